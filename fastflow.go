@@ -1,6 +1,7 @@
 package fastflow
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -16,6 +17,7 @@ import (
 	"github.com/weeyp/fastflow/pkg/actions"
 	"github.com/weeyp/fastflow/pkg/entity"
 	"github.com/weeyp/fastflow/pkg/entity/run"
+	"github.com/weeyp/fastflow/pkg/event"
 	"github.com/weeyp/fastflow/pkg/mod"
 	"github.com/weeyp/fastflow/pkg/utils"
 	"github.com/weeyp/fastflow/pkg/utils/data"
@@ -40,6 +42,15 @@ func GetAction(name string) (run.Action, bool) {
 // InitialOption used to initial fastflow
 type InitialOption struct {
 	Store mod.Store
+	// Keeper default is a keeper based on Store
+	Keeper mod.Keeper
+	// WorkerKey is used by default keeper, default is hostname
+	WorkerKey string
+	// WorkerLabels is used by default keeper, such as "zone=a,gpu=false"
+	// only the dag instances which selector matched will run at this worker
+	WorkerLabels string
+	// DispatchStrategy decide how leader assign dag instance to workers, default is round-robin
+	DispatchStrategy mod.DispatchStrategy
 
 	// ParserWorkersCnt default 100
 	ParserWorkersCnt int
@@ -47,8 +58,13 @@ type InitialOption struct {
 	ExecutorWorkerCnt int
 	// ExecutorTimeout default 30s
 	ExecutorTimeout time.Duration
+	// ExecutorPriorityAging is the waiting time for a queued task instance to gain one priority, default 10s
+	ExecutorPriorityAging time.Duration
 	// ExecutorTimeout default 15s
 	DagScheduleTimeout time.Duration
+	// Pools limit the task instances running at the same time, such as a pool for the actions which access database,
+	// the task uses the pool declared by its "pool" field, or the pool of its action
+	Pools []mod.PoolOption
 
 	// Read dag define from directory
 	// each file will be pared to a dag, so you CAN'T define all dag in one file
@@ -67,7 +83,7 @@ func Start(opt *InitialOption, afterInit ...func() error) error {
 	}
 
 	log.Println("fastflow start success")
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	sig := <-c
 	log.Println(fmt.Sprintf("get sig: %s, ready to close component", sig))
@@ -82,10 +98,14 @@ func Init(opt *InitialOption) error {
 		return err
 	}
 
-	initCommonComponent(opt)
+	if err := initCommonComponent(opt); err != nil {
+		return err
+	}
 
 	RegisterAction([]run.Action{
 		&actions.Waiting{},
+		&actions.Branch{},
+		&actions.SubDag{},
 	})
 
 	if opt.ReadDagFromDir != "" {
@@ -106,9 +126,69 @@ type LeaderChangedHandler struct {
 	opt *InitialOption
 
 	leaderCloser []mod.Closer
+	started      bool // the components which leader's components depend on are ready
+	closed       bool // fastflow is closing, leader's components should not be started again
 	mutex        sync.Mutex
 }
 
+// Topic
+func (l *LeaderChangedHandler) Topic() []string {
+	return []string{event.KeyLeaderChanged}
+}
+
+// Handle start leader's components when campaign success, and close them when lost leader,
+// the events are published asynchronously, so it follows the current state of keeper instead of the event
+func (l *LeaderChangedHandler) Handle(cxt context.Context, e goevent.Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sync()
+}
+
+// Start handle leader changes after the components are ready
+func (l *LeaderChangedHandler) Start() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.started = true
+	l.sync()
+}
+
+func (l *LeaderChangedHandler) sync() {
+	if !l.started || l.closed {
+		return
+	}
+
+	isLeader := mod.GetKeeper().IsLeader()
+	if isLeader && len(l.leaderCloser) == 0 {
+		sch := mod.NewDefScheduler()
+		sch.Init()
+		l.leaderCloser = append(l.leaderCloser, sch)
+
+		dis := mod.NewDefDispatcher(l.opt.DispatchStrategy)
+		dis.Init()
+		l.leaderCloser = append(l.leaderCloser, dis)
+		log.Println("leader initial")
+	}
+	if !isLeader && len(l.leaderCloser) > 0 {
+		l.closeLeaderComponents()
+		log.Println("leader closed")
+	}
+}
+
+// Close leader's components, and ignore the later leader changes
+func (l *LeaderChangedHandler) Close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	l.closeLeaderComponents()
+}
+
+func (l *LeaderChangedHandler) closeLeaderComponents() {
+	for i := range l.leaderCloser {
+		l.leaderCloser[i].Close()
+	}
+	l.leaderCloser = []mod.Closer{}
+}
+
 // Close all closer
 func Close() {
 	for i := range closers {
@@ -134,14 +214,53 @@ func checkOption(opt *InitialOption) error {
 	if opt.ParserWorkersCnt == 0 {
 		opt.ParserWorkersCnt = 100
 	}
+	if opt.Keeper == nil && opt.WorkerKey == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname as worker key failed: %w", err)
+		}
+		opt.WorkerKey = hostname
+	}
 	return nil
 }
 
-func initCommonComponent(opt *InitialOption) {
+func initCommonComponent(opt *InitialOption) error {
 	mod.SetStore(opt.Store)
 
+	// subscribe before keeper campaigns, the handler starts leader's components after all components are ready
+	leaderHandler := &LeaderChangedHandler{opt: opt}
+	if err := goevent.Subscribe(leaderHandler); err != nil {
+		return err
+	}
+
+	// keeper must init before parser, because parser need know whether it is leader
+	if opt.Keeper == nil {
+		labels, err := data.ParseLabels(opt.WorkerLabels)
+		if err != nil {
+			return fmt.Errorf("parse worker labels failed: %w", err)
+		}
+		keeper := mod.NewDefKeeper(&mod.KeeperOption{
+			WorkerKey: opt.WorkerKey,
+			Labels:    labels,
+		})
+		// keeper is registered before it publishes the first leader changed event
+		mod.SetKeeper(keeper)
+		if err := keeper.Init(); err != nil {
+			return fmt.Errorf("init keeper failed: %w", err)
+		}
+		opt.Keeper = keeper
+	}
+	mod.SetKeeper(opt.Keeper)
+	// keeper must close at first, so no leader changes happen when leader's components are closing,
+	// and leader's components must be closed before other components
+	closers = append(closers, opt.Keeper, leaderHandler)
+
 	// Executor must init before parse otherwise will cause a error
 	exe := mod.NewDefExecutor(opt.ExecutorTimeout, opt.ExecutorWorkerCnt)
+	if err := exe.SetPools(opt.Pools); err != nil {
+		return fmt.Errorf("set executor pools failed: %w", err)
+	}
+	exe.SetPriorityAging(opt.ExecutorPriorityAging)
 	mod.SetExecutor(exe)
 	p := mod.NewDefParser(opt.ParserWorkersCnt, opt.ExecutorTimeout)
 	mod.SetParser(p)
@@ -153,9 +272,11 @@ func initCommonComponent(opt *InitialOption) {
 
 	comm := &mod.DefCommander{}
 	mod.SetCommander(comm)
+	leaderHandler.Start()
 
-	// keeper and store must close latest
+	// store must close latest
 	closers = append(closers, opt.Store)
+	return nil
 }
 
 func readDagFromDir(dir string) error {
@@ -190,6 +311,10 @@ func readDagFromDir(dir string) error {
 }
 
 func ensureDagLatest(dag *entity.Dag) error {
+	if err := dag.Validate(); err != nil {
+		return fmt.Errorf("dag[%s] is invalid: %w", dag.ID, err)
+	}
+
 	oDag, err := mod.GetStore().GetDag(dag.ID)
 	if err != nil && !errors.Is(err, data.ErrDataNotFound) {
 		return err
@@ -7,6 +7,7 @@ require (
 	github.com/golang/mock v1.6.0
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/shiningrush/goevent v0.1.0
 	github.com/sony/sonyflake v1.1.0
 	github.com/spaolacci/murmur3 v1.1.0
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"fmt"
+
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/entity/run"
+)
+
+const (
+	ActionKeyBranch = "ff-branch"
+)
+
+// BranchParams
+type BranchParams struct {
+	// Source could be "vars" or "share-data"
+	Source entity.TaskConditionSource `json:"source"`
+	// Key is used to get the value from source
+	Key string `json:"key"`
+	// Branches map the value to the downstream task ids which will be followed
+	Branches map[string][]string `json:"branches"`
+	// Default is the downstream task ids which will be followed when no branch matches the value
+	Default []string `json:"default"`
+}
+
+// Branch action choose the downstream tasks according to the value of vars or share data,
+// the downstream tasks which are not chosen will be skipped
+type Branch struct {
+}
+
+// Name
+func (b *Branch) Name() string {
+	return ActionKeyBranch
+}
+
+// ParameterNew
+func (b *Branch) ParameterNew() interface{} {
+	return &BranchParams{}
+}
+
+// Run
+func (b *Branch) Run(ctx run.ExecuteContext, params interface{}) error {
+	p, ok := params.(*BranchParams)
+	if !ok || p == nil {
+		return fmt.Errorf("branch params is required")
+	}
+
+	var (
+		val   string
+		found bool
+	)
+	switch p.Source {
+	case entity.TaskConditionSourceVars:
+		val, found = ctx.GetVar(p.Key)
+	case entity.TaskConditionSourceShareData:
+		val, found = ctx.ShareData().Get(p.Key)
+	default:
+		return fmt.Errorf("branch source %s is not valid", p.Source)
+	}
+
+	taskIds, ok := p.Branches[val]
+	if !found || !ok {
+		taskIds = p.Default
+	}
+	ctx.Tracef("value of %s is %q, choose branches: %v", p.Key, val, taskIds)
+	ctx.Branch(taskIds...)
+	return nil
+}
@@ -0,0 +1,169 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/entity/run"
+	"github.com/weeyp/fastflow/pkg/log"
+	"github.com/weeyp/fastflow/pkg/mod"
+	"github.com/weeyp/fastflow/pkg/utils"
+	"github.com/weeyp/fastflow/pkg/utils/data"
+)
+
+const (
+	ActionKeySubDag = "ff-sub-dag"
+
+	// MaxSubDagDepth is the max depth of nested sub dag instances, it stops the cycle
+	// which is not found when the dag is saved, such as the dag id is rendered from variables
+	MaxSubDagDepth = 10
+)
+
+// SubDagParams
+type SubDagParams struct {
+	// DagID is the id of dag which will be run as sub dag
+	DagID string `json:"dagId"`
+	// Vars will be passed to the sub dag instance, such as {"fileName": "{{fileName}}"}
+	Vars map[string]string `json:"vars"`
+}
+
+// SubDag action start a dag instance of another dag and wait until it completed,
+// the sub dag instance will be canceled when the task is canceled
+type SubDag struct {
+	// interval of checking the status of sub dag instance, default 1s
+	interval time.Duration
+}
+
+// Name
+func (s *SubDag) Name() string {
+	return ActionKeySubDag
+}
+
+// ParameterNew
+func (s *SubDag) ParameterNew() interface{} {
+	return &SubDagParams{}
+}
+
+// SubDagIDs
+func (s *SubDag) SubDagIDs(params interface{}) []string {
+	p, ok := params.(*SubDagParams)
+	if !ok || p == nil || p.DagID == "" || strings.Contains(p.DagID, "{{") {
+		return nil
+	}
+	return []string{p.DagID}
+}
+
+// Run
+func (s *SubDag) Run(ctx run.ExecuteContext, params interface{}) error {
+	p, ok := params.(*SubDagParams)
+	if !ok || p == nil || p.DagID == "" {
+		return fmt.Errorf("dag id of sub dag is required")
+	}
+	taskIns, ok := mod.CtxRunningTaskIns(ctx.Context())
+	if !ok {
+		return fmt.Errorf("running task instance is not found in context")
+	}
+
+	subDagIns, err := s.ensureSubDagIns(ctx, taskIns, p)
+	if err != nil {
+		return err
+	}
+
+	lastStatus := subDagIns.Status
+	err = run.LoopDo(ctx, func() error {
+		ins, err := mod.GetStore().GetDagInstance(subDagIns.ID)
+		if err != nil {
+			return err
+		}
+		if ins.Status == entity.DagInstanceStatusSuccess {
+			return run.EndLoop
+		}
+		// blocked instance never completes by itself, so it fails the task instead of waiting until timeout
+		if isSubDagInsStopped(ins) {
+			return fmt.Errorf("sub dag instance[%s] %s: %s", ins.ID, ins.Status, ins.Reason)
+		}
+		if ins.Status == entity.DagInstanceStatusPaused && lastStatus != entity.DagInstanceStatusPaused {
+			ctx.Tracef("sub dag instance[%s] is paused, wait for it to be resumed", ins.ID)
+		}
+		lastStatus = ins.Status
+		return nil
+	}, run.LoopInterval(s.interval))
+	if err != nil && ctx.Context().Err() != nil {
+		s.cancelSubDagIns(subDagIns.ID)
+	}
+	return err
+}
+
+// ensureSubDagIns return the sub dag instance started by the task instance before,
+// or start a new one if there is no sub dag instance or the previous one failed, canceled or blocked
+func (s *SubDag) ensureSubDagIns(ctx run.ExecuteContext, taskIns *entity.TaskInstance, p *SubDagParams) (*entity.DagInstance, error) {
+	existed, err := mod.GetStore().ListDagInstance(&mod.ListDagInstanceInput{
+		ParentTaskInsID: taskIns.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, ins := range existed {
+		if !isSubDagInsStopped(ins) {
+			ctx.Tracef("continue to wait sub dag instance[%s]", ins.ID)
+			return ins, nil
+		}
+	}
+
+	if err := checkSubDagDepth(taskIns.DagInsID); err != nil {
+		return nil, err
+	}
+	dag, err := mod.GetStore().GetDag(p.DagID)
+	if err != nil {
+		return nil, fmt.Errorf("get sub dag[%s] failed: %w", p.DagID, err)
+	}
+	subDagIns, err := dag.Run(entity.TriggerSubDag, p.Vars)
+	if err != nil {
+		return nil, err
+	}
+	subDagIns.ParentDagInsID = taskIns.DagInsID
+	subDagIns.ParentTaskInsID = taskIns.ID
+	if err := mod.GetStore().CreateDagIns(subDagIns); err != nil {
+		return nil, err
+	}
+	ctx.Tracef("start sub dag instance[%s]", subDagIns.ID)
+	return subDagIns, nil
+}
+
+// checkSubDagDepth return error if the dag instance is nested in MaxSubDagDepth sub dag instances
+func checkSubDagDepth(dagInsId string) error {
+	for depth := 0; dagInsId != ""; depth++ {
+		if depth >= MaxSubDagDepth {
+			return fmt.Errorf("sub dag instances are nested more than %d levels", MaxSubDagDepth)
+		}
+		ins, err := mod.GetStore().GetDagInstance(dagInsId)
+		if errors.Is(err, data.ErrDataNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("get dag instance[%s] failed: %w", dagInsId, err)
+		}
+		dagInsId = ins.ParentDagInsID
+	}
+	return nil
+}
+
+// isSubDagInsStopped check whether the sub dag instance is stopped without success
+func isSubDagInsStopped(ins *entity.DagInstance) bool {
+	switch ins.Status {
+	case entity.DagInstanceStatusFailed, entity.DagInstanceStatusCanceled, entity.DagInstanceStatusBlocked:
+		return true
+	}
+	return false
+}
+
+func (s *SubDag) cancelSubDagIns(dagInsId string) {
+	if err := mod.GetCommander().CancelDagIns(dagInsId, "parent task is canceled"); err != nil {
+		log.Error("cancel sub dag instance failed",
+			utils.LogKeyDagInsID, dagInsId,
+			"err", err)
+	}
+}
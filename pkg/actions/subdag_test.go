@@ -0,0 +1,219 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/entity/run"
+	"github.com/weeyp/fastflow/pkg/mod"
+	"github.com/weeyp/fastflow/store/cache"
+)
+
+func TestSubDag_Run(t *testing.T) {
+	tests := []struct {
+		caseDesc   string
+		giveStatus entity.DagInstanceStatus
+		giveCancel bool
+		wantErr    string
+		wantStatus entity.DagInstanceStatus
+	}{
+		{
+			caseDesc:   "success",
+			giveStatus: entity.DagInstanceStatusSuccess,
+			wantStatus: entity.DagInstanceStatusSuccess,
+		},
+		{
+			caseDesc:   "failed",
+			giveStatus: entity.DagInstanceStatusFailed,
+			wantErr:    "failed: child failed",
+			wantStatus: entity.DagInstanceStatusFailed,
+		},
+		{
+			caseDesc:   "blocked",
+			giveStatus: entity.DagInstanceStatusBlocked,
+			wantErr:    "blocked: child blocked",
+			wantStatus: entity.DagInstanceStatusBlocked,
+		},
+		{
+			caseDesc:   "parent canceled",
+			giveCancel: true,
+			wantErr:    context.Canceled.Error(),
+			wantStatus: entity.DagInstanceStatusCanceled,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			store := cache.NewMemCache()
+			mod.SetStore(store)
+			mod.SetCommander(&mod.DefCommander{})
+			require.NoError(t, store.CreateDag(&entity.Dag{
+				ID:     "child",
+				Status: entity.DagStatusNormal,
+				Tasks:  []entity.Task{{ID: "task", ActionName: "action"}},
+			}))
+
+			taskIns := &entity.TaskInstance{ID: "parent-task", DagInsID: "parent"}
+			ctx, cancel := context.WithCancel(context.WithValue(context.Background(), mod.CtxKeyRunningTaskIns, taskIns))
+			defer cancel()
+			exeCtx := run.NewDefExecuteContext(ctx, nil, func(msg string, opt ...run.TraceOp) {}, nil, nil)
+
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- (&SubDag{interval: 10 * time.Millisecond}).Run(exeCtx, &SubDagParams{DagID: "child"})
+			}()
+
+			var child *entity.DagInstance
+			require.Eventually(t, func() bool {
+				ins, err := store.ListDagInstance(&mod.ListDagInstanceInput{ParentTaskInsID: taskIns.ID})
+				if err != nil || len(ins) == 0 {
+					return false
+				}
+				child = ins[0]
+				return true
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, "parent", child.ParentDagInsID)
+
+			if tc.giveCancel {
+				cancel()
+			} else {
+				// update a copy, the stored instance is being read by action
+				updated := *child
+				updated.Status = tc.giveStatus
+				updated.Reason = "child " + string(tc.giveStatus)
+				require.NoError(t, store.UpdateDagIns(&updated))
+			}
+
+			select {
+			case err := <-errCh:
+				if tc.wantErr == "" {
+					assert.NoError(t, err)
+				} else {
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), tc.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("sub dag action is not completed")
+			}
+			got, err := store.GetDagInstance(child.ID)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantStatus, got.Status)
+		})
+	}
+}
+
+func TestSubDag_RestartStoppedIns(t *testing.T) {
+	store := cache.NewMemCache()
+	mod.SetStore(store)
+	require.NoError(t, store.CreateDag(&entity.Dag{
+		ID:     "child",
+		Status: entity.DagStatusNormal,
+		Tasks:  []entity.Task{{ID: "task", ActionName: "action"}},
+	}))
+	taskIns := &entity.TaskInstance{ID: "parent-task", DagInsID: "parent"}
+	blocked := &entity.DagInstance{DagID: "child", ParentTaskInsID: taskIns.ID, Status: entity.DagInstanceStatusBlocked}
+	require.NoError(t, store.CreateDagIns(blocked))
+
+	exeCtx := run.NewDefExecuteContext(context.Background(), nil, func(msg string, opt ...run.TraceOp) {}, nil, nil)
+	ins, err := (&SubDag{}).ensureSubDagIns(exeCtx, taskIns, &SubDagParams{DagID: "child"})
+	require.NoError(t, err)
+	assert.NotEqual(t, blocked.ID, ins.ID)
+	assert.Equal(t, entity.DagInstanceStatusInit, ins.Status)
+
+	// the running instance is continued
+	again, err := (&SubDag{}).ensureSubDagIns(exeCtx, taskIns, &SubDagParams{DagID: "child"})
+	require.NoError(t, err)
+	assert.Equal(t, ins.ID, again.ID)
+}
+
+func TestSubDag_CheckSubDagCycle(t *testing.T) {
+	mod.ActionMap[ActionKeySubDag] = &SubDag{}
+	defer delete(mod.ActionMap, ActionKeySubDag)
+
+	subDag := func(id, dagId string) *entity.Dag {
+		return &entity.Dag{
+			ID:     id,
+			Status: entity.DagStatusNormal,
+			Tasks: []entity.Task{{
+				ID:         "task",
+				ActionName: ActionKeySubDag,
+				Params:     map[string]interface{}{"dagId": dagId},
+			}},
+		}
+	}
+	tests := []struct {
+		caseDesc string
+		giveDags []*entity.Dag
+		giveDag  *entity.Dag
+		wantErr  string
+	}{
+		{
+			caseDesc: "run itself",
+			giveDag:  subDag("a", "a"),
+			wantErr:  "a -> a",
+		},
+		{
+			caseDesc: "run itself through other dags",
+			giveDags: []*entity.Dag{subDag("b", "c"), subDag("c", "a")},
+			giveDag:  subDag("a", "b"),
+			wantErr:  "a -> b -> c -> a",
+		},
+		{
+			caseDesc: "no cycle",
+			giveDags: []*entity.Dag{subDag("b", "c"), subDag("c", "d")},
+			giveDag:  subDag("a", "b"),
+		},
+		{
+			caseDesc: "sub dag not created",
+			giveDag:  subDag("a", "b"),
+		},
+		{
+			caseDesc: "rendered dag id",
+			giveDag:  subDag("a", "{{dagId}}"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			store := cache.NewMemCache()
+			mod.SetStore(store)
+			for _, dag := range tc.giveDags {
+				require.NoError(t, store.CreateDag(dag))
+			}
+
+			err := store.CreateDag(tc.giveDag)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, mod.ErrSubDagCycle))
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+}
+
+func TestSubDag_MaxDepth(t *testing.T) {
+	store := cache.NewMemCache()
+	mod.SetStore(store)
+	require.NoError(t, store.CreateDag(&entity.Dag{
+		ID:     "child",
+		Status: entity.DagStatusNormal,
+		Tasks:  []entity.Task{{ID: "task", ActionName: "action"}},
+	}))
+	parentId := ""
+	for i := 0; i <= MaxSubDagDepth; i++ {
+		ins := &entity.DagInstance{DagID: "child", ParentDagInsID: parentId, Status: entity.DagInstanceStatusRunning}
+		require.NoError(t, store.CreateDagIns(ins))
+		parentId = ins.ID
+	}
+
+	exeCtx := run.NewDefExecuteContext(context.Background(), nil, func(msg string, opt ...run.TraceOp) {}, nil, nil)
+	taskIns := &entity.TaskInstance{ID: "parent-task", DagInsID: parentId}
+	_, err := (&SubDag{}).ensureSubDagIns(exeCtx, taskIns, &SubDagParams{DagID: "child"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nested more than")
+}
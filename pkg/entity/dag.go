@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/weeyp/fastflow/pkg/expr"
 	"github.com/weeyp/fastflow/pkg/log"
 	"github.com/weeyp/fastflow/pkg/utils"
+	"github.com/weeyp/fastflow/pkg/utils/data"
 	"github.com/weeyp/fastflow/pkg/utils/value"
 )
 
@@ -27,6 +30,23 @@ type Dag struct {
 	Vars   DagVars   `yaml:"vars,omitempty" json:"vars,omitempty" bson:"vars,omitempty"`
 	Status DagStatus `yaml:"status,omitempty" json:"status,omitempty" bson:"status,omitempty"`
 	Tasks  []Task    `yaml:"tasks,omitempty" json:"tasks,omitempty" bson:"tasks,omitempty"`
+
+	// CatchUp decide how to handle the cron schedules missed when application is down
+	CatchUp CatchUpPolicy `yaml:"catchUp,omitempty" json:"catchUp,omitempty" bson:"catchUp,omitempty"`
+	// Selector decide which workers can run the dag instances, such as "zone=a,gpu in (true)"
+	Selector string `yaml:"selector,omitempty" json:"selector,omitempty" bson:"selector,omitempty"`
+	// MaxActiveInstances limits the number of instances which are dispatched and not completed, 0 means no limit
+	MaxActiveInstances int `yaml:"maxActiveInstances,omitempty" json:"maxActiveInstances,omitempty" bson:"maxActiveInstances,omitempty"`
+	// MaxActivePolicy decide how to handle the instances exceeding MaxActiveInstances, default is "queue"
+	MaxActivePolicy MaxActivePolicy `yaml:"maxActivePolicy,omitempty" json:"maxActivePolicy,omitempty" bson:"maxActivePolicy,omitempty"`
+	// Priority is added to the priorities of its tasks, the higher one is executed first when executor is busy
+	Priority int `yaml:"priority,omitempty" json:"priority,omitempty" bson:"priority,omitempty"`
+	// Timeout is the max running duration of instance such as "1h", the remaining tasks are canceled
+	// and the instance is failed when it is exceeded, empty means no limit
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty" bson:"timeout,omitempty"`
+	// SLA is the expected running duration of instance such as "30m", the instance keeps running when it is exceeded,
+	// but "SLAMissed" event is published and "BeforeSLAMissed" hook is called
+	SLA string `yaml:"sla,omitempty" json:"sla,omitempty" bson:"sla,omitempty"`
 }
 
 // Run used to build a new DagInstance, then you also need save it to Store
@@ -46,15 +66,152 @@ func (d *Dag) Run(trigger Trigger, specVars map[string]string) (*DagInstance, er
 		}
 	}
 
+	if err := d.validateTasks(); err != nil {
+		return nil, err
+	}
+	selector, err := d.buildSelector()
+	if err != nil {
+		return nil, err
+	}
+
 	return &DagInstance{
 		DagID:     d.ID,
 		Trigger:   trigger,
 		Vars:      dagInsVars,
 		ShareData: &ShareData{},
 		Status:    DagInstanceStatusInit,
+		Selector:  selector,
+		Priority:  d.Priority,
+		Timeout:   d.Timeout,
+		SLA:       d.SLA,
+		CreatedAt: time.Now().UnixMilli(),
 	}, nil
 }
 
+// Validate return error if the dag is invalid, it should be called when the dag is loaded
+func (d *Dag) Validate() error {
+	if err := d.validateTasks(); err != nil {
+		return err
+	}
+	if _, ok := d.Vars[VarKeyScheduleTime]; ok {
+		return fmt.Errorf("var[%s] is reserved for the logical schedule time", VarKeyScheduleTime)
+	}
+	if d.MaxActiveInstances < 0 {
+		return fmt.Errorf("max active instances can not be negative")
+	}
+	switch d.CatchUp {
+	case "", CatchUpPolicySkip, CatchUpPolicyLatest, CatchUpPolicyAll:
+	default:
+		return fmt.Errorf("catch up policy[%s] is invalid", d.CatchUp)
+	}
+	switch d.MaxActivePolicy {
+	case "", MaxActivePolicyQueue, MaxActivePolicySkip, MaxActivePolicyCancelOldest:
+	default:
+		return fmt.Errorf("max active policy[%s] is invalid", d.MaxActivePolicy)
+	}
+	if timeout, err := parseDelay(d.Timeout); err != nil || timeout < 0 {
+		return fmt.Errorf("timeout[%s] is invalid", d.Timeout)
+	}
+	if sla, err := parseDelay(d.SLA); err != nil || sla < 0 {
+		return fmt.Errorf("sla[%s] is invalid", d.SLA)
+	}
+	_, err := d.buildSelector()
+	return err
+}
+
+func (d *Dag) validateTasks() error {
+	for i := range d.Tasks {
+		if err := d.Tasks[i].Validate(); err != nil {
+			return fmt.Errorf("task[%s] is invalid: %w", d.Tasks[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// buildSelector merge the selectors of dag and its tasks,
+// because all tasks of a dag instance are executed at the same worker
+func (d *Dag) buildSelector() (string, error) {
+	var selectors []string
+	if d.Selector != "" {
+		selectors = append(selectors, d.Selector)
+	}
+	for i := range d.Tasks {
+		if d.Tasks[i].Selector != "" {
+			selectors = append(selectors, d.Tasks[i].Selector)
+		}
+	}
+	if len(selectors) == 0 {
+		return "", nil
+	}
+
+	selector := strings.Join(selectors, ",")
+	if _, err := data.PareSelectors(selector); err != nil {
+		return "", fmt.Errorf("dag[%s] has invalid selector: %w", d.ID, err)
+	}
+	return selector, nil
+}
+
+// RunWithScheduleTime is like Run, but it records the logical schedule time of the instance
+// and inject it as a built-in var, so you can use it in task's params such as "{{scheduleTime}}"
+func (d *Dag) RunWithScheduleTime(trigger Trigger, specVars map[string]string, scheduleTime time.Time) (*DagInstance, error) {
+	// the var defined by user should not be overwritten silently
+	if _, ok := d.Vars[VarKeyScheduleTime]; ok {
+		return nil, fmt.Errorf("var[%s] is reserved for the logical schedule time", VarKeyScheduleTime)
+	}
+	dagIns, err := d.Run(trigger, specVars)
+	if err != nil {
+		return nil, err
+	}
+
+	dagIns.ScheduleTime = scheduleTime.Unix()
+	dagIns.Vars[VarKeyScheduleTime] = DagInstanceVar{
+		Value: scheduleTime.Format(time.RFC3339),
+	}
+	return dagIns, nil
+}
+
+// RunAt is like RunWithScheduleTime, but the instance is scheduled,
+// it will be promoted to init by parser when the schedule time comes
+func (d *Dag) RunAt(trigger Trigger, specVars map[string]string, at time.Time) (*DagInstance, error) {
+	dagIns, err := d.RunWithScheduleTime(trigger, specVars, at)
+	if err != nil {
+		return nil, err
+	}
+
+	dagIns.Status = DagInstanceStatusScheduled
+	return dagIns, nil
+}
+
+// CatchUpPolicy used to define how to handle missed cron schedules
+type CatchUpPolicy string
+
+const (
+	// CatchUpPolicySkip ignore all missed schedules, it is the default behavior
+	CatchUpPolicySkip CatchUpPolicy = "skip"
+	// CatchUpPolicyLatest only run the latest missed schedule
+	CatchUpPolicyLatest CatchUpPolicy = "latest"
+	// CatchUpPolicyAll run every missed schedule
+	CatchUpPolicyAll CatchUpPolicy = "all"
+)
+
+// MaxActivePolicy used to define how to handle the instances exceeding the max active instances of dag
+type MaxActivePolicy string
+
+const (
+	// MaxActivePolicyQueue keep the instance in init until a slot frees up, it is the default behavior
+	MaxActivePolicyQueue MaxActivePolicy = "queue"
+	// MaxActivePolicySkip cancel the instance without running it
+	MaxActivePolicySkip MaxActivePolicy = "skip"
+	// MaxActivePolicyCancelOldest cancel the oldest active instance, and run the instance after it is canceled
+	MaxActivePolicyCancelOldest MaxActivePolicy = "cancel-oldest"
+)
+
+const (
+	// VarKeyScheduleTime is the built-in var of logical schedule time, it is formatted as RFC3339,
+	// dag can not define a var with the same key
+	VarKeyScheduleTime = "scheduleTime"
+)
+
 type DagVars map[string]DagVar
 
 // DagVar used to define a dag var
@@ -86,7 +243,30 @@ type DagInstance struct {
 	ShareData *ShareData        `json:"shareData,omitempty" bson:"shareData,omitempty"`
 	Status    DagInstanceStatus `json:"status,omitempty" bson:"status,omitempty"`
 	Reason    string            `json:"reason,omitempty" bson:"reason,omitempty"`
-	Cmd       *Command          `json:"cmd,omitempty" bson:"cmd,omitempty"`
+	// Cmd is the command which is being created or executed, commands are queued in store rather than dag instance,
+	// it is persisted only by the earlier versions, and Parser moves such command to the queue
+	Cmd *Command `json:"cmd,omitempty" bson:"cmd,omitempty"`
+
+	// ScheduleTime is the logical time(unix seconds) of cron or backfill schedule
+	ScheduleTime int64 `json:"scheduleTime,omitempty" bson:"scheduleTime,omitempty"`
+	// Selector decide which workers can run the instance, it is merged from dag and tasks
+	Selector string `json:"selector,omitempty" bson:"selector,omitempty"`
+	// ParentDagInsID is the id of dag instance which starts this instance as a sub dag
+	ParentDagInsID string `json:"parentDagInsId,omitempty" bson:"parentDagInsId,omitempty"`
+	// ParentTaskInsID is the id of task instance which starts this instance as a sub dag
+	ParentTaskInsID string `json:"parentTaskInsId,omitempty" bson:"parentTaskInsId,omitempty"`
+	// Priority is copied from dag when the instance is created
+	Priority int `json:"priority,omitempty" bson:"priority,omitempty"`
+	// Timeout and SLA are copied from dag when the instance is created, they are measured from StartedAt
+	Timeout string `json:"timeout,omitempty" bson:"timeout,omitempty"`
+	SLA     string `json:"sla,omitempty" bson:"sla,omitempty"`
+	// StartedAt(unix seconds) is the time when the instance starts running,
+	// it is reset when the completed or blocked instance runs again
+	StartedAt int64 `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	// SLAMissedAt(unix seconds) is the time when the instance is found missing its sla
+	SLAMissedAt int64 `json:"slaMissedAt,omitempty" bson:"slaMissedAt,omitempty"`
+	// CreatedAt(unix milliseconds) decides the order of instances when they are dispatched or canceled for concurrency
+	CreatedAt int64 `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
 }
 
 // ShareData can read/write within all tasks and will persist it
@@ -128,6 +308,9 @@ func (d *ShareData) Get(key string) (string, bool) {
 func (d *ShareData) Set(key string, val string) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
+	if d.Dict == nil {
+		d.Dict = map[string]string{}
+	}
 	d.Dict[key] = val
 	if d.Save != nil {
 		if err := d.Save(d); err != nil {
@@ -145,11 +328,9 @@ type DagInstanceVars map[string]DagInstanceVar
 
 // Cancel a task, it is just set a command, command will execute by Parser
 func (dagIns *DagInstance) Cancel(taskInsIds []string) error {
-	if dagIns.Status != DagInstanceStatusRunning {
-		return fmt.Errorf("you can only cancel a running dag instance")
-	}
-	if dagIns.Cmd != nil {
-		return fmt.Errorf("dag instance have a incomplete command")
+	// the running tasks of paused dag instance can be canceled too
+	if dagIns.Status != DagInstanceStatusRunning && dagIns.Status != DagInstanceStatusPaused {
+		return fmt.Errorf("you can only cancel a running or paused dag instance")
 	}
 	dagIns.Cmd = &Command{
 		Name:             CommandNameCancel,
@@ -171,6 +352,23 @@ type DagInstanceLifecycleHook struct {
 	BeforeFail    DagInstanceHookFunc
 	BeforeBlock   DagInstanceHookFunc
 	BeforeRetry   DagInstanceHookFunc
+	BeforePause   DagInstanceHookFunc
+	BeforeResume  DagInstanceHookFunc
+	BeforeCancel  DagInstanceHookFunc
+	// BeforeSLAMissed is called when the running instance exceeds its sla, it is called once in each run
+	BeforeSLAMissed DagInstanceHookFunc
+}
+
+// MatchLabels return if the worker's labels meet the selector of dag instance
+func (dagIns *DagInstance) MatchLabels(labels map[string]string) (bool, error) {
+	if dagIns.Selector == "" {
+		return true, nil
+	}
+	selectors, err := data.PareSelectors(dagIns.Selector)
+	if err != nil {
+		return false, err
+	}
+	return data.MatchSelectors(selectors, labels), nil
 }
 
 // VarsGetter used to get a var value by key
@@ -192,9 +390,41 @@ func (dagIns *DagInstance) VarsIterator() utils.KeyValueIterator {
 	}
 }
 
+// ExprEnv build the env of expressions, it contains "vars", "shareData" and "tasks",
+// tasks is used to get the status and output of tasks, such as "tasks.taskId.output.field"
+func (dagIns *DagInstance) ExprEnv(tasks TaskInsGetter) expr.Env {
+	return expr.Env{
+		"vars": expr.GetterFunc(func(key string) (interface{}, bool) {
+			return dagIns.VarsGetter()(key)
+		}),
+		"shareData": expr.GetterFunc(func(key string) (interface{}, bool) {
+			if dagIns.ShareData == nil {
+				return nil, false
+			}
+			return dagIns.ShareData.Get(key)
+		}),
+		"tasks": expr.GetterFunc(func(key string) (interface{}, bool) {
+			if tasks == nil {
+				return nil, false
+			}
+			t, ok := tasks(key)
+			if !ok {
+				return nil, false
+			}
+			return map[string]interface{}{
+				"status": string(t.Status),
+				"output": t.Output,
+			}, true
+		}),
+	}
+}
+
 // Run executeHook execute a hook
 func (dagIns *DagInstance) Run() {
 	dagIns.executeHook(HookDagInstance.BeforeRun)
+	if dagIns.StartedAt == 0 || dagIns.CanRerun() {
+		dagIns.StartedAt = time.Now().Unix()
+	}
 	dagIns.Status = DagInstanceStatusRunning
 	dagIns.Reason = ""
 }
@@ -219,12 +449,15 @@ func (dagIns *DagInstance) Block(reason string) {
 	dagIns.Status = DagInstanceStatusBlocked
 }
 
+// MarkCanceled the dag instance
+func (dagIns *DagInstance) MarkCanceled(reason string) {
+	dagIns.Reason = reason
+	dagIns.executeHook(HookDagInstance.BeforeCancel)
+	dagIns.Status = DagInstanceStatusCanceled
+}
+
 // Retry a task, it is just set a command, command will execute by Parser
 func (dagIns *DagInstance) Retry(taskInsIds []string) error {
-	if dagIns.Cmd != nil {
-		return fmt.Errorf("dag instance have a incomplete command")
-	}
-
 	dagIns.executeHook(HookDagInstance.BeforeRetry)
 	dagIns.Cmd = &Command{
 		Name:             CommandNameRetry,
@@ -233,6 +466,162 @@ func (dagIns *DagInstance) Retry(taskInsIds []string) error {
 	return nil
 }
 
+// Rerun the tasks of completed dag instance, it is just set a command, command will execute by Parser
+func (dagIns *DagInstance) Rerun(taskInsIds []string) error {
+	if !dagIns.CanRerun() {
+		return fmt.Errorf("only completed or blocked dag instance can be rerun, current status is %s", dagIns.Status)
+	}
+
+	dagIns.Cmd = &Command{
+		Name:             CommandNameRerun,
+		TargetTaskInsIDs: taskInsIds,
+	}
+	return nil
+}
+
+// CanRerun return if the tasks of dag instance can be rerun, it should not have any running task
+func (dagIns *DagInstance) CanRerun() bool {
+	return dagIns.IsCompleted() || dagIns.Status == DagInstanceStatusBlocked
+}
+
+// SkipTasks force the tasks to be skipped, it is just set a command, command will execute by Parser
+func (dagIns *DagInstance) SkipTasks(taskInsIds []string, issuer, reason string) error {
+	return dagIns.forceTasks(CommandNameSkip, taskInsIds, issuer, reason)
+}
+
+// MarkTasksSuccess force the tasks to be success, it is just set a command, command will execute by Parser
+func (dagIns *DagInstance) MarkTasksSuccess(taskInsIds []string, issuer, reason string) error {
+	return dagIns.forceTasks(CommandNameMarkSuccess, taskInsIds, issuer, reason)
+}
+
+func (dagIns *DagInstance) forceTasks(name CommandName, taskInsIds []string, issuer, reason string) error {
+	if !dagIns.CanForceTasks() {
+		return fmt.Errorf("the tasks of %s dag instance can not be forced", dagIns.Status)
+	}
+
+	dagIns.Cmd = &Command{
+		Name:             name,
+		TargetTaskInsIDs: taskInsIds,
+		Reason:           reason,
+		Issuer:           issuer,
+	}
+	return nil
+}
+
+// CanForceTasks return if the tasks of dag instance can be forced to skipped or success,
+// the failed or blocked instance will continue after the tasks are forced
+func (dagIns *DagInstance) CanForceTasks() bool {
+	switch dagIns.Status {
+	case DagInstanceStatusRunning, DagInstanceStatusPaused, DagInstanceStatusFailed, DagInstanceStatusBlocked:
+		return true
+	}
+	return false
+}
+
+// CancelAll cancel the dag instance and all of its tasks, it is just set a command, command will execute by Parser
+func (dagIns *DagInstance) CancelAll(reason string) error {
+	if dagIns.IsCompleted() {
+		return fmt.Errorf("dag instance is already completed, status is %s", dagIns.Status)
+	}
+
+	dagIns.Cmd = &Command{
+		Name:   CommandNameCancelDagIns,
+		Reason: reason,
+	}
+	return nil
+}
+
+// TimeoutAll cancel all tasks and fail the dag instance because it exceeds its timeout,
+// it is just set a command, command will execute by Parser
+func (dagIns *DagInstance) TimeoutAll(reason string) error {
+	if dagIns.IsCompleted() {
+		return fmt.Errorf("dag instance is already completed, status is %s", dagIns.Status)
+	}
+
+	dagIns.Cmd = &Command{
+		Name:   CommandNameTimeoutDagIns,
+		Reason: reason,
+	}
+	return nil
+}
+
+// IsDue return if the scheduled dag instance reaches its schedule time
+func (dagIns *DagInstance) IsDue(now time.Time) bool {
+	return dagIns.Status == DagInstanceStatusScheduled && dagIns.ScheduleTime <= now.Unix()
+}
+
+// IsTimeout return if the dag instance exceeds its timeout
+func (dagIns *DagInstance) IsTimeout(now time.Time) bool {
+	return dagIns.exceed(dagIns.Timeout, now)
+}
+
+// IsSLAMissed return if the dag instance exceeds its sla, and it is not marked in current run
+func (dagIns *DagInstance) IsSLAMissed(now time.Time) bool {
+	return dagIns.SLAMissedAt < dagIns.StartedAt && dagIns.exceed(dagIns.SLA, now)
+}
+
+// MarkSLAMissed mark the dag instance missing its sla
+func (dagIns *DagInstance) MarkSLAMissed(now time.Time) {
+	dagIns.executeHook(HookDagInstance.BeforeSLAMissed)
+	dagIns.SLAMissedAt = now.Unix()
+}
+
+func (dagIns *DagInstance) exceed(duration string, now time.Time) bool {
+	if dagIns.StartedAt == 0 {
+		return false
+	}
+	d, err := parseDelay(duration)
+	if err != nil || d <= 0 {
+		return false
+	}
+	return now.Sub(time.Unix(dagIns.StartedAt, 0)) > d
+}
+
+// IsCompleted return if the dag instance is in a terminal status
+func (dagIns *DagInstance) IsCompleted() bool {
+	switch dagIns.Status {
+	case DagInstanceStatusSuccess, DagInstanceStatusFailed, DagInstanceStatusCanceled:
+		return true
+	}
+	return false
+}
+
+// Pause the dag instance, it is just set a command, command will execute by Parser
+func (dagIns *DagInstance) Pause() error {
+	if dagIns.Status != DagInstanceStatusRunning {
+		return fmt.Errorf("only running dag instance can be paused, current status is %s", dagIns.Status)
+	}
+
+	dagIns.Cmd = &Command{
+		Name: CommandNamePause,
+	}
+	return nil
+}
+
+// MarkPaused the dag instance, it is called by Parser when the pause command is executed
+func (dagIns *DagInstance) MarkPaused() {
+	dagIns.executeHook(HookDagInstance.BeforePause)
+	dagIns.Status = DagInstanceStatusPaused
+}
+
+// Resume the paused dag instance, it is just set a command, command will execute by Parser
+func (dagIns *DagInstance) Resume() error {
+	if dagIns.Status != DagInstanceStatusPaused {
+		return fmt.Errorf("only paused dag instance can be resumed, current status is %s", dagIns.Status)
+	}
+
+	dagIns.Cmd = &Command{
+		Name: CommandNameResume,
+	}
+	return nil
+}
+
+// MarkResumed the dag instance, it is called by Parser when the resume command is executed
+func (dagIns *DagInstance) MarkResumed() {
+	dagIns.executeHook(HookDagInstance.BeforeResume)
+	dagIns.Run()
+}
+
 func (dagIns *DagInstance) executeHook(hookFunc DagInstanceHookFunc) {
 	if hookFunc != nil {
 		hookFunc(dagIns)
@@ -241,7 +630,7 @@ func (dagIns *DagInstance) executeHook(hookFunc DagInstanceHookFunc) {
 
 // CanModifyStatus CanChange indicate if the dag instance can modify status
 func (dagIns *DagInstance) CanModifyStatus() bool {
-	return dagIns.Status != DagInstanceStatusFailed
+	return dagIns.Status != DagInstanceStatusFailed && dagIns.Status != DagInstanceStatusCanceled
 }
 
 // Render variables
@@ -256,18 +645,66 @@ func (vars DagInstanceVars) Render(p map[string]interface{}) (map[string]interfa
 	return p, err
 }
 
-// Command used to define a command
+// Command used to define a command, the commands of a dag instance are queued in store and executed in order
 type Command struct {
-	Name             CommandName
-	TargetTaskInsIDs []string
+	ID               string        `json:"id,omitempty" bson:"_id,omitempty"`
+	DagInsID         string        `json:"dagInsId,omitempty" bson:"dagInsId,omitempty"`
+	Name             CommandName   `json:"name,omitempty" bson:"name,omitempty"`
+	TargetTaskInsIDs []string      `json:"targetTaskInsIds,omitempty" bson:"targetTaskInsIds,omitempty"`
+	Status           CommandStatus `json:"status,omitempty" bson:"status,omitempty"`
+	// Reason is used by the command which changes the status of dag instance, such as "cancel-dag-ins"
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+	// Issuer is who issue the command, it is recorded in the traces of task instances
+	Issuer string `json:"issuer,omitempty" bson:"issuer,omitempty"`
+	// Result is the reason why the command failed, such as "dag instance is not running"
+	Result string `json:"result,omitempty" bson:"result,omitempty"`
+	// CreatedAt(unix milliseconds) decides the order of commands
+	CreatedAt int64 `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+	// ExecutedAt(unix milliseconds) is the time when the command is executed
+	ExecutedAt int64 `json:"executedAt,omitempty" bson:"executedAt,omitempty"`
+}
+
+// Complete mark the command as executed, err is the reason why the command failed
+func (c *Command) Complete(err error) {
+	c.Status = CommandStatusSuccess
+	c.Result = ""
+	if err != nil {
+		c.Status = CommandStatusFailed
+		c.Result = err.Error()
+	}
+	c.ExecutedAt = time.Now().UnixMilli()
 }
 
+// CommandStatus used to define a command status
+type CommandStatus string
+
+const (
+	// CommandStatusPending means the command is waiting to be executed
+	CommandStatusPending CommandStatus = "pending"
+	// CommandStatusSuccess means the command is executed
+	CommandStatusSuccess CommandStatus = "success"
+	// CommandStatusFailed means the command can not be executed, such as resuming a running dag instance
+	CommandStatusFailed CommandStatus = "failed"
+)
+
 // CommandName used to define a command name
 type CommandName string
 
 const (
 	CommandNameRetry  = "retry"
 	CommandNameCancel = "cancel"
+	CommandNamePause  = "pause"
+	CommandNameResume = "resume"
+	// CommandNameCancelDagIns cancel the whole dag instance
+	CommandNameCancelDagIns = "cancel-dag-ins"
+	// CommandNameTimeoutDagIns cancel the remaining tasks and fail the dag instance which exceeds its timeout
+	CommandNameTimeoutDagIns = "timeout-dag-ins"
+	// CommandNameSkip force the tasks to be skipped, so the downstream tasks can continue
+	CommandNameSkip = "skip"
+	// CommandNameMarkSuccess force the tasks to be success, so the downstream tasks can continue
+	CommandNameMarkSuccess = "mark-success"
+	// CommandNameRerun reset the completed tasks to init and execute them again
+	CommandNameRerun = "rerun"
 )
 
 // DagInstanceStatus used to define a dag instance status
@@ -279,6 +716,12 @@ const (
 	DagInstanceStatusBlocked DagInstanceStatus = "blocked"
 	DagInstanceStatusFailed  DagInstanceStatus = "failed"
 	DagInstanceStatusSuccess DagInstanceStatus = "success"
+	// DagInstanceStatusPaused means no new task will be executed until the instance is resumed
+	DagInstanceStatusPaused DagInstanceStatus = "paused"
+	// DagInstanceStatusCanceled means the instance is canceled by user, it is a terminal status like failed
+	DagInstanceStatusCanceled DagInstanceStatus = "canceled"
+	// DagInstanceStatusScheduled means the instance waits for its schedule time, then it is promoted to init
+	DagInstanceStatusScheduled DagInstanceStatus = "scheduled"
 )
 
 // Trigger used to define a trigger
@@ -287,4 +730,6 @@ type Trigger string
 const (
 	TriggerManually Trigger = "manually"
 	TriggerCron     Trigger = "cron"
+	TriggerBackfill Trigger = "backfill"
+	TriggerSubDag   Trigger = "sub-dag"
 )
@@ -3,6 +3,7 @@ package entity
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -72,6 +73,163 @@ func TestDagInstance_VarsGetter(t *testing.T) {
 	}
 }
 
+func TestDag_Validate(t *testing.T) {
+	tests := []struct {
+		caseDesc string
+		giveDag  *Dag
+		wantErr  bool
+	}{
+		{
+			caseDesc: "default",
+			giveDag:  &Dag{},
+		},
+		{
+			caseDesc: "catch up",
+			giveDag:  &Dag{CatchUp: CatchUpPolicyLatest},
+		},
+		{
+			caseDesc: "invalid catch up",
+			giveDag:  &Dag{CatchUp: "none"},
+			wantErr:  true,
+		},
+		{
+			caseDesc: "reserved var",
+			giveDag:  &Dag{Vars: DagVars{VarKeyScheduleTime: DagVar{}}},
+			wantErr:  true,
+		},
+		{
+			caseDesc: "max active instances",
+			giveDag:  &Dag{MaxActiveInstances: 2, MaxActivePolicy: MaxActivePolicyCancelOldest},
+		},
+		{
+			caseDesc: "negative max active instances",
+			giveDag:  &Dag{MaxActiveInstances: -1},
+			wantErr:  true,
+		},
+		{
+			caseDesc: "invalid max active policy",
+			giveDag:  &Dag{MaxActiveInstances: 1, MaxActivePolicy: "drop"},
+			wantErr:  true,
+		},
+		{
+			caseDesc: "timeout and sla",
+			giveDag:  &Dag{Timeout: "1h", SLA: "30m"},
+		},
+		{
+			caseDesc: "invalid timeout",
+			giveDag:  &Dag{Timeout: "1"},
+			wantErr:  true,
+		},
+		{
+			caseDesc: "negative sla",
+			giveDag:  &Dag{SLA: "-1m"},
+			wantErr:  true,
+		},
+		{
+			caseDesc: "map over",
+			giveDag:  &Dag{Tasks: []Task{{ID: "task", MapOver: "{{.shareData.files}}", MaxParallel: 2}}},
+		},
+		{
+			caseDesc: "invalid map over",
+			giveDag:  &Dag{Tasks: []Task{{ID: "task", MapOver: "{{.shareData.files"}}},
+			wantErr:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			assert.Equal(t, tc.wantErr, tc.giveDag.Validate() != nil)
+		})
+	}
+}
+
+func TestDag_RunWithScheduleTime(t *testing.T) {
+	dag := &Dag{
+		ID:     "test-dag",
+		Status: DagStatusNormal,
+		Vars: DagVars{
+			"key1": DagVar{DefaultValue: "value1"},
+		},
+	}
+	scheduleTime := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	dagIns, err := dag.RunWithScheduleTime(TriggerCron, nil, scheduleTime)
+	assert.NoError(t, err)
+	assert.Equal(t, TriggerCron, dagIns.Trigger)
+	assert.Equal(t, scheduleTime.Unix(), dagIns.ScheduleTime)
+	assert.Equal(t, DagInstanceVars{
+		"key1":             DagInstanceVar{Value: "value1"},
+		VarKeyScheduleTime: DagInstanceVar{Value: "2023-01-01T10:00:00Z"},
+	}, dagIns.Vars)
+
+	dag.Status = DagStatusStopped
+	_, err = dag.RunWithScheduleTime(TriggerCron, nil, scheduleTime)
+	assert.Error(t, err)
+
+	// the built-in var is not overwritten silently
+	dag.Status = DagStatusNormal
+	dag.Vars[VarKeyScheduleTime] = DagVar{DefaultValue: "value2"}
+	_, err = dag.RunWithScheduleTime(TriggerCron, nil, scheduleTime)
+	assert.Error(t, err)
+}
+
+func TestDag_RunAt(t *testing.T) {
+	dag := &Dag{ID: "test-dag", Status: DagStatusNormal}
+	at := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	dagIns, err := dag.RunAt(TriggerManually, nil, at)
+	assert.NoError(t, err)
+	assert.Equal(t, DagInstanceStatusScheduled, dagIns.Status)
+	assert.Equal(t, at.Unix(), dagIns.ScheduleTime)
+	assert.False(t, dagIns.IsDue(at.Add(-time.Second)))
+	assert.True(t, dagIns.IsDue(at))
+	assert.True(t, dagIns.IsDue(at.Add(time.Second)))
+
+	dagIns.MarkCanceled("canceled")
+	assert.False(t, dagIns.IsDue(at))
+
+	dag.Status = DagStatusStopped
+	_, err = dag.RunAt(TriggerManually, nil, at)
+	assert.Error(t, err)
+}
+
+func TestDagInstance_MatchLabels(t *testing.T) {
+	dag := &Dag{
+		ID:       "test-dag",
+		Status:   DagStatusNormal,
+		Selector: "zone in (a,b)",
+		Tasks: []Task{
+			{ID: "task1"},
+			{ID: "task2", Selector: "gpu=true"},
+		},
+	}
+	dagIns, err := dag.Run(TriggerManually, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "zone in (a,b),gpu=true", dagIns.Selector)
+
+	tests := []struct {
+		giveLabels map[string]string
+		wantMatch  bool
+	}{
+		{giveLabels: map[string]string{"zone": "a", "gpu": "true"}, wantMatch: true},
+		{giveLabels: map[string]string{"zone": "c", "gpu": "true"}, wantMatch: false},
+		{giveLabels: map[string]string{"zone": "a"}, wantMatch: false},
+		{giveLabels: nil, wantMatch: false},
+	}
+	for _, tc := range tests {
+		ok, err := dagIns.MatchLabels(tc.giveLabels)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.wantMatch, ok)
+	}
+
+	ok, err := (&DagInstance{}).MatchLabels(nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	dag.Selector = "zone in (a"
+	_, err = dag.Run(TriggerManually, nil)
+	assert.Error(t, err)
+}
+
 func TestDagInstance_Success(t *testing.T) {
 	dagIns := &DagInstance{}
 	testHook(t, dagIns, string(DagInstanceStatusSuccess), DagInstanceStatusSuccess, func() {
@@ -91,6 +249,88 @@ func TestDagInstance_Run(t *testing.T) {
 	testHook(t, dagIns, string(DagInstanceStatusRunning), DagInstanceStatusRunning, func() {
 		dagIns.Run()
 	})
+	assert.NotZero(t, dagIns.StartedAt)
+
+	resumed := &DagInstance{Status: DagInstanceStatusPaused, StartedAt: 1}
+	resumed.Run()
+	assert.Equal(t, int64(1), resumed.StartedAt)
+
+	retried := &DagInstance{Status: DagInstanceStatusFailed, StartedAt: 1}
+	retried.Run()
+	assert.Greater(t, retried.StartedAt, int64(1))
+}
+
+func TestDagInstance_Deadline(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		caseDesc      string
+		giveDagIns    *DagInstance
+		wantTimeout   bool
+		wantSLAMissed bool
+	}{
+		{
+			caseDesc:   "not started",
+			giveDagIns: &DagInstance{Timeout: "1m", SLA: "1m"},
+		},
+		{
+			caseDesc:   "no limit",
+			giveDagIns: &DagInstance{StartedAt: now.Add(-time.Hour).Unix()},
+		},
+		{
+			caseDesc:   "not exceeded",
+			giveDagIns: &DagInstance{Timeout: "1m", SLA: "1m", StartedAt: now.Add(-30 * time.Second).Unix()},
+		},
+		{
+			caseDesc:      "sla missed",
+			giveDagIns:    &DagInstance{Timeout: "1h", SLA: "1m", StartedAt: now.Add(-2 * time.Minute).Unix()},
+			wantSLAMissed: true,
+		},
+		{
+			caseDesc: "sla already missed",
+			giveDagIns: &DagInstance{SLA: "1m",
+				StartedAt: now.Add(-2 * time.Minute).Unix(), SLAMissedAt: now.Add(-time.Minute).Unix()},
+		},
+		{
+			caseDesc: "sla missed in last run",
+			giveDagIns: &DagInstance{SLA: "1m",
+				StartedAt: now.Add(-2 * time.Minute).Unix(), SLAMissedAt: now.Add(-time.Hour).Unix()},
+			wantSLAMissed: true,
+		},
+		{
+			caseDesc:      "timeout",
+			giveDagIns:    &DagInstance{Timeout: "1m", SLA: "1m", StartedAt: now.Add(-2 * time.Minute).Unix()},
+			wantTimeout:   true,
+			wantSLAMissed: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			assert.Equal(t, tc.wantTimeout, tc.giveDagIns.IsTimeout(now))
+			assert.Equal(t, tc.wantSLAMissed, tc.giveDagIns.IsSLAMissed(now))
+		})
+	}
+}
+
+func TestDagInstance_MarkSLAMissed(t *testing.T) {
+	now := time.Now()
+	dagIns := &DagInstance{
+		Status:    DagInstanceStatusRunning,
+		SLA:       "1m",
+		StartedAt: now.Add(-2 * time.Minute).Unix(),
+	}
+	testHook(t, dagIns, "sla-missed", DagInstanceStatusRunning, func() {
+		dagIns.MarkSLAMissed(now)
+	})
+	assert.Equal(t, now.Unix(), dagIns.SLAMissedAt)
+	assert.False(t, dagIns.IsSLAMissed(now))
+}
+
+func TestDagInstance_TimeoutAll(t *testing.T) {
+	dagIns := &DagInstance{Status: DagInstanceStatusPaused}
+	assert.NoError(t, dagIns.TimeoutAll("timeout"))
+	assert.Equal(t, &Command{Name: CommandNameTimeoutDagIns, Reason: "timeout"}, dagIns.Cmd)
+
+	assert.Error(t, (&DagInstance{Status: DagInstanceStatusFailed}).TimeoutAll("timeout"))
 }
 
 func TestDagInstance_Retry(t *testing.T) {
@@ -109,6 +349,205 @@ func TestDagInstance_Block(t *testing.T) {
 	})
 }
 
+func TestDagInstance_Pause(t *testing.T) {
+	dagIns := &DagInstance{
+		Status: DagInstanceStatusRunning,
+	}
+	// the hook is called when the command is executed rather than created
+	testHook(t, dagIns, "", DagInstanceStatusRunning, func() {
+		assert.NoError(t, dagIns.Pause())
+	})
+	assert.Equal(t, &Command{Name: CommandNamePause}, dagIns.Cmd)
+
+	assert.Error(t, (&DagInstance{Status: DagInstanceStatusSuccess}).Pause())
+}
+
+func TestDagInstance_MarkPaused(t *testing.T) {
+	dagIns := &DagInstance{
+		Status: DagInstanceStatusRunning,
+	}
+	testHook(t, dagIns, "pause", DagInstanceStatusPaused, func() {
+		dagIns.MarkPaused()
+	})
+}
+
+func TestDagInstance_Resume(t *testing.T) {
+	dagIns := &DagInstance{
+		Status: DagInstanceStatusPaused,
+	}
+	testHook(t, dagIns, "", DagInstanceStatusPaused, func() {
+		assert.NoError(t, dagIns.Resume())
+	})
+	assert.Equal(t, &Command{Name: CommandNameResume}, dagIns.Cmd)
+
+	assert.Error(t, (&DagInstance{Status: DagInstanceStatusRunning}).Resume())
+}
+
+func TestDagInstance_MarkResumed(t *testing.T) {
+	dagIns := &DagInstance{
+		Status: DagInstanceStatusPaused,
+	}
+	var called []string
+	HookDagInstance = DagInstanceLifecycleHook{
+		BeforeRun: func(dagIns *DagInstance) {
+			called = append(called, "run")
+		},
+		BeforeResume: func(dagIns *DagInstance) {
+			called = append(called, "resume")
+		},
+	}
+	defer func() {
+		HookDagInstance = DagInstanceLifecycleHook{}
+	}()
+
+	dagIns.MarkResumed()
+	assert.Equal(t, DagInstanceStatusRunning, dagIns.Status)
+	assert.Equal(t, []string{"resume", "run"}, called)
+}
+
+func TestDagInstance_MarkCanceled(t *testing.T) {
+	dagIns := &DagInstance{}
+	testHook(t, dagIns, string(DagInstanceStatusCanceled), DagInstanceStatusCanceled, func() {
+		dagIns.MarkCanceled("stop")
+	})
+	assert.Equal(t, "stop", dagIns.Reason)
+	assert.False(t, dagIns.CanModifyStatus())
+}
+
+func TestDagInstance_CancelAll(t *testing.T) {
+	tests := []struct {
+		caseDesc   string
+		giveDagIns *DagInstance
+		wantCmd    *Command
+		wantErr    bool
+	}{
+		{
+			caseDesc:   "running",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusRunning},
+			wantCmd:    &Command{Name: CommandNameCancelDagIns, Reason: "stop"},
+		},
+		{
+			caseDesc: "override command",
+			giveDagIns: &DagInstance{
+				Status: DagInstanceStatusPaused,
+				Cmd:    &Command{Name: CommandNameResume},
+			},
+			wantCmd: &Command{Name: CommandNameCancelDagIns, Reason: "stop"},
+		},
+		{
+			caseDesc:   "blocked",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusBlocked},
+			wantCmd:    &Command{Name: CommandNameCancelDagIns, Reason: "stop"},
+		},
+		{
+			caseDesc:   "completed",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusCanceled},
+			wantErr:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			err := tc.giveDagIns.CancelAll("stop")
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantCmd, tc.giveDagIns.Cmd)
+		})
+	}
+}
+
+func TestDagInstance_Rerun(t *testing.T) {
+	tests := []struct {
+		caseDesc   string
+		giveDagIns *DagInstance
+		wantCmd    *Command
+		wantErr    bool
+	}{
+		{
+			caseDesc:   "success",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusSuccess},
+			wantCmd:    &Command{Name: CommandNameRerun, TargetTaskInsIDs: []string{"t1", "t2"}},
+		},
+		{
+			caseDesc:   "blocked",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusBlocked},
+			wantCmd:    &Command{Name: CommandNameRerun, TargetTaskInsIDs: []string{"t1", "t2"}},
+		},
+		{
+			caseDesc:   "running",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusRunning},
+			wantErr:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			err := tc.giveDagIns.Rerun([]string{"t1", "t2"})
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantCmd, tc.giveDagIns.Cmd)
+		})
+	}
+}
+
+func TestDagInstance_SkipTasks(t *testing.T) {
+	tests := []struct {
+		caseDesc   string
+		giveDagIns *DagInstance
+		giveFunc   func(dagIns *DagInstance) error
+		wantCmd    *Command
+		wantErr    bool
+	}{
+		{
+			caseDesc:   "skip",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusRunning},
+			giveFunc: func(dagIns *DagInstance) error {
+				return dagIns.SkipTasks([]string{"t1"}, "admin", "stuck")
+			},
+			wantCmd: &Command{
+				Name:             CommandNameSkip,
+				TargetTaskInsIDs: []string{"t1"},
+				Reason:           "stuck",
+				Issuer:           "admin",
+			},
+		},
+		{
+			caseDesc:   "mark success for failed dag instance",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusFailed},
+			giveFunc: func(dagIns *DagInstance) error {
+				return dagIns.MarkTasksSuccess([]string{"t1"}, "", "")
+			},
+			wantCmd: &Command{
+				Name:             CommandNameMarkSuccess,
+				TargetTaskInsIDs: []string{"t1"},
+			},
+		},
+		{
+			caseDesc:   "completed",
+			giveDagIns: &DagInstance{Status: DagInstanceStatusSuccess},
+			giveFunc: func(dagIns *DagInstance) error {
+				return dagIns.MarkTasksSuccess([]string{"t1"}, "", "")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			err := tc.giveFunc(tc.giveDagIns)
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantCmd, tc.giveDagIns.Cmd)
+		})
+	}
+}
+
+func TestCommand_Complete(t *testing.T) {
+	cmd := &Command{Status: CommandStatusPending}
+	cmd.Complete(fmt.Errorf("dag instance is not running"))
+	assert.Equal(t, CommandStatusFailed, cmd.Status)
+	assert.Equal(t, "dag instance is not running", cmd.Result)
+	assert.NotZero(t, cmd.ExecutedAt)
+
+	cmd.Complete(nil)
+	assert.Equal(t, CommandStatusSuccess, cmd.Status)
+	assert.Empty(t, cmd.Result)
+}
+
 func testHook(t *testing.T, dagIns *DagInstance, wantRet string, wantStatus DagInstanceStatus, call func()) {
 	ret := ""
 	HookDagInstance = DagInstanceLifecycleHook{
@@ -132,6 +571,22 @@ func testHook(t *testing.T, dagIns *DagInstance, wantRet string, wantStatus DagI
 			assert.NotNil(t, dagIns)
 			ret = "retry"
 		},
+		BeforePause: func(dagIns *DagInstance) {
+			assert.NotNil(t, dagIns)
+			ret = "pause"
+		},
+		BeforeResume: func(dagIns *DagInstance) {
+			assert.NotNil(t, dagIns)
+			ret = "resume"
+		},
+		BeforeCancel: func(dagIns *DagInstance) {
+			assert.NotNil(t, dagIns)
+			ret = string(DagInstanceStatusCanceled)
+		},
+		BeforeSLAMissed: func(dagIns *DagInstance) {
+			assert.NotNil(t, dagIns)
+			ret = "sla-missed"
+		},
 	}
 
 	call()
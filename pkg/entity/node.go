@@ -0,0 +1,11 @@
+package entity
+
+// Node is a worker which registered by keeper
+type Node struct {
+	Key string `json:"key,omitempty" bson:"_id,omitempty"`
+	// HeartbeatAt is the time of last heartbeat in unix milliseconds
+	HeartbeatAt int64 `json:"heartbeatAt,omitempty" bson:"heartbeatAt,omitempty"`
+
+	// Labels used to route dag instances which have selector
+	Labels map[string]string `json:"labels,omitempty" bson:"labels,omitempty"`
+}
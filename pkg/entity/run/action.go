@@ -35,6 +35,20 @@ type RetryBeforeAction interface {
 	RetryBefore(ctx ExecuteContext, params interface{}) error
 }
 
+// RateLimitedAction means the task instances of action are delayed by executor when they exceed the rate limit
+type RateLimitedAction interface {
+	// RateLimit return the rate(task instances per second) and burst of token bucket
+	RateLimit() (rate float64, burst int)
+}
+
+// SubDagAction means action runs other dags, the dags referenced by it are checked when the dag is saved,
+// so a dag can not run itself as a sub dag
+type SubDagAction interface {
+	// SubDagIDs return the ids of dags run by the action with the params, the ids which are not known
+	// until the task is rendered can be omitted
+	SubDagIDs(params interface{}) []string
+}
+
 var (
 	EndLoop = errors.New("end loop")
 )
@@ -68,7 +68,9 @@ func TestLoopDo(t *testing.T) {
 		cnt = 0
 		pc, cancel := context.WithCancel(context.TODO())
 		if tc.timeout > 0 {
-			pc, cancel = context.WithTimeout(pc, tc.timeout)
+			var timeoutCancel context.CancelFunc
+			pc, timeoutCancel = context.WithTimeout(pc, tc.timeout)
+			defer timeoutCancel()
 		}
 		ctx := &DefExecuteContext{
 			ctx: pc,
@@ -86,5 +88,6 @@ func TestLoopDo(t *testing.T) {
 		err := LoopDo(ctx, tc.giveDo, LoopInterval(tc.giveInterval))
 		assert.Equal(t, tc.wantErr, err)
 		assert.Equal(t, tc.wantTraces, traces)
+		cancel()
 	}
 }
@@ -2,6 +2,7 @@ package run
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/weeyp/fastflow/pkg/utils"
@@ -14,14 +15,36 @@ func NewDefExecuteContext(
 	trace func(msg string, opt ...TraceOp),
 	dagVars utils.KeyValueGetter,
 	varsIterator utils.KeyValueIterator,
+	ops ...ExecuteContextOp,
 ) *DefExecuteContext {
-	return &DefExecuteContext{
+	e := &DefExecuteContext{
 		ctx:          ctx,
 		op:           op,
 		trace:        trace,
 		varsGetter:   dagVars,
 		varsIterator: varsIterator,
 	}
+	for i := range ops {
+		ops[i](e)
+	}
+	return e
+}
+
+// ExecuteContextOp used to set optional callbacks of DefExecuteContext
+type ExecuteContextOp func(e *DefExecuteContext)
+
+// WithBranchFunc set the callback which is called when action choose branches
+func WithBranchFunc(branch func(taskIds []string)) ExecuteContextOp {
+	return func(e *DefExecuteContext) {
+		e.branch = branch
+	}
+}
+
+// WithOutputFunc set the callback which is called when action set its output
+func WithOutputFunc(output func(output map[string]interface{})) ExecuteContextOp {
+	return func(e *DefExecuteContext) {
+		e.output = output
+	}
 }
 
 // ExecuteContext is a context using by action
@@ -44,6 +67,13 @@ type ExecuteContext interface {
 	Tracef(msg string, a ...interface{})
 	GetVar(varName string) (string, bool)
 	IterateVars(iterateFunc utils.KeyValueIterateFunc)
+	// Branch choose which downstream tasks to follow, the other downstream tasks will be skipped,
+	// calling it without task ids means all downstream tasks will be skipped
+	Branch(taskIds ...string)
+	// SetOutput set the output of task, it will be persisted to the TaskInstance.Output,
+	// and downstream tasks can reference it in params, such as "{{.tasks.taskId.output.field}}".
+	// output should be a map or a struct which can be marshaled to a json object
+	SetOutput(output interface{}) error
 }
 
 // ShareDataOperator used to operate share data
@@ -61,6 +91,8 @@ type DefExecuteContext struct {
 	trace        func(msg string, opt ...TraceOp)
 	varsGetter   func(string) (string, bool)
 	varsIterator utils.KeyValueIterator
+	branch       func(taskIds []string)
+	output       func(output map[string]interface{})
 }
 
 // Context
@@ -125,6 +157,36 @@ func (e *DefExecuteContext) IterateVars(iterateFunc utils.KeyValueIterateFunc) {
 	e.varsIterator(iterateFunc)
 }
 
+// Branch choose which downstream tasks to follow
+func (e *DefExecuteContext) Branch(taskIds ...string) {
+	if e.branch == nil {
+		return
+	}
+	e.branch(append([]string{}, taskIds...))
+}
+
+// SetOutput set the output of task
+func (e *DefExecuteContext) SetOutput(output interface{}) error {
+	if e.output == nil {
+		return nil
+	}
+	if m, ok := output.(map[string]interface{}); ok {
+		e.output(m)
+		return nil
+	}
+
+	bs, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("marshal output failed: %w", err)
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return fmt.Errorf("output must be a json object: %w", err)
+	}
+	e.output(m)
+	return nil
+}
+
 // TraceOption
 type TraceOption struct {
 	Priority PersistPriority
@@ -83,3 +83,46 @@ func TestDefExecuteContext_Tracef(t *testing.T) {
 		})
 	}
 }
+
+func TestDefExecuteContext_SetOutput(t *testing.T) {
+	tests := []struct {
+		caseDesc   string
+		giveOutput interface{}
+		wantOutput map[string]interface{}
+		wantErr    bool
+	}{
+		{
+			caseDesc:   "map",
+			giveOutput: map[string]interface{}{"count": 1},
+			wantOutput: map[string]interface{}{"count": 1},
+		},
+		{
+			caseDesc: "struct",
+			giveOutput: struct {
+				Name  string   `json:"name"`
+				Files []string `json:"files"`
+			}{Name: "a", Files: []string{"f1"}},
+			wantOutput: map[string]interface{}{"name": "a", "files": []interface{}{"f1"}},
+		},
+		{
+			caseDesc:   "not object",
+			giveOutput: []string{"a"},
+			wantErr:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			var got map[string]interface{}
+			e := NewDefExecuteContext(nil, nil, nil, nil, nil, WithOutputFunc(func(output map[string]interface{}) {
+				got = output
+			}))
+			err := e.SetOutput(tc.giveOutput)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantOutput, got)
+		})
+	}
+}
@@ -1,13 +1,20 @@
 package entity
 
 import (
+	"encoding/json"
 	"fmt"
+	"regexp"
 	"runtime"
+	"strconv"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/weeyp/fastflow/pkg/entity/run"
+	"github.com/weeyp/fastflow/pkg/expr"
 	"github.com/weeyp/fastflow/pkg/log"
 	"github.com/weeyp/fastflow/pkg/utils"
+	"github.com/weeyp/fastflow/pkg/utils/value"
 )
 
 // Task instance
@@ -19,6 +26,220 @@ type Task struct {
 	TimeoutSecs int                    `yaml:"timeoutSecs,omitempty" json:"timeoutSecs,omitempty"  bson:"timeoutSecs,omitempty"`
 	Params      map[string]interface{} `yaml:"params,omitempty" json:"params,omitempty"  bson:"params,omitempty"`
 	PreChecks   PreChecks              `yaml:"preCheck,omitempty" json:"preCheck,omitempty"  bson:"preCheck,omitempty"`
+	// Selector means the dag instance can only run at workers which match it
+	Selector string `yaml:"selector,omitempty" json:"selector,omitempty"  bson:"selector,omitempty"`
+	// Retry means the task will be retried automatically when it failed
+	Retry *RetryPolicy `yaml:"retry,omitempty" json:"retry,omitempty"  bson:"retry,omitempty"`
+	// TriggerRule decide when the task can be executed according to its upstream tasks, default is "all_success"
+	TriggerRule TriggerRule `yaml:"triggerRule,omitempty" json:"triggerRule,omitempty"  bson:"triggerRule,omitempty"`
+	// MapOver means the task will be expanded to a task instance per item at runtime,
+	// it is a template rendered like params such as "{{.shareData.files}}",
+	// and the result should be a json array or comma separated values
+	MapOver string `yaml:"mapOver,omitempty" json:"mapOver,omitempty"  bson:"mapOver,omitempty"`
+	// MaxParallel limits the number of mapped task instances running at the same time, 0 means no limit
+	MaxParallel int `yaml:"maxParallel,omitempty" json:"maxParallel,omitempty"  bson:"maxParallel,omitempty"`
+	// Pool is the name of pool which limits the running task instances, it overrides the default pool of action
+	Pool string `yaml:"pool,omitempty" json:"pool,omitempty"  bson:"pool,omitempty"`
+	// Priority decide the order of task instances waiting for executor, it is added to the priority of dag
+	Priority int `yaml:"priority,omitempty" json:"priority,omitempty"  bson:"priority,omitempty"`
+}
+
+// TriggerRule decide when the task can be executed according to the status of its upstream tasks,
+// skipped upstream task is treated as success, and canceled upstream task is treated as failed
+type TriggerRule string
+
+const (
+	// TriggerRuleAllSuccess means all upstream tasks succeeded
+	TriggerRuleAllSuccess TriggerRule = "all_success"
+	// TriggerRuleAllDone means all upstream tasks completed, no matter they succeeded or not
+	TriggerRuleAllDone TriggerRule = "all_done"
+	// TriggerRuleOneSuccess means at least one upstream task succeeded
+	TriggerRuleOneSuccess TriggerRule = "one_success"
+	// TriggerRuleOneFailed means at least one upstream task failed
+	TriggerRuleOneFailed TriggerRule = "one_failed"
+	// TriggerRuleAllFailed means all upstream tasks failed
+	TriggerRuleAllFailed TriggerRule = "all_failed"
+	// TriggerRuleNoneFailed means all upstream tasks completed and none of them failed,
+	// unlike all_success, the upstream task which will never be executed is not treated as failed
+	TriggerRuleNoneFailed TriggerRule = "none_failed"
+)
+
+// Validate return error if the trigger rule is unknown
+func (r TriggerRule) Validate() error {
+	switch r {
+	case "", TriggerRuleAllSuccess, TriggerRuleAllDone, TriggerRuleOneSuccess,
+		TriggerRuleOneFailed, TriggerRuleAllFailed, TriggerRuleNoneFailed:
+		return nil
+	}
+	return fmt.Errorf("trigger rule %s is not valid", r)
+}
+
+// RetryPolicy define how to retry a failed task
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts, including the first one
+	MaxAttempts int `yaml:"maxAttempts,omitempty" json:"maxAttempts,omitempty"  bson:"maxAttempts,omitempty"`
+	// Backoff could be "fixed" or "exponential", default is "fixed"
+	Backoff BackoffType `yaml:"backoff,omitempty" json:"backoff,omitempty"  bson:"backoff,omitempty"`
+	// InitialDelay is the delay before first retry, such as "10s", default is 0
+	InitialDelay string `yaml:"initialDelay,omitempty" json:"initialDelay,omitempty"  bson:"initialDelay,omitempty"`
+	// MaxDelay is the upper limit of delay, the exponential backoff is limited by DefaultMaxRetryDelay when it is not set
+	MaxDelay string `yaml:"maxDelay,omitempty" json:"maxDelay,omitempty"  bson:"maxDelay,omitempty"`
+	// RetryOn is a list of regular expressions, the task only be retried when error matches one of them,
+	// empty means retry on any error
+	RetryOn []string `yaml:"retryOn,omitempty" json:"retryOn,omitempty"  bson:"retryOn,omitempty"`
+}
+
+type BackoffType string
+
+const (
+	BackoffTypeFixed       BackoffType = "fixed"
+	BackoffTypeExponential BackoffType = "exponential"
+)
+
+// DefaultMaxRetryDelay is the upper limit of delay when MaxDelay is not set,
+// so the exponential backoff will not overflow after many attempts
+const DefaultMaxRetryDelay = time.Hour
+
+// Validate return error if the policy is invalid
+func (p *RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("max attempts can not be negative")
+	}
+	switch p.Backoff {
+	case "", BackoffTypeFixed, BackoffTypeExponential:
+	default:
+		return fmt.Errorf("backoff %s is not valid", p.Backoff)
+	}
+	if _, err := parseDelay(p.InitialDelay); err != nil {
+		return fmt.Errorf("initial delay is invalid: %w", err)
+	}
+	if _, err := parseDelay(p.MaxDelay); err != nil {
+		return fmt.Errorf("max delay is invalid: %w", err)
+	}
+	for _, pattern := range p.RetryOn {
+		if _, err := compileRegexp(pattern); err != nil {
+			return fmt.Errorf("retry on pattern %s is invalid: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ShouldRetry return if the task should be retried after the attempt failed
+func (p *RetryPolicy) ShouldRetry(attempt int, err error) bool {
+	if p == nil || err == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+	for _, pattern := range p.RetryOn {
+		re, cErr := compileRegexp(pattern)
+		if cErr == nil && re.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay return the delay before retrying the failed attempt
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	delay, _ := parseDelay(p.InitialDelay)
+	maxDelay, _ := parseDelay(p.MaxDelay)
+	if p.Backoff == BackoffTypeExponential {
+		if maxDelay == 0 {
+			maxDelay = DefaultMaxRetryDelay
+		}
+		for i := 1; i < attempt && delay < maxDelay; i++ {
+			// stop doubling before it exceeds the max delay, so it never overflows
+			if delay > maxDelay/2 {
+				delay = maxDelay
+				break
+			}
+			delay *= 2
+		}
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func parseDelay(delay string) (time.Duration, error) {
+	if delay == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("delay %s can not be negative", delay)
+	}
+	return d, nil
+}
+
+// exprs cache the parsed expressions of checks, so they are parsed once rather than at every evaluation
+var exprs sync.Map // map[string]*expr.Expr
+
+func parseExpr(src string) (*expr.Expr, error) {
+	if e, ok := exprs.Load(src); ok {
+		return e.(*expr.Expr), nil
+	}
+	e, err := expr.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	exprs.Store(src, e)
+	return e, nil
+}
+
+// regexps cache the compiled regular expressions, the patterns are defined in dags,
+// so they are compiled once rather than at every evaluation
+var regexps sync.Map // map[string]*regexp.Regexp
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(pattern, re)
+	return re, nil
+}
+
+// Validate return error if the task is invalid
+func (t *Task) Validate() error {
+	if t.Retry != nil {
+		if err := t.Retry.Validate(); err != nil {
+			return fmt.Errorf("invalid retry policy: %w", err)
+		}
+	}
+	if err := t.TriggerRule.Validate(); err != nil {
+		return err
+	}
+	if t.MaxParallel < 0 {
+		return fmt.Errorf("max parallel can not be negative")
+	}
+	// map over is rendered like params by text/template
+	if t.MapOver != "" {
+		if _, err := template.New(t.ID).Parse(t.MapOver); err != nil {
+			return fmt.Errorf("invalid map over: %w", err)
+		}
+	}
+	if err := t.PreChecks.Validate(); err != nil {
+		return err
+	}
+	return value.MapValue(t.Params).WalkString(func(walkContext *value.WalkContext, s string) error {
+		if !expr.HasTemplate(s) {
+			return nil
+		}
+		if _, err := expr.ParseTemplate(s); err != nil {
+			return fmt.Errorf("invalid params: %w", err)
+		}
+		return nil
+	})
 }
 
 // GetGraphID return graph id
@@ -41,22 +262,76 @@ func (t *Task) GetStatus() TaskInstanceStatus {
 	return ""
 }
 
+// GetTriggerRule return trigger rule
+func (t *Task) GetTriggerRule() TriggerRule {
+	return t.TriggerRule
+}
+
+// TaskInsGetter return the task instance by task id in the same dag instance
+type TaskInsGetter func(taskId string) (*TaskInstance, bool)
+
 type PreChecks map[string]*Check
 
+// Validate return error if any check is invalid
+func (p PreChecks) Validate() error {
+	for k, c := range p {
+		if c == nil {
+			return fmt.Errorf("pre-check[%s] is empty", k)
+		}
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("pre-check[%s] is invalid: %w", k, err)
+		}
+	}
+	return nil
+}
+
 // Check return if all check is meet
 type Check struct {
 	Conditions []TaskCondition `yaml:"conditions,omitempty" json:"conditions,omitempty"  bson:"conditions,omitempty"`
 	Act        ActiveAction    `yaml:"act,omitempty" json:"act,omitempty"  bson:"act,omitempty"`
+	// When is an expression such as "vars.env == 'prod' && shareData.count > 3",
+	// the check is meet when both of it and conditions are meet
+	When string `yaml:"when,omitempty" json:"when,omitempty"  bson:"when,omitempty"`
+}
+
+// Validate return error if the check is invalid
+func (c *Check) Validate() error {
+	switch c.Act {
+	// empty act is accepted as before, it only fails the task when the check is meet
+	case "", ActiveActionSkip, ActiveActionBlock:
+	default:
+		return fmt.Errorf("act is invalid: %s", c.Act)
+	}
+	for i := range c.Conditions {
+		if err := c.Conditions[i].Validate(); err != nil {
+			return err
+		}
+	}
+	if c.When != "" {
+		if _, err := parseExpr(c.When); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // IsMeet return if check is meet
-func (c *Check) IsMeet(dagIns *DagInstance) bool {
+func (c *Check) IsMeet(dagIns *DagInstance, tasks TaskInsGetter) (bool, error) {
 	for _, cd := range c.Conditions {
-		if !cd.IsMeet(dagIns) {
-			return false
+		ok, err := cd.IsMeet(dagIns, tasks)
+		if err != nil || !ok {
+			return false, err
 		}
 	}
-	return true
+	if c.When == "" {
+		return true, nil
+	}
+
+	e, err := parseExpr(c.When)
+	if err != nil {
+		return false, err
+	}
+	return e.EvalBool(dagIns.ExprEnv(tasks))
 }
 
 type ActiveAction string
@@ -73,24 +348,96 @@ type Operator string
 const (
 	OperatorIn    Operator = "in"
 	OperatorNotIn Operator = "not-in"
+	// OperatorEq means the value equals to the first of values
+	OperatorEq Operator = "eq"
+	// OperatorNe means the value does not equal to the first of values
+	OperatorNe Operator = "ne"
+	// OperatorExists means the key exists, values are ignored
+	OperatorExists Operator = "exists"
+	// OperatorNotExists means the key does not exist, values are ignored
+	OperatorNotExists Operator = "not-exists"
+	// OperatorRegex means the value matches the regular expression which is the first of values
+	OperatorRegex Operator = "regex"
+	// OperatorGt means the value is a number and greater than the first of values
+	OperatorGt Operator = "gt"
+	// OperatorGte means the value is a number and greater than or equal to the first of values
+	OperatorGte Operator = "gte"
+	// OperatorLt means the value is a number and less than the first of values
+	OperatorLt Operator = "lt"
+	// OperatorLte means the value is a number and less than or equal to the first of values
+	OperatorLte Operator = "lte"
 )
 
+// Validate return error if the operator is invalid or values are not suitable for it
+func (o Operator) Validate(values []string) error {
+	switch o {
+	case OperatorIn, OperatorNotIn:
+		if len(values) == 0 {
+			return fmt.Errorf("operator %s need at least one value", o)
+		}
+	case OperatorExists, OperatorNotExists:
+	case OperatorEq, OperatorNe:
+		if len(values) != 1 {
+			return fmt.Errorf("operator %s need exactly one value", o)
+		}
+	case OperatorRegex:
+		if len(values) != 1 {
+			return fmt.Errorf("operator %s need exactly one value", o)
+		}
+		if _, err := compileRegexp(values[0]); err != nil {
+			return fmt.Errorf("regex %s is invalid: %w", values[0], err)
+		}
+	case OperatorGt, OperatorGte, OperatorLt, OperatorLte:
+		if len(values) != 1 {
+			return fmt.Errorf("operator %s need exactly one value", o)
+		}
+		if _, err := strconv.ParseFloat(values[0], 64); err != nil {
+			return fmt.Errorf("operator %s need a number value: %w", o, err)
+		}
+	default:
+		return fmt.Errorf("operator %s is not valid", o)
+	}
+	return nil
+}
+
 type TaskConditionSource string
 
 const (
 	TaskConditionSourceVars      TaskConditionSource = "vars"
 	TaskConditionSourceShareData TaskConditionSource = "share-data"
+	// TaskConditionSourceTaskStatus means the key is an upstream task id, and the value is its status
+	TaskConditionSourceTaskStatus TaskConditionSource = "task-status"
 )
 
+// Validate return error if the source is invalid
+func (t TaskConditionSource) Validate() error {
+	switch t {
+	case TaskConditionSourceVars, TaskConditionSourceShareData, TaskConditionSourceTaskStatus:
+		return nil
+	}
+	return fmt.Errorf("task condition source %s is not valid", t)
+}
+
 // BuildKvGetter return kv getter
-func (t TaskConditionSource) BuildKvGetter(dagIns *DagInstance) utils.KeyValueGetter {
+func (t TaskConditionSource) BuildKvGetter(dagIns *DagInstance, tasks TaskInsGetter) (utils.KeyValueGetter, error) {
 	switch t {
 	case TaskConditionSourceVars:
-		return dagIns.VarsGetter()
+		return dagIns.VarsGetter(), nil
 	case TaskConditionSourceShareData:
-		return dagIns.ShareData.Get
+		return dagIns.ShareData.Get, nil
+	case TaskConditionSourceTaskStatus:
+		if tasks == nil {
+			return nil, fmt.Errorf("task status is not available")
+		}
+		return func(taskId string) (string, bool) {
+			t, ok := tasks(taskId)
+			if !ok {
+				return "", false
+			}
+			return string(t.Status), true
+		}, nil
 	default:
-		panic(fmt.Sprintf("task condition source %s is not valid", t))
+		return nil, t.Validate()
 	}
 }
 
@@ -100,24 +447,122 @@ type TaskCondition struct {
 	Key    string              `yaml:"key,omitempty" json:"key,omitempty"  bson:"key,omitempty"`
 	Values []string            `yaml:"values,omitempty" json:"values,omitempty"  bson:"values,omitempty"`
 	Op     Operator            `yaml:"op,omitempty" json:"op,omitempty"  bson:"op,omitempty"`
+	// Any means the condition is meet when any of them is meet, it can not be used with other fields
+	Any []TaskCondition `yaml:"any,omitempty" json:"any,omitempty"  bson:"any,omitempty"`
+	// All means the condition is meet when all of them are meet, it can not be used with other fields
+	All []TaskCondition `yaml:"all,omitempty" json:"all,omitempty"  bson:"all,omitempty"`
+}
+
+// Validate return error if the condition is invalid
+func (c *TaskCondition) Validate() error {
+	if len(c.Any) != 0 || len(c.All) != 0 {
+		if c.Source != "" || c.Key != "" || c.Op != "" || len(c.Values) != 0 {
+			return fmt.Errorf("grouped condition can not have source, key, op or values")
+		}
+		if len(c.Any) != 0 && len(c.All) != 0 {
+			return fmt.Errorf("condition can not have both any and all")
+		}
+		for _, sub := range append(c.Any, c.All...) {
+			if err := sub.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := c.Source.Validate(); err != nil {
+		return err
+	}
+	if c.Key == "" {
+		return fmt.Errorf("key of condition is required")
+	}
+	return c.Op.Validate(c.Values)
 }
 
 // IsMeet return if check is meet
-func (c *TaskCondition) IsMeet(dagIns *DagInstance) bool {
-	kvGetter := c.Source.BuildKvGetter(dagIns)
+func (c *TaskCondition) IsMeet(dagIns *DagInstance, tasks TaskInsGetter) (bool, error) {
+	if len(c.Any) != 0 {
+		for _, sub := range c.Any {
+			ok, err := sub.IsMeet(dagIns, tasks)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if len(c.All) != 0 {
+		for _, sub := range c.All {
+			ok, err := sub.IsMeet(dagIns, tasks)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	kvGetter, err := c.Source.BuildKvGetter(dagIns, tasks)
+	if err != nil {
+		return false, err
+	}
 
 	v, ok := kvGetter(c.Key)
+	switch c.Op {
+	case OperatorExists:
+		return ok, nil
+	case OperatorNotExists:
+		return !ok, nil
+	}
 	if !ok {
-		return false
+		return false, nil
 	}
 
 	switch c.Op {
 	case OperatorIn:
-		return isStrInArray(v, c.Values)
+		return isStrInArray(v, c.Values), nil
 	case OperatorNotIn:
-		return !isStrInArray(v, c.Values)
+		return !isStrInArray(v, c.Values), nil
+	}
+	if len(c.Values) != 1 {
+		return false, c.Op.Validate(c.Values)
+	}
+	switch c.Op {
+	case OperatorEq:
+		return v == c.Values[0], nil
+	case OperatorNe:
+		return v != c.Values[0], nil
+	case OperatorRegex:
+		re, err := compileRegexp(c.Values[0])
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(v), nil
+	case OperatorGt, OperatorGte, OperatorLt, OperatorLte:
+		return compareNumber(c.Op, v, c.Values[0])
+	}
+	return false, c.Op.Validate(c.Values)
+}
+
+// compareNumber compare the value with target, it is not meet if value is not a number
+func compareNumber(op Operator, value, target string) (bool, error) {
+	t, err := strconv.ParseFloat(target, 64)
+	if err != nil {
+		return false, fmt.Errorf("operator %s need a number value: %w", op, err)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	switch op {
+	case OperatorGt:
+		return v > t, nil
+	case OperatorGte:
+		return v >= t, nil
+	case OperatorLt:
+		return v < t, nil
+	default:
+		return v <= t, nil
 	}
-	return false
 }
 
 func isStrInArray(str string, arr []string) bool {
@@ -145,6 +590,31 @@ type TaskInstance struct {
 	Status      TaskInstanceStatus     `json:"status,omitempty" bson:"status,omitempty"`
 	Reason      string                 `json:"reason,omitempty" bson:"reason,omitempty"`
 	PreChecks   PreChecks              `json:"preChecks,omitempty"  bson:"preChecks,omitempty"`
+	Retry       *RetryPolicy           `json:"retry,omitempty"  bson:"retry,omitempty"`
+	TriggerRule TriggerRule            `json:"triggerRule,omitempty"  bson:"triggerRule,omitempty"`
+	MapOver     string                 `json:"mapOver,omitempty"  bson:"mapOver,omitempty"`
+	MaxParallel int                    `json:"maxParallel,omitempty"  bson:"maxParallel,omitempty"`
+	Pool        string                 `json:"pool,omitempty"  bson:"pool,omitempty"`
+	Priority    int                    `json:"priority,omitempty"  bson:"priority,omitempty"`
+	// Attempt is the number of current attempt, start from 1
+	Attempt int `json:"attempt,omitempty"  bson:"attempt,omitempty"`
+	// Attempts record the history of each attempt
+	Attempts []TaskAttempt `json:"attempts,omitempty"  bson:"attempts,omitempty"`
+	// RetryAt(unix milliseconds) is the time when the retrying task instance can be executed,
+	// it is persisted so the backoff is kept after the worker restarts
+	RetryAt int64 `json:"retryAt,omitempty"  bson:"retryAt,omitempty"`
+	// Branches is the downstream task ids chosen by the task, nil means the task is not a branch task,
+	// and empty means the branch task chooses nothing. The difference is kept by TaskInstance.MarshalJSON,
+	// and bson does not omit it, because it encodes nil as null and empty as an empty array
+	Branches []string `json:"branches,omitempty"  bson:"branches"`
+	// MapParentID is the id of task instance which this mapped task instance is expanded from
+	MapParentID string `json:"mapParentId,omitempty"  bson:"mapParentId,omitempty"`
+	// MapIndex is the index of item which this mapped task instance is expanded from
+	MapIndex int `json:"mapIndex,omitempty"  bson:"mapIndex,omitempty"`
+	// MapItem is the item which this mapped task instance is expanded from, it can be used in params as "{{.item}}"
+	MapItem string `json:"mapItem,omitempty"  bson:"mapItem,omitempty"`
+	// Output is set by action through "ExecuteContext.SetOutput", downstream tasks can reference it in params
+	Output map[string]interface{} `json:"output,omitempty"  bson:"output,omitempty"`
 
 	// used to save changes
 	Patch              func(*TaskInstance) error `json:"-" bson:"-"`
@@ -155,6 +625,16 @@ type TaskInstance struct {
 	bufTraces []TraceInfo
 }
 
+// TaskAttempt record the outcome of an attempt
+type TaskAttempt struct {
+	Attempt   int                `json:"attempt,omitempty" bson:"attempt,omitempty"`
+	Worker    string             `json:"worker,omitempty" bson:"worker,omitempty"`
+	StartedAt int64              `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	EndedAt   int64              `json:"endedAt,omitempty" bson:"endedAt,omitempty"`
+	Status    TaskInstanceStatus `json:"status,omitempty" bson:"status,omitempty"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+}
+
 // TraceInfo trace info
 type TraceInfo struct {
 	Time    int64  `json:"time,omitempty" bson:"time,omitempty"`
@@ -173,6 +653,13 @@ func NewTaskInstance(dagInsId string, t Task) *TaskInstance {
 		Params:      t.Params,
 		Status:      TaskInstanceStatusInit,
 		PreChecks:   t.PreChecks,
+		Retry:       t.Retry,
+		TriggerRule: t.TriggerRule,
+		MapOver:     t.MapOver,
+		MaxParallel: t.MaxParallel,
+		Pool:        t.Pool,
+		Priority:    t.Priority,
+		Attempt:     1,
 	}
 }
 
@@ -196,6 +683,45 @@ func (t *TaskInstance) GetStatus() TaskInstanceStatus {
 	return t.Status
 }
 
+// GetTriggerRule return trigger rule
+func (t *TaskInstance) GetTriggerRule() TriggerRule {
+	return t.TriggerRule
+}
+
+// taskInstanceJSON is the json form of task instance, omitempty drops both nil and empty slice,
+// so branches is a pointer to keep an empty one
+type taskInstanceJSON struct {
+	*taskInstanceAlias
+	Branches *[]string `json:"branches,omitempty"`
+}
+
+// taskInstanceAlias has no methods, so it is marshaled without TaskInstance.MarshalJSON
+type taskInstanceAlias TaskInstance
+
+// MarshalJSON used by json, it omits nil branches but keeps empty ones
+func (t TaskInstance) MarshalJSON() ([]byte, error) {
+	aux := taskInstanceJSON{taskInstanceAlias: (*taskInstanceAlias)(&t)}
+	if t.Branches != nil {
+		aux.Branches = &t.Branches
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON used by json
+func (t *TaskInstance) UnmarshalJSON(data []byte) error {
+	aux := taskInstanceJSON{taskInstanceAlias: (*taskInstanceAlias)(t)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Branches != nil {
+		t.Branches = *aux.Branches
+		if t.Branches == nil {
+			t.Branches = []string{}
+		}
+	}
+	return nil
+}
+
 // InitialDep initial task instance
 func (t *TaskInstance) InitialDep(ctx run.ExecuteContext, patch func(*TaskInstance) error, dagIns *DagInstance) {
 	t.Patch = patch
@@ -206,13 +732,77 @@ func (t *TaskInstance) InitialDep(ctx run.ExecuteContext, patch func(*TaskInstan
 // SetStatus will persist task instance
 func (t *TaskInstance) SetStatus(s TaskInstanceStatus) error {
 	t.Status = s
-	patch := &TaskInstance{ID: t.ID, Status: t.Status, Reason: t.Reason}
+	t.endAttempt()
+	patch := &TaskInstance{
+		ID:       t.ID,
+		Status:   t.Status,
+		Reason:   t.Reason,
+		Attempt:  t.Attempt,
+		Attempts: t.Attempts,
+		RetryAt:  t.RetryAt,
+		Branches: t.Branches,
+		Output:   t.Output,
+	}
 	if len(t.bufTraces) != 0 {
 		patch.Traces = append(t.Traces, t.bufTraces...)
 	}
 	return t.Patch(patch)
 }
 
+// beginAttempt record the start of current attempt if it is not recorded
+func (t *TaskInstance) beginAttempt() {
+	if t.Attempt == 0 {
+		t.Attempt = 1
+	}
+	if last := t.lastAttempt(); last != nil && last.Attempt >= t.Attempt {
+		if last.EndedAt == 0 {
+			return
+		}
+		// the last attempt has ended but attempt number is not increased, such as retried by command
+		t.Attempt = last.Attempt + 1
+	}
+
+	var worker string
+	if t.RelatedDagInstance != nil {
+		worker = t.RelatedDagInstance.Worker
+	}
+	t.Attempts = append(t.Attempts, TaskAttempt{
+		Attempt:   t.Attempt,
+		Worker:    worker,
+		StartedAt: time.Now().Unix(),
+		Status:    TaskInstanceStatusRunning,
+	})
+}
+
+// endAttempt record the outcome of current attempt when task leave running
+func (t *TaskInstance) endAttempt() {
+	last := t.lastAttempt()
+	if last == nil || last.EndedAt != 0 {
+		return
+	}
+
+	switch t.Status {
+	case TaskInstanceStatusSuccess, TaskInstanceStatusFailed, TaskInstanceStatusCanceled:
+		last.Status = t.Status
+	case TaskInstanceStatusRetrying:
+		// retrying means current attempt failed
+		last.Status = TaskInstanceStatusFailed
+	default:
+		return
+	}
+	last.EndedAt = time.Now().Unix()
+	if last.Status != TaskInstanceStatusSuccess {
+		last.Reason = t.Reason
+	}
+}
+
+func (t *TaskInstance) lastAttempt() *TaskAttempt {
+	if len(t.Attempts) == 0 {
+		return nil
+	}
+	return &t.Attempts[len(t.Attempts)-1]
+}
+
 // Trace info
 func (t *TaskInstance) Trace(msg string, ops ...run.TraceOp) {
 	opt := run.NewTraceOption(ops...)
@@ -253,6 +843,10 @@ func (t *TaskInstance) Run(params interface{}, act run.Action) (err error) {
 
 	}()
 
+	if t.Status == TaskInstanceStatusInit || t.Status == TaskInstanceStatusEnding {
+		t.beginAttempt()
+	}
+
 	if t.Status == TaskInstanceStatusInit {
 		beforeAct, ok := act.(run.BeforeAction)
 		if ok {
@@ -299,14 +893,18 @@ func (t *TaskInstance) Run(params interface{}, act run.Action) (err error) {
 	return nil
 }
 
-// DoPreCheck do pre-check
-func (t *TaskInstance) DoPreCheck(dagIns *DagInstance) (isActive bool, err error) {
+// DoPreCheck do pre-check, tasks is used by the conditions which depend on other tasks
+func (t *TaskInstance) DoPreCheck(dagIns *DagInstance, tasks TaskInsGetter) (isActive bool, err error) {
 	if t.PreChecks == nil {
 		return
 	}
 
 	for k, c := range t.PreChecks {
-		if c.IsMeet(dagIns) {
+		meet, err := c.IsMeet(dagIns, tasks)
+		if err != nil {
+			return false, fmt.Errorf("pre-check[%s] failed: %w", k, err)
+		}
+		if meet {
 			switch c.Act {
 			case ActiveActionSkip:
 				t.Status = TaskInstanceStatusSkipped
@@ -316,7 +914,7 @@ func (t *TaskInstance) DoPreCheck(dagIns *DagInstance) (isActive bool, err error
 				return false, fmt.Errorf("pre-check[%s] act is invalid: %s", k, c.Act)
 			}
 			isActive = true
-			return
+			return isActive, nil
 		}
 	}
 
@@ -336,4 +934,9 @@ const (
 	TaskInstanceStatusSuccess  TaskInstanceStatus = "success"
 	TaskInstanceStatusBlocked  TaskInstanceStatus = "blocked"
 	TaskInstanceStatusSkipped  TaskInstanceStatus = "skipped"
+	// TaskInstanceStatusUpstreamFailed means the trigger rule of task can never be met by its upstream tasks,
+	// so it will not be executed, it is treated as init again when its upstream tasks are retried or rerun
+	TaskInstanceStatusUpstreamFailed TaskInstanceStatus = "upstream_failed"
+	// TaskInstanceStatusWaiting means the task is waiting for a free slot of its pool, or the rate limit of its action
+	TaskInstanceStatusWaiting TaskInstanceStatus = "waiting"
 )
@@ -1,11 +1,13 @@
 package entity
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/weeyp/fastflow/pkg/entity/run"
 )
 
@@ -161,10 +163,11 @@ func TestTaskConditionSource_BuildKvGetter(t *testing.T) {
 		caseDesc   string
 		giveSource TaskConditionSource
 		giveDagIns *DagInstance
+		giveTasks  TaskInsGetter
 		giveKey    string
 		wantFind   bool
 		wantVal    string
-		wantPanic  string
+		wantErr    error
 	}{
 		{
 			caseDesc:   "vars",
@@ -196,33 +199,307 @@ func TestTaskConditionSource_BuildKvGetter(t *testing.T) {
 			wantVal:  "value2",
 		},
 		{
-			caseDesc:   "panic",
+			caseDesc:   "task status",
+			giveSource: TaskConditionSourceTaskStatus,
+			giveDagIns: &DagInstance{},
+			giveTasks: func(taskId string) (*TaskInstance, bool) {
+				return &TaskInstance{Status: TaskInstanceStatusFailed}, taskId == "task1"
+			},
+			giveKey:  "task1",
+			wantFind: true,
+			wantVal:  string(TaskInstanceStatusFailed),
+		},
+		{
+			caseDesc:   "task status not available",
+			giveSource: TaskConditionSourceTaskStatus,
+			giveDagIns: &DagInstance{},
+			giveKey:    "task1",
+			wantErr:    fmt.Errorf("task status is not available"),
+		},
+		{
+			caseDesc:   "invalid source",
 			giveSource: "test",
 			giveDagIns: &DagInstance{
 				Vars: DagInstanceVars{
 					"key1": DagInstanceVar{Value: "value1"},
 				},
 			},
-			giveKey:   "key1",
-			wantPanic: "task condition source test is not valid",
+			giveKey: "key1",
+			wantErr: fmt.Errorf("task condition source test is not valid"),
 		},
 	}
 
 	for _, tc := range tests {
-		doTest := func() {
-			if tc.wantPanic != "" {
-				defer func() {
-					err := recover()
-					assert.Equal(t, tc.wantPanic, err, tc.caseDesc)
-				}()
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			g, err := tc.giveSource.BuildKvGetter(tc.giveDagIns, tc.giveTasks)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
 			}
-
-			g := tc.giveSource.BuildKvGetter(tc.giveDagIns)
 			val, ok := g(tc.giveKey)
-			assert.Equal(t, tc.wantVal, val, tc.caseDesc)
-			assert.Equal(t, tc.wantFind, ok, tc.caseDesc)
+			assert.Equal(t, tc.wantVal, val)
+			assert.Equal(t, tc.wantFind, ok)
+		})
+	}
+}
+
+func TestTaskCondition_IsMeet(t *testing.T) {
+	dagIns := &DagInstance{
+		Vars: DagInstanceVars{
+			"env":   {Value: "prod"},
+			"count": {Value: "10"},
+		},
+	}
+	tasks := func(taskId string) (*TaskInstance, bool) {
+		if taskId == "upstream" {
+			return &TaskInstance{Status: TaskInstanceStatusFailed}, true
+		}
+		return nil, false
+	}
+	tests := []struct {
+		caseDesc  string
+		giveCond  TaskCondition
+		wantMeet  bool
+		wantError bool
+	}{
+		{
+			caseDesc: "eq",
+			giveCond: TaskCondition{Source: TaskConditionSourceVars, Key: "env", Op: OperatorEq, Values: []string{"prod"}},
+			wantMeet: true,
+		},
+		{
+			caseDesc: "ne",
+			giveCond: TaskCondition{Source: TaskConditionSourceVars, Key: "env", Op: OperatorNe, Values: []string{"prod"}},
+			wantMeet: false,
+		},
+		{
+			caseDesc: "exists",
+			giveCond: TaskCondition{Source: TaskConditionSourceVars, Key: "env", Op: OperatorExists},
+			wantMeet: true,
+		},
+		{
+			caseDesc: "not exists",
+			giveCond: TaskCondition{Source: TaskConditionSourceVars, Key: "missing", Op: OperatorNotExists},
+			wantMeet: true,
+		},
+		{
+			caseDesc: "regex",
+			giveCond: TaskCondition{Source: TaskConditionSourceVars, Key: "env", Op: OperatorRegex, Values: []string{"^pr"}},
+			wantMeet: true,
+		},
+		{
+			caseDesc: "gt",
+			giveCond: TaskCondition{Source: TaskConditionSourceVars, Key: "count", Op: OperatorGt, Values: []string{"9.5"}},
+			wantMeet: true,
+		},
+		{
+			caseDesc: "lte",
+			giveCond: TaskCondition{Source: TaskConditionSourceVars, Key: "count", Op: OperatorLte, Values: []string{"9"}},
+			wantMeet: false,
+		},
+		{
+			caseDesc: "number compare with not number value",
+			giveCond: TaskCondition{Source: TaskConditionSourceVars, Key: "env", Op: OperatorGte, Values: []string{"1"}},
+			wantMeet: false,
+		},
+		{
+			caseDesc: "task status",
+			giveCond: TaskCondition{Source: TaskConditionSourceTaskStatus, Key: "upstream", Op: OperatorEq, Values: []string{"failed"}},
+			wantMeet: true,
+		},
+		{
+			caseDesc: "any",
+			giveCond: TaskCondition{Any: []TaskCondition{
+				{Source: TaskConditionSourceVars, Key: "env", Op: OperatorEq, Values: []string{"dev"}},
+				{Source: TaskConditionSourceVars, Key: "count", Op: OperatorLt, Values: []string{"11"}},
+			}},
+			wantMeet: true,
+		},
+		{
+			caseDesc: "all",
+			giveCond: TaskCondition{All: []TaskCondition{
+				{Source: TaskConditionSourceVars, Key: "env", Op: OperatorEq, Values: []string{"prod"}},
+				{Source: TaskConditionSourceVars, Key: "count", Op: OperatorLt, Values: []string{"10"}},
+			}},
+			wantMeet: false,
+		},
+		{
+			caseDesc:  "invalid source",
+			giveCond:  TaskCondition{Source: "invalid", Key: "env", Op: OperatorExists},
+			wantError: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			meet, err := tc.giveCond.IsMeet(dagIns, tasks)
+			assert.Equal(t, tc.wantError, err != nil)
+			assert.Equal(t, tc.wantMeet, meet)
+		})
+	}
+}
+
+func TestCheck_IsMeet(t *testing.T) {
+	dagIns := &DagInstance{
+		Vars: DagInstanceVars{
+			"env": {Value: "prod"},
+		},
+		ShareData: &ShareData{
+			Dict: map[string]string{"count": "5"},
+		},
+	}
+	tasks := func(taskId string) (*TaskInstance, bool) {
+		if taskId == "upstream" {
+			return &TaskInstance{
+				Status: TaskInstanceStatusSuccess,
+				Output: map[string]interface{}{"size": 3},
+			}, true
 		}
-		doTest()
+		return nil, false
+	}
+	tests := []struct {
+		caseDesc  string
+		giveCheck *Check
+		wantMeet  bool
+		wantErr   bool
+	}{
+		{
+			caseDesc:  "when",
+			giveCheck: &Check{When: "vars.env == 'prod' && shareData.count > 3"},
+			wantMeet:  true,
+		},
+		{
+			caseDesc:  "when with task output",
+			giveCheck: &Check{When: "tasks.upstream.status == 'success' && tasks.upstream.output.size < 3"},
+			wantMeet:  false,
+		},
+		{
+			caseDesc: "when and conditions",
+			giveCheck: &Check{
+				When:       "vars.env == 'prod'",
+				Conditions: []TaskCondition{{Source: TaskConditionSourceShareData, Key: "count", Op: OperatorEq, Values: []string{"4"}}},
+			},
+			wantMeet: false,
+		},
+		{
+			caseDesc:  "invalid when",
+			giveCheck: &Check{When: "env == 'prod'"},
+			wantErr:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			meet, err := tc.giveCheck.IsMeet(dagIns, tasks)
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantMeet, meet)
+		})
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	src := "vars.env == 'prod'"
+	e, err := parseExpr(src)
+	require.NoError(t, err)
+	again, err := parseExpr(src)
+	require.NoError(t, err)
+	// the expression is parsed once and reused by the later evaluations
+	assert.Same(t, e, again)
+
+	_, err = parseExpr("vars.env ==")
+	assert.Error(t, err)
+}
+
+func TestPreChecks_Validate(t *testing.T) {
+	tests := []struct {
+		caseDesc      string
+		givePreChecks PreChecks
+		wantErr       bool
+	}{
+		{
+			caseDesc: "valid",
+			givePreChecks: PreChecks{
+				"check": {
+					Conditions: []TaskCondition{
+						{Source: TaskConditionSourceVars, Key: "env", Op: OperatorIn, Values: []string{"prod"}},
+						{Any: []TaskCondition{
+							{Source: TaskConditionSourceTaskStatus, Key: "upstream", Op: OperatorEq, Values: []string{"failed"}},
+							{Source: TaskConditionSourceShareData, Key: "count", Op: OperatorGt, Values: []string{"1"}},
+						}},
+					},
+					Act: ActiveActionSkip,
+				},
+			},
+		},
+		{
+			caseDesc: "empty act",
+			givePreChecks: PreChecks{
+				"check": {When: "vars.env == 'prod'"},
+			},
+		},
+		{
+			caseDesc: "invalid act",
+			givePreChecks: PreChecks{
+				"check": {Act: "invalid"},
+			},
+			wantErr: true,
+		},
+		{
+			caseDesc: "invalid source",
+			givePreChecks: PreChecks{
+				"check": {
+					Conditions: []TaskCondition{{Source: "invalid", Key: "env", Op: OperatorExists}},
+					Act:        ActiveActionSkip,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			caseDesc: "invalid regex",
+			givePreChecks: PreChecks{
+				"check": {
+					Conditions: []TaskCondition{{Source: TaskConditionSourceVars, Key: "env", Op: OperatorRegex, Values: []string{"("}}},
+					Act:        ActiveActionSkip,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			caseDesc: "not number",
+			givePreChecks: PreChecks{
+				"check": {
+					Conditions: []TaskCondition{{Source: TaskConditionSourceVars, Key: "env", Op: OperatorGt, Values: []string{"a"}}},
+					Act:        ActiveActionSkip,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			caseDesc: "invalid when",
+			givePreChecks: PreChecks{
+				"check": {
+					When: "vars.env ==",
+					Act:  ActiveActionSkip,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			caseDesc: "group with source",
+			givePreChecks: PreChecks{
+				"check": {
+					Conditions: []TaskCondition{{Source: TaskConditionSourceVars, All: []TaskCondition{
+						{Source: TaskConditionSourceVars, Key: "env", Op: OperatorExists},
+					}}},
+					Act: ActiveActionSkip,
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			err := tc.givePreChecks.Validate()
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
 	}
 }
 
@@ -513,7 +790,7 @@ func TestTaskInstance_DoPreCheck(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
-			ret, err := tc.giveTaskIns.DoPreCheck(tc.giveDagIns)
+			ret, err := tc.giveTaskIns.DoPreCheck(tc.giveDagIns, nil)
 			assert.Equal(t, tc.wantRet, ret)
 			assert.Equal(t, tc.wantErr, err)
 			if err == nil {
@@ -522,3 +799,216 @@ func TestTaskInstance_DoPreCheck(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryPolicy_ShouldRetry(t *testing.T) {
+	tests := []struct {
+		caseDesc    string
+		givePolicy  *RetryPolicy
+		giveAttempt int
+		giveErr     error
+		wantRetry   bool
+	}{
+		{
+			caseDesc:    "nil policy",
+			giveAttempt: 1,
+			giveErr:     fmt.Errorf("failed"),
+			wantRetry:   false,
+		},
+		{
+			caseDesc:    "any error",
+			givePolicy:  &RetryPolicy{MaxAttempts: 3},
+			giveAttempt: 2,
+			giveErr:     fmt.Errorf("failed"),
+			wantRetry:   true,
+		},
+		{
+			caseDesc:    "attempts exhausted",
+			givePolicy:  &RetryPolicy{MaxAttempts: 3},
+			giveAttempt: 3,
+			giveErr:     fmt.Errorf("failed"),
+			wantRetry:   false,
+		},
+		{
+			caseDesc:    "error matched",
+			givePolicy:  &RetryPolicy{MaxAttempts: 3, RetryOn: []string{"timeout", "connection refused"}},
+			giveAttempt: 1,
+			giveErr:     fmt.Errorf("dial tcp: connection refused"),
+			wantRetry:   true,
+		},
+		{
+			caseDesc:    "error not matched",
+			givePolicy:  &RetryPolicy{MaxAttempts: 3, RetryOn: []string{"^timeout$"}},
+			giveAttempt: 1,
+			giveErr:     fmt.Errorf("request timeout"),
+			wantRetry:   false,
+		},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.wantRetry, tc.givePolicy.ShouldRetry(tc.giveAttempt, tc.giveErr), tc.caseDesc)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	tests := []struct {
+		caseDesc    string
+		givePolicy  *RetryPolicy
+		giveAttempt int
+		wantDelay   time.Duration
+	}{
+		{
+			caseDesc:    "no delay",
+			givePolicy:  &RetryPolicy{MaxAttempts: 3},
+			giveAttempt: 2,
+			wantDelay:   0,
+		},
+		{
+			caseDesc:    "fixed",
+			givePolicy:  &RetryPolicy{Backoff: BackoffTypeFixed, InitialDelay: "2s"},
+			giveAttempt: 3,
+			wantDelay:   2 * time.Second,
+		},
+		{
+			caseDesc:    "exponential",
+			givePolicy:  &RetryPolicy{Backoff: BackoffTypeExponential, InitialDelay: "2s"},
+			giveAttempt: 3,
+			wantDelay:   8 * time.Second,
+		},
+		{
+			caseDesc:    "exponential with max delay",
+			givePolicy:  &RetryPolicy{Backoff: BackoffTypeExponential, InitialDelay: "2s", MaxDelay: "5s"},
+			giveAttempt: 10,
+			wantDelay:   5 * time.Second,
+		},
+		{
+			caseDesc:    "exponential is limited by default max delay",
+			givePolicy:  &RetryPolicy{Backoff: BackoffTypeExponential, InitialDelay: "1s"},
+			giveAttempt: 100,
+			wantDelay:   DefaultMaxRetryDelay,
+		},
+		{
+			caseDesc:    "exponential does not overflow with huge max delay",
+			givePolicy:  &RetryPolicy{Backoff: BackoffTypeExponential, InitialDelay: "1s", MaxDelay: "2500000h"},
+			giveAttempt: 100,
+			wantDelay:   2500000 * time.Hour,
+		},
+		{
+			caseDesc:    "fixed is not limited by default max delay",
+			givePolicy:  &RetryPolicy{Backoff: BackoffTypeFixed, InitialDelay: "2h"},
+			giveAttempt: 3,
+			wantDelay:   2 * time.Hour,
+		},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.wantDelay, tc.givePolicy.Delay(tc.giveAttempt), tc.caseDesc)
+	}
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		caseDesc   string
+		givePolicy *RetryPolicy
+		wantErr    bool
+	}{
+		{
+			caseDesc:   "normal",
+			givePolicy: &RetryPolicy{MaxAttempts: 3, Backoff: BackoffTypeExponential, InitialDelay: "1s", MaxDelay: "1m", RetryOn: []string{"timeout"}},
+		},
+		{caseDesc: "negative attempts", givePolicy: &RetryPolicy{MaxAttempts: -1}, wantErr: true},
+		{caseDesc: "invalid backoff", givePolicy: &RetryPolicy{Backoff: "linear"}, wantErr: true},
+		{caseDesc: "invalid delay", givePolicy: &RetryPolicy{InitialDelay: "1"}, wantErr: true},
+		{caseDesc: "negative delay", givePolicy: &RetryPolicy{MaxDelay: "-1s"}, wantErr: true},
+		{caseDesc: "invalid pattern", givePolicy: &RetryPolicy{RetryOn: []string{"("}}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		err := tc.givePolicy.Validate()
+		assert.Equal(t, tc.wantErr, err != nil, tc.caseDesc)
+	}
+}
+
+type testAction struct {
+	err error
+}
+
+func (a *testAction) Name() string {
+	return "test"
+}
+
+func (a *testAction) Run(ctx run.ExecuteContext, params interface{}) error {
+	return a.err
+}
+
+func TestTaskInstance_Attempts(t *testing.T) {
+	taskIns := &TaskInstance{
+		ID:                 "test-id",
+		Status:             TaskInstanceStatusInit,
+		Attempt:            1,
+		RelatedDagInstance: &DagInstance{Worker: "worker-1"},
+	}
+	var lastPatch *TaskInstance
+	taskIns.Patch = func(instance *TaskInstance) error {
+		lastPatch = instance
+		return nil
+	}
+
+	// first attempt failed and will be retried
+	err := taskIns.Run(nil, &testAction{err: fmt.Errorf("failed")})
+	assert.Error(t, err)
+	taskIns.Reason = err.Error()
+	taskIns.Attempt++
+	assert.NoError(t, taskIns.SetStatus(TaskInstanceStatusRetrying))
+
+	// retry before, then second attempt success
+	assert.NoError(t, taskIns.Run(nil, &testAction{}))
+	assert.Equal(t, TaskInstanceStatusInit, taskIns.Status)
+	assert.NoError(t, taskIns.Run(nil, &testAction{}))
+	assert.Equal(t, TaskInstanceStatusSuccess, taskIns.Status)
+
+	assert.Len(t, taskIns.Attempts, 2)
+	assert.Equal(t, taskIns.Attempts, lastPatch.Attempts)
+	for i, a := range taskIns.Attempts {
+		assert.Equal(t, i+1, a.Attempt)
+		assert.Equal(t, "worker-1", a.Worker)
+		assert.NotZero(t, a.StartedAt)
+		assert.NotZero(t, a.EndedAt)
+	}
+	assert.Equal(t, TaskInstanceStatusFailed, taskIns.Attempts[0].Status)
+	assert.Equal(t, "run failed: failed", taskIns.Attempts[0].Reason)
+	assert.Equal(t, TaskInstanceStatusSuccess, taskIns.Attempts[1].Status)
+	assert.Empty(t, taskIns.Attempts[1].Reason)
+
+	// retried by command without increasing attempt number
+	taskIns.Status = TaskInstanceStatusInit
+	assert.NoError(t, taskIns.Run(nil, &testAction{}))
+	assert.Equal(t, 3, taskIns.Attempt)
+	assert.Len(t, taskIns.Attempts, 3)
+}
+
+func TestTaskInstance_BranchesJSON(t *testing.T) {
+	tests := []struct {
+		caseDesc     string
+		giveBranches []string
+		wantJSON     string
+	}{
+		{caseDesc: "not a branch task", giveBranches: nil, wantJSON: `{"timeoutSecs":0}`},
+		{caseDesc: "no branch is chosen", giveBranches: []string{}, wantJSON: `{"timeoutSecs":0,"branches":[]}`},
+		{caseDesc: "branches are chosen", giveBranches: []string{"task1"}, wantJSON: `{"timeoutSecs":0,"branches":["task1"]}`},
+	}
+	for _, tc := range tests {
+		b, err := json.Marshal(&TaskInstance{Branches: tc.giveBranches})
+		assert.NoError(t, err, tc.caseDesc)
+		assert.JSONEq(t, tc.wantJSON, string(b), tc.caseDesc)
+		ret := &TaskInstance{}
+		assert.NoError(t, json.Unmarshal(b, ret), tc.caseDesc)
+		assert.Equal(t, tc.giveBranches, ret.Branches, tc.caseDesc)
+
+		// it is the same when task instance is marshaled as a value
+		b, err = json.Marshal([]TaskInstance{{Branches: tc.giveBranches}})
+		assert.NoError(t, err, tc.caseDesc)
+		var rets []TaskInstance
+		assert.NoError(t, json.Unmarshal(b, &rets), tc.caseDesc)
+		assert.Equal(t, tc.giveBranches, rets[0].Branches, tc.caseDesc)
+	}
+}
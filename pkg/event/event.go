@@ -8,6 +8,7 @@ const (
 
 	KeyTaskCompleted = "TaskCompleted"
 	KeyTaskBegin     = "TaskBegin"
+	KeySLAMissed     = "SLAMissed"
 
 	KeyLeaderChanged                = "LeaderChanged"
 	KeyDispatchInitDagInsCompleted  = "DispatchInitDagInsCompleted"
@@ -55,6 +56,16 @@ func (e *TaskBegin) Topic() []string {
 	return []string{KeyTaskBegin}
 }
 
+// SLAMissed will raise when a running dag instance exceeds its sla
+type SLAMissed struct {
+	DagIns *entity.DagInstance
+}
+
+// Topic
+func (e *SLAMissed) Topic() []string {
+	return []string{KeySLAMissed}
+}
+
 // LeaderChanged will raise when leader changed such as campaign success or continue leader failed
 type LeaderChanged struct {
 	IsLeader  bool
@@ -0,0 +1,420 @@
+// Package expr is a small and sandboxed expression language,
+// it only supports literals, member access, boolean, comparison and arithmetic operators, such as
+// "vars.env == 'prod' && shareData.count > 3" or "tasks['list-files'].status in ['success', 'skipped']".
+// there is no function call or assignment, so it is safe to evaluate expressions written in dag definition.
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Getter is used to look up the value of identifier or member, so the values can be loaded on demand
+type Getter interface {
+	Get(key string) (interface{}, bool)
+}
+
+// GetterFunc is a function implements Getter
+type GetterFunc func(key string) (interface{}, bool)
+
+// Get
+func (f GetterFunc) Get(key string) (interface{}, bool) {
+	return f(key)
+}
+
+// Env is a Getter based on map
+type Env map[string]interface{}
+
+// Get
+func (e Env) Get(key string) (interface{}, bool) {
+	v, ok := e[key]
+	return v, ok
+}
+
+// Expr is a parsed expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse parse the expression
+func Parse(src string) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, fmt.Errorf("parse expression %q failed: %w", src, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("parse expression %q failed: %w", src, err)
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("parse expression %q failed: unexpected %q at %d", src, t.text, t.pos)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String return the source of expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluate the expression, the result is one of nil, bool, float64, string, slice or map
+func (e *Expr) Eval(env Getter) (interface{}, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate expression %q failed: %w", e.src, err)
+	}
+	return v, nil
+}
+
+// EvalBool evaluate the expression and convert the result to bool
+func (e *Expr) EvalBool(env Getter) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	return Truthy(v), nil
+}
+
+// Truthy return if the value is treated as true,
+// nil, false, 0, empty string, empty collection and the strings which can be parsed as false are treated as false
+func Truthy(v interface{}) bool {
+	v = normalize(v)
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case float64:
+		return val != 0
+	case string:
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+		return val != ""
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return rv.Len() > 0
+	}
+	return true
+}
+
+// ToString convert the value to string, collections are converted to json
+func ToString(v interface{}) string {
+	v = normalize(v)
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(bs)
+}
+
+func (n *literalNode) eval(env Getter) (interface{}, error) {
+	return n.val, nil
+}
+
+func (n *identNode) eval(env Getter) (interface{}, error) {
+	if env == nil {
+		return nil, fmt.Errorf("undefined identifier %q", n.name)
+	}
+	v, ok := env.Get(n.name)
+	if !ok {
+		return nil, fmt.Errorf("undefined identifier %q", n.name)
+	}
+	return normalize(v), nil
+}
+
+func (n *memberNode) eval(env Getter) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return member(target, key)
+}
+
+func (n *listNode) eval(env Getter) (interface{}, error) {
+	list := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (n *unaryNode) eval(env Getter) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !Truthy(x), nil
+	}
+	f, ok := toNumber(x)
+	if !ok {
+		return nil, fmt.Errorf("operator - need a number, but got %v", x)
+	}
+	return -f, nil
+}
+
+func (n *binaryNode) eval(env Getter) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit evaluation
+	switch n.op {
+	case "&&":
+		if !Truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		return Truthy(right), err
+	case "||":
+		if Truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		return Truthy(right), err
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "<", "<=", ">", ">=":
+		return compare(n.op, left, right)
+	case "in":
+		return contains(right, left)
+	case "+":
+		l, lok := toNumber(left)
+		r, rok := toNumber(right)
+		if lok && rok {
+			return l + r, nil
+		}
+		_, lstr := left.(string)
+		_, rstr := right.(string)
+		if lstr || rstr {
+			return ToString(left) + ToString(right), nil
+		}
+		return nil, fmt.Errorf("operator + need numbers or strings, but got %v and %v", left, right)
+	}
+	return arithmetic(n.op, left, right)
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %s need numbers, but got %v and %v", op, left, right)
+	}
+	switch op {
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+func equal(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	_, lnum := left.(float64)
+	_, rnum := right.(float64)
+	if lnum || rnum {
+		l, lok := toNumber(left)
+		r, rok := toNumber(right)
+		return lok && rok && l == r
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func compare(op string, left, right interface{}) (bool, error) {
+	var c int
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	ls, lstr := left.(string)
+	rs, rstr := right.(string)
+	switch {
+	case lok && rok:
+		switch {
+		case l < r:
+			c = -1
+		case l > r:
+			c = 1
+		}
+	case lstr && rstr:
+		c = strings.Compare(ls, rs)
+	default:
+		return false, fmt.Errorf("operator %s can not compare %v and %v", op, left, right)
+	}
+
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+// contains return if the item is in the collection,
+// it means substring for string and key for map
+func contains(collection, item interface{}) (bool, error) {
+	switch c := collection.(type) {
+	case nil:
+		return false, nil
+	case string:
+		return strings.Contains(c, ToString(item)), nil
+	case Getter:
+		_, ok := c.Get(ToString(item))
+		return ok, nil
+	}
+
+	rv := reflect.ValueOf(collection)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if equal(normalize(rv.Index(i).Interface()), item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		return rv.MapIndex(reflect.ValueOf(ToString(item)).Convert(rv.Type().Key())).IsValid(), nil
+	}
+	return false, fmt.Errorf("operator in need a list, map or string, but got %v", collection)
+}
+
+// member return the member of target, missing member is nil
+func member(target, key interface{}) (interface{}, error) {
+	switch t := target.(type) {
+	case nil:
+		return nil, nil
+	case Getter:
+		v, _ := t.Get(ToString(key))
+		return normalize(v), nil
+	}
+
+	rv := reflect.ValueOf(target)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		v := rv.MapIndex(reflect.ValueOf(ToString(key)).Convert(rv.Type().Key()))
+		if !v.IsValid() {
+			return nil, nil
+		}
+		return normalize(v.Interface()), nil
+	case reflect.Slice, reflect.Array:
+		f, ok := toNumber(key)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("index of list must be an integer, but got %v", key)
+		}
+		i := int(f)
+		if i < 0 || i >= rv.Len() {
+			return nil, nil
+		}
+		return normalize(rv.Index(i).Interface()), nil
+	}
+	return nil, fmt.Errorf("can not get member %v of %v", key, target)
+}
+
+// normalize convert numbers to float64, and named string or bool types to their underlying types
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, bool, float64, string:
+		return v
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case int32:
+		return float64(val)
+	case float32:
+		return float64(val)
+	case uint:
+		return float64(val)
+	case uint64:
+		return float64(val)
+	case uint32:
+		return float64(val)
+	case json.Number:
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val.String()
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	return v
+}
+
+// toNumber convert the value to number, the strings which can be parsed as number are converted too
+func toNumber(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,118 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEnv() Getter {
+	return Env{
+		"vars": map[string]string{
+			"env":    "prod",
+			"dryRun": "false",
+		},
+		"shareData": GetterFunc(func(key string) (interface{}, bool) {
+			if key == "count" {
+				return "5", true
+			}
+			return nil, false
+		}),
+		"tasks": map[string]interface{}{
+			"list-files": map[string]interface{}{
+				"status": "success",
+				"output": map[string]interface{}{
+					"files": []interface{}{"a.txt", "b.txt"},
+					"size":  3,
+				},
+			},
+		},
+	}
+}
+
+func TestExpr_Eval(t *testing.T) {
+	tests := []struct {
+		caseDesc string
+		giveExpr string
+		wantVal  interface{}
+		wantErr  bool
+	}{
+		{caseDesc: "literal", giveExpr: "'a\\'b'", wantVal: "a'b"},
+		{caseDesc: "arithmetic", giveExpr: "1 + 2 * 3 - 4 / 2 % 3", wantVal: float64(5)},
+		{caseDesc: "parentheses", giveExpr: "(1 + 2) * -3", wantVal: float64(-9)},
+		{caseDesc: "string number", giveExpr: "shareData.count + 1", wantVal: float64(6)},
+		{caseDesc: "concat", giveExpr: "vars.env + '-' + 1", wantVal: "prod-1"},
+		{caseDesc: "and or", giveExpr: "vars.env == 'prod' && shareData.count > 3 || false", wantVal: true},
+		{caseDesc: "not", giveExpr: "!vars.dryRun", wantVal: true},
+		{caseDesc: "compare string", giveExpr: "vars.env < 'qa'", wantVal: true},
+		{caseDesc: "index", giveExpr: "tasks['list-files'].output.files[1]", wantVal: "b.txt"},
+		{caseDesc: "int output", giveExpr: "tasks['list-files'].output.size >= 3", wantVal: true},
+		{caseDesc: "in list", giveExpr: "tasks['list-files'].status in ['success', 'skipped']", wantVal: true},
+		{caseDesc: "in map", giveExpr: "'env' in vars", wantVal: true},
+		{caseDesc: "missing member", giveExpr: "tasks.missing.output.size == nil", wantVal: true},
+		{caseDesc: "missing getter key", giveExpr: "shareData.missing", wantVal: nil},
+		{caseDesc: "undefined identifier", giveExpr: "var.env", wantErr: true},
+		{caseDesc: "division by zero", giveExpr: "1 / 0", wantErr: true},
+		{caseDesc: "invalid compare", giveExpr: "vars.env > 1", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			e, err := Parse(tc.giveExpr)
+			assert.NoError(t, err)
+			v, err := e.Eval(testEnv())
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantVal, v)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		caseDesc string
+		giveExpr string
+		wantErr  bool
+	}{
+		{caseDesc: "valid", giveExpr: "a.b[0] != 'x' && !(c > 1)"},
+		{caseDesc: "empty", giveExpr: "", wantErr: true},
+		{caseDesc: "unclosed parentheses", giveExpr: "(1 + 2", wantErr: true},
+		{caseDesc: "unterminated string", giveExpr: "'abc", wantErr: true},
+		{caseDesc: "unexpected token", giveExpr: "1 2", wantErr: true},
+		{caseDesc: "invalid character", giveExpr: "a = 1", wantErr: true},
+		{caseDesc: "function call", giveExpr: "exit(1)", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			_, err := Parse(tc.giveExpr)
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
+	}
+}
+
+func TestTemplate_Render(t *testing.T) {
+	tests := []struct {
+		caseDesc string
+		giveTpl  string
+		wantVal  interface{}
+		wantErr  bool
+	}{
+		{caseDesc: "single expression keeps type", giveTpl: "${{ shareData.count * 2 }}", wantVal: float64(10)},
+		{caseDesc: "mixed", giveTpl: "deploy-${{vars.env}}-${{ tasks['list-files'].output.size }}.log", wantVal: "deploy-prod-3.log"},
+		{caseDesc: "brace in string", giveTpl: "${{ '}}' + vars.env }}", wantVal: "}}prod"},
+		{caseDesc: "shell variable", giveTpl: "echo ${HOME}", wantVal: "echo ${HOME}"},
+		{caseDesc: "no expression", giveTpl: "plain", wantVal: "plain"},
+		{caseDesc: "not closed", giveTpl: "${{ vars.env }", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			tpl, err := ParseTemplate(tc.giveTpl)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			v, err := tpl.Render(testEnv())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantVal, v)
+		})
+	}
+}
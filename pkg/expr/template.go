@@ -0,0 +1,88 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	templateStart = "${{"
+	templateEnd   = "}}"
+)
+
+// Template is a string which contains expressions such as "file-${{ vars.date }}.txt",
+// the delimiters are different from shell variables, so scripts in params are not affected
+type Template struct {
+	// texts and exprs are interleaved, texts[i] is followed by exprs[i]
+	texts []string
+	exprs []*Expr
+}
+
+// HasTemplate return if the string contains expressions
+func HasTemplate(s string) bool {
+	return strings.Contains(s, templateStart)
+}
+
+// ParseTemplate parse the expressions in the string
+func ParseTemplate(s string) (*Template, error) {
+	tpl := &Template{}
+	for {
+		start := strings.Index(s, templateStart)
+		if start < 0 {
+			tpl.texts = append(tpl.texts, s)
+			return tpl, nil
+		}
+		end, err := findTemplateEnd(s, start+len(templateStart))
+		if err != nil {
+			return nil, err
+		}
+		e, err := Parse(strings.TrimSpace(s[start+len(templateStart) : end]))
+		if err != nil {
+			return nil, err
+		}
+		tpl.texts = append(tpl.texts, s[:start])
+		tpl.exprs = append(tpl.exprs, e)
+		s = s[end+len(templateEnd):]
+	}
+}
+
+// findTemplateEnd find the end of expression, it skips the "}}" in quoted strings
+func findTemplateEnd(s string, from int) (int, error) {
+	var quote byte
+	for i := from; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], templateEnd):
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("expression in %q is not closed", s)
+}
+
+// Render evaluate the expressions, if the template is a single expression such as "${{ shareData.count + 1 }}",
+// the result keeps its type, otherwise the results are converted to string and joined with texts
+func (t *Template) Render(env Getter) (interface{}, error) {
+	if len(t.exprs) == 1 && t.texts[0] == "" && t.texts[1] == "" {
+		return t.exprs[0].Eval(env)
+	}
+
+	var sb strings.Builder
+	for i := range t.exprs {
+		sb.WriteString(t.texts[i])
+		v, err := t.exprs[i].Eval(env)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(ToString(v))
+	}
+	sb.WriteString(t.texts[len(t.texts)-1])
+	return sb.String(), nil
+}
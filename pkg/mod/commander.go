@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/utils/cron"
 )
 
 // DefCommander used to execute command
@@ -31,6 +32,63 @@ func (c *DefCommander) RunDag(dagId string, specVars map[string]string) (*entity
 	return dagIns, nil
 }
 
+// RunDagAt create a scheduled dag instance, it is saved to store, and runs when the time comes
+func (c *DefCommander) RunDagAt(dagId string, specVars map[string]string, at time.Time) (*entity.DagInstance, error) {
+	dag, err := GetStore().GetDag(dagId)
+	if err != nil {
+		return nil, err
+	}
+
+	dagIns, err := dag.RunAt(entity.TriggerManually, specVars, at)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := GetStore().CreateDagIns(dagIns); err != nil {
+		return nil, err
+	}
+	return dagIns, nil
+}
+
+// RunDagAfter is like RunDagAt, the dag instance runs after the delay
+func (c *DefCommander) RunDagAfter(dagId string, specVars map[string]string, delay time.Duration) (*entity.DagInstance, error) {
+	return c.RunDagAt(dagId, specVars, time.Now().Add(delay))
+}
+
+// Backfill create a dag instance for each cron schedule time between "from" and "to"(both inclusive),
+// the logical schedule time will be injected to instance's vars
+func (c *DefCommander) Backfill(dagId string, from, to time.Time) ([]*entity.DagInstance, error) {
+	dag, err := GetStore().GetDag(dagId)
+	if err != nil {
+		return nil, err
+	}
+	if dag.Cron == "" {
+		return nil, fmt.Errorf("dag[%s] does not have a cron expression", dagId)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("the end of backfill is before the beginning")
+	}
+
+	schedule, err := cron.Parse(dag.Cron)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*entity.DagInstance
+	// "from" is inclusive, and schedule times are whole seconds, so the one before it must not be included
+	for _, t := range scheduleTimesBetween(schedule, from.Add(-time.Nanosecond), to) {
+		dagIns, err := dag.RunWithScheduleTime(entity.TriggerBackfill, nil, t)
+		if err != nil {
+			return ret, err
+		}
+		if err := GetStore().CreateDagIns(dagIns); err != nil {
+			return ret, err
+		}
+		ret = append(ret, dagIns)
+	}
+	return ret, nil
+}
+
 // RetryDagIns retry dag instance
 func (c *DefCommander) RetryDagIns(dagInsId string, ops ...CommandOptSetter) error {
 	taskIns, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
@@ -69,6 +127,111 @@ func (c *DefCommander) CancelTask(taskInsIds []string, ops ...CommandOptSetter)
 	}, opt)
 }
 
+// RerunFrom reset the task of completed dag instance to init and execute it again,
+// if includeDownstream is true, all of its descendants will be reset too
+func (c *DefCommander) RerunFrom(dagInsId, taskId string, includeDownstream bool, ops ...CommandOptSetter) error {
+	tasks, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+		DagInsID: dagInsId,
+	})
+	if err != nil {
+		return err
+	}
+
+	var treeTasks []*entity.TaskInstance
+	var start *entity.TaskInstance
+	for _, t := range tasks {
+		// mapped task instances are rerun with the task which they are expanded from
+		if t.MapParentID != "" {
+			continue
+		}
+		treeTasks = append(treeTasks, t)
+		if t.TaskID == taskId {
+			start = t
+		}
+	}
+	if start == nil {
+		return fmt.Errorf("task[%s] is not found in dag instance[%s]", taskId, dagInsId)
+	}
+
+	ids := []string{start.ID}
+	if includeDownstream {
+		root, err := BuildRootNode(MapTaskInsToGetter(treeTasks))
+		if err != nil {
+			return err
+		}
+		ids, _ = root.Descendants(start.ID)
+	}
+
+	opt := initOption(ops)
+	return executeDagInsCommand(dagInsId, func(dagIns *entity.DagInstance) error {
+		return dagIns.Rerun(ids)
+	}, opt)
+}
+
+// SkipTask force the tasks to be skipped and continue the downstream tasks, the running tasks will be canceled,
+// it can be used to make a failed or blocked dag instance continue
+func (c *DefCommander) SkipTask(taskInsIds []string, reason string, ops ...CommandOptSetter) error {
+	opt := initOption(ops)
+	return executeCommand(taskInsIds, func(dagIns *entity.DagInstance) error {
+		return dagIns.SkipTasks(taskInsIds, opt.issuer, reason)
+	}, opt)
+}
+
+// MarkTaskSuccess force the tasks to be success and continue the downstream tasks, the running tasks will be canceled,
+// it can be used to make a failed or blocked dag instance continue
+func (c *DefCommander) MarkTaskSuccess(taskInsIds []string, reason string, ops ...CommandOptSetter) error {
+	opt := initOption(ops)
+	return executeCommand(taskInsIds, func(dagIns *entity.DagInstance) error {
+		return dagIns.MarkTasksSuccess(taskInsIds, opt.issuer, reason)
+	}, opt)
+}
+
+// PauseDagIns pause dag instance, the running tasks will continue until they are completed,
+// but no new task will be executed until it is resumed
+func (c *DefCommander) PauseDagIns(dagInsId string, ops ...CommandOptSetter) error {
+	opt := initOption(ops)
+	return executeDagInsCommand(dagInsId, func(dagIns *entity.DagInstance) error {
+		return dagIns.Pause()
+	}, opt)
+}
+
+// ResumeDagIns resume paused dag instance, it continues from the tasks which are not executed
+func (c *DefCommander) ResumeDagIns(dagInsId string, ops ...CommandOptSetter) error {
+	opt := initOption(ops)
+	return executeDagInsCommand(dagInsId, func(dagIns *entity.DagInstance) error {
+		return dagIns.Resume()
+	}, opt)
+}
+
+// CancelDagIns cancel dag instance, the running tasks will be canceled and the tasks which are not executed
+// will be marked as canceled, then the dag instance will be "canceled"
+func (c *DefCommander) CancelDagIns(dagInsId, reason string, ops ...CommandOptSetter) error {
+	dagIns, err := GetStore().GetDagInstance(dagInsId)
+	if err != nil {
+		return err
+	}
+	// the instance is not dispatched to any worker, so there is no worker to execute the command
+	if (dagIns.Status == entity.DagInstanceStatusInit || dagIns.Status == entity.DagInstanceStatusScheduled) &&
+		dagIns.Worker == "" {
+		canceled := *dagIns
+		canceled.MarkCanceled(reason)
+		// it is canceled only when it is still not dispatched, otherwise the worker executes the command
+		err := patchDagInsIfOwned("", &entity.DagInstance{
+			ID:     dagIns.ID,
+			Status: canceled.Status,
+			Reason: canceled.Reason,
+		})
+		if !errors.Is(err, ErrDagInsNotOwned) {
+			return err
+		}
+	}
+
+	opt := initOption(ops)
+	return executeDagInsCommand(dagInsId, func(dagIns *entity.DagInstance) error {
+		return dagIns.CancelAll(reason)
+	}, opt)
+}
+
 func initOption(opSetter []CommandOptSetter) (opt CommandOption) {
 	opt.syncTimeout = 5 * time.Second
 	opt.syncInterval = 500 * time.Millisecond
@@ -117,41 +280,73 @@ func executeCommand(
 		}
 	}
 
+	return executeDagInsCommand(dagInsId, perform, opt)
+}
+
+func executeDagInsCommand(
+	dagInsId string,
+	perform func(dagIns *entity.DagInstance) error,
+	opt CommandOption) error {
 	dagIns, err := GetStore().GetDagInstance(dagInsId)
 	if err != nil {
 		return err
 	}
 
-	if err := perform(dagIns); err != nil {
+	// the command is built on a copy, so the instance in store is never seen with a command
+	ins := *dagIns
+	if err := perform(&ins); err != nil {
 		return err
 	}
-	if err := GetStore().PatchDagIns(&entity.DagInstance{
-		ID:     dagIns.ID,
-		Worker: dagIns.Worker,
-		Cmd:    dagIns.Cmd,
-	}); err != nil {
+	cmd := ins.Cmd
+	cmd.DagInsID = dagIns.ID
+	cmd.Issuer = opt.issuer
+	cmd.Status = entity.CommandStatusPending
+	cmd.CreatedAt = time.Now().UnixMilli()
+	if err := GetStore().CreateCommand(cmd); err != nil {
 		return err
 	}
 
 	if opt.isSync {
-		return ensureCmdExecuted(dagInsId, opt)
+		return ensureCmdExecuted(cmd.ID, opt)
 	}
 
 	return nil
 }
 
-func ensureCmdExecuted(dagInsId string, opt CommandOption) error {
+// hasPendingCommand return if the dag instance has a pending command with the name
+func hasPendingCommand(dagInsId string, name entity.CommandName) (bool, error) {
+	cmds, err := GetStore().ListCommand(&ListCommandInput{
+		DagInsID: dagInsId,
+		Status:   []entity.CommandStatus{entity.CommandStatusPending},
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func ensureCmdExecuted(cmdId string, opt CommandOption) error {
 	timer := time.NewTimer(opt.syncTimeout)
+	defer timer.Stop()
 	ticker := time.NewTicker(opt.syncInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			dag, err := GetStore().GetDagInstance(dagInsId)
+			cmd, err := GetStore().GetCommand(cmdId)
 			if err != nil {
 				return err
 			}
-			if dag.Cmd == nil {
+			switch cmd.Status {
+			case entity.CommandStatusSuccess:
 				return nil
+			case entity.CommandStatusFailed:
+				return fmt.Errorf("command[%s] failed: %s", cmd.ID, cmd.Result)
 			}
 		case <-timer.C:
 			return fmt.Errorf("watch command executing timeout")
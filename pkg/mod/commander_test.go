@@ -0,0 +1,81 @@
+package mod_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/mod"
+)
+
+func TestDefCommander_Backfill(t *testing.T) {
+	store := initTestEnv(t, nil)
+	for _, dag := range []*entity.Dag{
+		{ID: "hourly", Status: entity.DagStatusNormal, Cron: "@hourly"},
+		{ID: "no-cron", Status: entity.DagStatusNormal},
+	} {
+		require.NoError(t, store.CreateDag(dag))
+	}
+	at := func(hour, min, sec, nsec int) time.Time {
+		return time.Date(2023, 1, 1, hour, min, sec, nsec, time.UTC)
+	}
+
+	tests := []struct {
+		caseDesc  string
+		giveDagId string
+		giveFrom  time.Time
+		giveTo    time.Time
+		wantTimes []time.Time
+		wantErr   bool
+	}{
+		{
+			caseDesc:  "both inclusive",
+			giveDagId: "hourly",
+			giveFrom:  at(10, 0, 0, 0),
+			giveTo:    at(12, 0, 0, 0),
+			wantTimes: []time.Time{at(10, 0, 0, 0), at(11, 0, 0, 0), at(12, 0, 0, 0)},
+		},
+		{
+			caseDesc:  "from has sub-seconds",
+			giveDagId: "hourly",
+			giveFrom:  at(10, 0, 0, 500000000),
+			giveTo:    at(12, 30, 0, 0),
+			wantTimes: []time.Time{at(11, 0, 0, 0), at(12, 0, 0, 0)},
+		},
+		{
+			caseDesc:  "no schedule",
+			giveDagId: "hourly",
+			giveFrom:  at(10, 1, 0, 0),
+			giveTo:    at(10, 59, 0, 0),
+		},
+		{
+			caseDesc:  "to is before from",
+			giveDagId: "hourly",
+			giveFrom:  at(12, 0, 0, 0),
+			giveTo:    at(10, 0, 0, 0),
+			wantErr:   true,
+		},
+		{
+			caseDesc:  "no cron",
+			giveDagId: "no-cron",
+			giveFrom:  at(10, 0, 0, 0),
+			giveTo:    at(12, 0, 0, 0),
+			wantErr:   true,
+		},
+	}
+	for _, tc := range tests {
+		ret, err := mod.GetCommander().Backfill(tc.giveDagId, tc.giveFrom, tc.giveTo)
+		assert.Equal(t, tc.wantErr, err != nil, tc.caseDesc)
+
+		var times []time.Time
+		for _, ins := range ret {
+			assert.Equal(t, entity.TriggerBackfill, ins.Trigger, tc.caseDesc)
+			got, err := store.GetDagInstance(ins.ID)
+			require.NoError(t, err, tc.caseDesc)
+			times = append(times, time.Unix(got.ScheduleTime, 0).UTC())
+		}
+		assert.Equal(t, tc.wantTimes, times, tc.caseDesc)
+	}
+}
@@ -0,0 +1,123 @@
+package mod
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+// activeLimiter limits the active instances of dags when the dispatcher assigns new instances to workers,
+// the dispatched instances which are not completed are active, it is only used by the leader,
+// so the limit is checked in one place. It caches the dags, so it should be used in one round of dispatching
+type activeLimiter struct {
+	dags   map[string]*entity.Dag
+	active map[string][]*entity.DagInstance
+	// canceling record the dags whose oldest instance is canceling to free a slot
+	canceling map[string]struct{}
+}
+
+func newActiveLimiter() *activeLimiter {
+	return &activeLimiter{
+		dags:      map[string]*entity.Dag{},
+		active:    map[string][]*entity.DagInstance{},
+		canceling: map[string]struct{}{},
+	}
+}
+
+// track record the dispatched dag instance as active
+func (l *activeLimiter) track(dagIns *entity.DagInstance) {
+	l.active[dagIns.DagID] = append(l.active[dagIns.DagID], dagIns)
+}
+
+// allow return if the new dag instance can be dispatched now, the allowed instance is tracked as active,
+// and the excess instance is handled according to the max active policy of its dag
+func (l *activeLimiter) allow(dagIns *entity.DagInstance) (bool, error) {
+	dag, err := l.getDag(dagIns.DagID)
+	if err != nil {
+		return false, err
+	}
+	active := l.active[dag.ID]
+	if dag.MaxActiveInstances <= 0 {
+		l.track(dagIns)
+		return true, nil
+	}
+	if _, ok := l.canceling[dag.ID]; !ok && len(active) < dag.MaxActiveInstances {
+		l.track(dagIns)
+		return true, nil
+	}
+
+	switch dag.MaxActivePolicy {
+	case entity.MaxActivePolicySkip:
+		dagIns.MarkCanceled(fmt.Sprintf("skipped because dag[%s] already has %d active instances", dag.ID, len(active)))
+		return false, GetStore().PatchDagIns(&entity.DagInstance{
+			ID:     dagIns.ID,
+			Status: dagIns.Status,
+			Reason: dagIns.Reason,
+		})
+	case entity.MaxActivePolicyCancelOldest:
+		if _, ok := l.canceling[dag.ID]; ok {
+			return false, nil
+		}
+		oldest := oldestStartedDagIns(active)
+		// the instances which are not started yet can not be canceled by their workers, wait for them
+		if oldest == nil {
+			return false, nil
+		}
+		l.canceling[dag.ID] = struct{}{}
+		return false, cancelOldestDagIns(oldest, dagIns)
+	default:
+		// keep it in init without worker, it will be checked again in next round
+		return false, nil
+	}
+}
+
+func (l *activeLimiter) getDag(dagId string) (*entity.Dag, error) {
+	if dag, ok := l.dags[dagId]; ok {
+		return dag, nil
+	}
+	dag, err := GetStore().GetDag(dagId)
+	if err != nil {
+		return nil, err
+	}
+	l.dags[dagId] = dag
+	return dag, nil
+}
+
+// oldestStartedDagIns return the oldest running or paused instance
+func oldestStartedDagIns(active []*entity.DagInstance) *entity.DagInstance {
+	started := make([]*entity.DagInstance, 0, len(active))
+	for _, ins := range active {
+		if ins.Status == entity.DagInstanceStatusRunning || ins.Status == entity.DagInstanceStatusPaused {
+			started = append(started, ins)
+		}
+	}
+	if len(started) == 0 {
+		return nil
+	}
+	sortDagInsByCreatedAt(started)
+	return started[0]
+}
+
+// cancelOldestDagIns cancel the oldest active instance, unless it is already canceling
+func cancelOldestDagIns(oldest, waiting *entity.DagInstance) error {
+	if pending, err := hasPendingCommand(oldest.ID, entity.CommandNameCancelDagIns); err != nil || pending {
+		return err
+	}
+	return GetCommander().CancelDagIns(oldest.ID,
+		fmt.Sprintf("canceled to free a slot for dag instance[%s]", waiting.ID))
+}
+
+// sortDagInsByCreatedAt sort dag instances from the oldest to the latest,
+// the instances created at the same time are sorted by their ids
+func sortDagInsByCreatedAt(dagIns []*entity.DagInstance) {
+	sort.SliceStable(dagIns, func(i, j int) bool {
+		if dagIns[i].CreatedAt != dagIns[j].CreatedAt {
+			return dagIns[i].CreatedAt < dagIns[j].CreatedAt
+		}
+		if len(dagIns[i].ID) != len(dagIns[j].ID) {
+			return len(dagIns[i].ID) < len(dagIns[j].ID)
+		}
+		return dagIns[i].ID < dagIns[j].ID
+	})
+}
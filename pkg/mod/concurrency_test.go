@@ -0,0 +1,26 @@
+package mod
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+func TestSortDagInsByCreatedAt(t *testing.T) {
+	dagIns := []*entity.DagInstance{
+		{ID: "100", CreatedAt: 2},
+		{ID: "99", CreatedAt: 2},
+		{ID: "b", CreatedAt: 1},
+		{ID: "1000", CreatedAt: 2},
+		{ID: "a", CreatedAt: 3},
+	}
+	sortDagInsByCreatedAt(dagIns)
+
+	var ids []string
+	for _, ins := range dagIns {
+		ids = append(ids, ins.ID)
+	}
+	// the ids are only compared when the instances are created at the same time
+	assert.Equal(t, []string{"b", "99", "100", "1000", "a"}, ids)
+}
@@ -0,0 +1,59 @@
+package mod
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shiningrush/goevent"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/event"
+)
+
+// watchDagInsDeadline fail the dag instances which exceed their timeout,
+// and notify the ones which miss their sla
+func (p *DefParser) watchDagInsDeadline() error {
+	dagIns, err := GetStore().ListDagInstance(&ListDagInstanceInput{
+		Worker: GetKeeper().WorkerKey(),
+		Status: []entity.DagInstanceStatus{
+			entity.DagInstanceStatusRunning,
+			entity.DagInstanceStatusPaused,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("watch dag instance deadline failed: %w", err)
+	}
+
+	now := time.Now()
+	var errs []error
+	for _, ins := range dagIns {
+		if ins.IsTimeout(now) {
+			if err := timeoutDagIns(ins); err != nil {
+				errs = append(errs, fmt.Errorf("timeout dag instance[%s] failed: %w", ins.ID, err))
+			}
+			continue
+		}
+		if ins.IsSLAMissed(now) {
+			ins.MarkSLAMissed(now)
+			if err := GetStore().PatchDagIns(&entity.DagInstance{
+				ID:          ins.ID,
+				SLAMissedAt: ins.SLAMissedAt,
+			}); err != nil {
+				errs = append(errs, fmt.Errorf("mark dag instance[%s] sla missed failed: %w", ins.ID, err))
+				continue
+			}
+			goevent.Publish(&event.SLAMissed{DagIns: ins})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// timeoutDagIns send the timeout command, unless it is already sent
+func timeoutDagIns(dagIns *entity.DagInstance) error {
+	if pending, err := hasPendingCommand(dagIns.ID, entity.CommandNameTimeoutDagIns); err != nil || pending {
+		return err
+	}
+	return executeDagInsCommand(dagIns.ID, func(dagIns *entity.DagInstance) error {
+		return dagIns.TimeoutAll(fmt.Sprintf("dag instance exceeds its timeout %s", dagIns.Timeout))
+	}, CommandOption{})
+}
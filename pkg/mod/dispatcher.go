@@ -0,0 +1,279 @@
+package mod
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shiningrush/goevent"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/event"
+	"github.com/weeyp/fastflow/pkg/log"
+	"github.com/weeyp/fastflow/pkg/utils"
+	"github.com/weeyp/fastflow/pkg/utils/data"
+)
+
+const (
+	ReasonWorkerDead = "worker is dead when task running"
+)
+
+// DispatchStrategy decide which worker will be assigned
+type DispatchStrategy string
+
+const (
+	// DispatchStrategyRoundRobin assign worker in turn
+	DispatchStrategyRoundRobin DispatchStrategy = "round-robin"
+	// DispatchStrategyLeastLoaded assign the worker which has the least unfinished dag instances
+	DispatchStrategyLeastLoaded DispatchStrategy = "least-loaded"
+)
+
+// DefDispatcher is default dispatcher, it only runs at leader,
+// it assigns init dag instances to alive workers and re-dispatch the instances of dead workers,
+// as well as the init instances whose workers no longer match their selectors
+type DefDispatcher struct {
+	strategy DispatchStrategy // dispatch strategy
+	interval time.Duration    // interval of dispatching
+	rrIndex  int              // index of round-robin
+
+	closeCh chan struct{}  // close channel
+	wg      sync.WaitGroup // wait group
+	once    sync.Once      // close once
+}
+
+// NewDefDispatcher create a default dispatcher
+func NewDefDispatcher(strategy DispatchStrategy) *DefDispatcher {
+	if strategy == "" {
+		strategy = DispatchStrategyRoundRobin
+	}
+	return &DefDispatcher{
+		strategy: strategy,
+		interval: time.Second,
+		closeCh:  make(chan struct{}),
+	}
+}
+
+// Init init dispatcher
+func (d *DefDispatcher) Init() {
+	d.wg.Add(1)
+	go d.watch()
+}
+
+func (d *DefDispatcher) watch() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		case <-ticker.C:
+			if err := d.Do(); err != nil {
+				d.handleErr(err)
+			}
+		}
+	}
+}
+
+// Do dispatch dag instances once
+func (d *DefDispatcher) Do() (err error) {
+	start := time.Now()
+	e := &event.DispatchInitDagInsCompleted{}
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("dispatch dag instance failed: %w", err)
+			e.Error = err
+		}
+		e.ElapsedMs = time.Now().Sub(start).Milliseconds()
+		goevent.Publish(e)
+	}()
+
+	nodes, err := GetKeeper().AliveNodes()
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return data.ErrNoAliveNodes
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Key < nodes[j].Key
+	})
+
+	dagIns, err := GetStore().ListDagInstance(&ListDagInstanceInput{
+		Status: []entity.DagInstanceStatus{
+			entity.DagInstanceStatusInit,
+			entity.DagInstanceStatusRunning,
+			entity.DagInstanceStatusPaused,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	loads := map[string]int{}
+	nodeMap := map[string]*entity.Node{}
+	for _, n := range nodes {
+		loads[n.Key] = 0
+		nodeMap[n.Key] = n
+	}
+	var needDispatch []*entity.DagInstance
+	limiter := newActiveLimiter()
+	for i := range dagIns {
+		if dagIns[i].Worker != "" {
+			limiter.track(dagIns[i])
+		}
+		if node, ok := nodeMap[dagIns[i].Worker]; ok && !isMismatchedInit(dagIns[i], node) {
+			loads[dagIns[i].Worker]++
+			continue
+		}
+		needDispatch = append(needDispatch, dagIns[i])
+	}
+	// the older instances are dispatched at first when the active instances of dag are limited
+	sortDagInsByCreatedAt(needDispatch)
+
+	for _, ins := range needDispatch {
+		candidates, err := matchedNodes(ins, nodes)
+		if err != nil {
+			log.Errorf("dag instance[%s] match workers failed: %s", ins.ID, err)
+			continue
+		}
+		if len(candidates) == 0 {
+			log.Warn("no alive worker matches the selector of dag instance",
+				utils.LogKeyDagInsID, ins.ID,
+				"selector", ins.Selector)
+			continue
+		}
+		// the new instance is limited by the max active instances of its dag,
+		// the instance of dead worker is already active, it just needs a new worker
+		if ins.Worker == "" {
+			allowed, err := limiter.allow(ins)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				continue
+			}
+		}
+
+		worker := d.pickWorker(candidates, loads)
+		if err = d.dispatch(ins, worker); err != nil {
+			return err
+		}
+		loads[worker]++
+	}
+	return nil
+}
+
+// isMismatchedInit check whether the init instance is assigned to a worker which does not match its selector,
+// e.g. the labels of worker are changed after dispatching, the worker never starts it, so it needs a new worker
+func isMismatchedInit(dagIns *entity.DagInstance, node *entity.Node) bool {
+	if dagIns.Status != entity.DagInstanceStatusInit {
+		return false
+	}
+	matched, err := dagIns.MatchLabels(node.Labels)
+	return err == nil && !matched
+}
+
+func matchedNodes(dagIns *entity.DagInstance, nodes []*entity.Node) ([]string, error) {
+	var ret []string
+	for _, n := range nodes {
+		ok, err := dagIns.MatchLabels(n.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ret = append(ret, n.Key)
+		}
+	}
+	return ret, nil
+}
+
+func (d *DefDispatcher) pickWorker(nodes []string, loads map[string]int) string {
+	if d.strategy == DispatchStrategyLeastLoaded {
+		picked := nodes[0]
+		for _, n := range nodes {
+			if loads[n] < loads[picked] {
+				picked = n
+			}
+		}
+		return picked
+	}
+
+	picked := nodes[d.rrIndex%len(nodes)]
+	d.rrIndex++
+	return picked
+}
+
+func (d *DefDispatcher) dispatch(dagIns *entity.DagInstance, worker string) error {
+	// the instance is running at a dead worker, its running tasks can not be continued,
+	// so mark them failed and let new worker initial it again
+	if dagIns.Status == entity.DagInstanceStatusRunning || dagIns.Status == entity.DagInstanceStatusPaused {
+		tasks, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+			DagInsID: dagIns.ID,
+			Status:   []entity.TaskInstanceStatus{entity.TaskInstanceStatusRunning},
+		})
+		if err != nil {
+			return err
+		}
+		for _, t := range tasks {
+			if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+				ID:     t.ID,
+				Status: entity.TaskInstanceStatusFailed,
+				Reason: ReasonWorkerDead,
+			}); err != nil {
+				return err
+			}
+		}
+		log.Info("re-dispatch dag instance of dead worker",
+			utils.LogKeyDagInsID, dagIns.ID,
+			"deadWorker", dagIns.Worker,
+			"worker", worker)
+	}
+
+	// the init instance is assigned to a dead or mismatched worker before
+	if dagIns.Status == entity.DagInstanceStatusInit && dagIns.Worker != "" {
+		log.Info("re-dispatch init dag instance",
+			utils.LogKeyDagInsID, dagIns.ID,
+			"previousWorker", dagIns.Worker,
+			"worker", worker)
+	}
+	status := dagIns.Status
+	// paused instance keeps its status, new worker will initial it when it is resumed
+	if status != entity.DagInstanceStatusPaused {
+		status = entity.DagInstanceStatusInit
+	}
+	// compare and set the worker, so the previous worker can not write it any more,
+	// and the instance changed during dispatching is left to next round
+	patch := &entity.DagInstance{
+		ID:     dagIns.ID,
+		Worker: worker,
+	}
+	// the status of init instance is not patched, so it will not overwrite the cancel during dispatching
+	if status != dagIns.Status {
+		patch.Status = status
+	}
+	err := patchDagInsIfOwned(dagIns.Worker, patch)
+	if errors.Is(err, ErrDagInsNotOwned) {
+		log.Warn("dag instance is changed during dispatching, skip it",
+			utils.LogKeyDagInsID, dagIns.ID,
+			"err", err)
+		return nil
+	}
+	return err
+}
+
+// Close dispatcher
+func (d *DefDispatcher) Close() {
+	d.once.Do(func() {
+		close(d.closeCh)
+		d.wg.Wait()
+	})
+}
+
+func (d *DefDispatcher) handleErr(err error) {
+	log.Error("dispatcher get some error",
+		"module", "dispatcher",
+		"err", err)
+}
@@ -0,0 +1,197 @@
+package mod_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/mod"
+	"github.com/weeyp/fastflow/store/cache"
+)
+
+// initTestEnv set a memory store and a keeper of worker "w1", which is the leader
+func initTestEnv(t *testing.T, labels map[string]string) *cache.MemCache {
+	store := cache.NewMemCache()
+	mod.SetStore(store)
+	mod.SetCommander(&mod.DefCommander{})
+
+	keeper := mod.NewDefKeeper(&mod.KeeperOption{WorkerKey: "w1", Labels: labels})
+	require.NoError(t, keeper.Init())
+	mod.SetKeeper(keeper)
+	t.Cleanup(keeper.Close)
+	return store
+}
+
+func createDagIns(t *testing.T, store mod.Store, ins *entity.DagInstance) *entity.DagInstance {
+	require.NoError(t, store.CreateDagIns(ins))
+	return ins
+}
+
+func TestDefDispatcher_MaxActiveInstances(t *testing.T) {
+	store := initTestEnv(t, nil)
+	for _, dag := range []*entity.Dag{
+		{ID: "no-limit"},
+		{ID: "queue", MaxActiveInstances: 1},
+		{ID: "skip", MaxActiveInstances: 1, MaxActivePolicy: entity.MaxActivePolicySkip},
+		{ID: "cancel-oldest", MaxActiveInstances: 1, MaxActivePolicy: entity.MaxActivePolicyCancelOldest},
+		{ID: "race", MaxActiveInstances: 1},
+	} {
+		require.NoError(t, store.CreateDag(dag))
+	}
+
+	newIns := func(dagId string) *entity.DagInstance {
+		return createDagIns(t, store, &entity.DagInstance{DagID: dagId, Status: entity.DagInstanceStatusInit})
+	}
+	runningIns := func(dagId string) *entity.DagInstance {
+		return createDagIns(t, store, &entity.DagInstance{DagID: dagId, Worker: "w1", Status: entity.DagInstanceStatusRunning})
+	}
+	noLimit1, noLimit2 := newIns("no-limit"), newIns("no-limit")
+	runningIns("queue")
+	queued := newIns("queue")
+	runningIns("skip")
+	skipped := newIns("skip")
+	oldest := runningIns("cancel-oldest")
+	canceling := newIns("cancel-oldest")
+	// both of them are new, only the older one can be dispatched
+	race1, race2 := newIns("race"), newIns("race")
+
+	require.NoError(t, mod.NewDefDispatcher(mod.DispatchStrategyRoundRobin).Do())
+
+	tests := []struct {
+		caseDesc   string
+		giveIns    *entity.DagInstance
+		wantWorker string
+		wantStatus entity.DagInstanceStatus
+	}{
+		{caseDesc: "no limit 1", giveIns: noLimit1, wantWorker: "w1", wantStatus: entity.DagInstanceStatusInit},
+		{caseDesc: "no limit 2", giveIns: noLimit2, wantWorker: "w1", wantStatus: entity.DagInstanceStatusInit},
+		{caseDesc: "queue", giveIns: queued, wantStatus: entity.DagInstanceStatusInit},
+		{caseDesc: "skip", giveIns: skipped, wantStatus: entity.DagInstanceStatusCanceled},
+		{caseDesc: "cancel oldest", giveIns: canceling, wantStatus: entity.DagInstanceStatusInit},
+		{caseDesc: "race older", giveIns: race1, wantWorker: "w1", wantStatus: entity.DagInstanceStatusInit},
+		{caseDesc: "race newer", giveIns: race2, wantStatus: entity.DagInstanceStatusInit},
+	}
+	for _, tc := range tests {
+		ins, err := store.GetDagInstance(tc.giveIns.ID)
+		require.NoError(t, err, tc.caseDesc)
+		assert.Equal(t, tc.wantWorker, ins.Worker, tc.caseDesc)
+		assert.Equal(t, tc.wantStatus, ins.Status, tc.caseDesc)
+	}
+
+	cmds, err := store.ListCommand(&mod.ListCommandInput{DagInsID: oldest.ID})
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	assert.Equal(t, entity.CommandName(entity.CommandNameCancelDagIns), cmds[0].Name)
+
+	// the limit is kept in next round, and the oldest is not canceled again
+	require.NoError(t, mod.NewDefDispatcher(mod.DispatchStrategyRoundRobin).Do())
+	ins, err := store.GetDagInstance(race2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "", ins.Worker)
+	cmds, err = store.ListCommand(&mod.ListCommandInput{DagInsID: oldest.ID})
+	require.NoError(t, err)
+	assert.Len(t, cmds, 1)
+}
+
+func TestDefDispatcher_RedispatchDeadWorker(t *testing.T) {
+	store := initTestEnv(t, nil)
+	require.NoError(t, store.Heartbeat(&entity.Node{Key: "dead", HeartbeatAt: time.Now().Add(-time.Hour).UnixMilli()}))
+
+	running := createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: "dead", Status: entity.DagInstanceStatusRunning})
+	paused := createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: "dead", Status: entity.DagInstanceStatusPaused})
+	initIns := createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: "dead", Status: entity.DagInstanceStatusInit})
+	runningTask := &entity.TaskInstance{DagInsID: running.ID, TaskID: "running", Status: entity.TaskInstanceStatusRunning}
+	successTask := &entity.TaskInstance{DagInsID: running.ID, TaskID: "success", Status: entity.TaskInstanceStatusSuccess}
+	require.NoError(t, store.BatchCreatTaskIns([]*entity.TaskInstance{runningTask, successTask}))
+
+	require.NoError(t, mod.NewDefDispatcher(mod.DispatchStrategyRoundRobin).Do())
+
+	tests := []struct {
+		caseDesc   string
+		giveIns    *entity.DagInstance
+		wantStatus entity.DagInstanceStatus
+	}{
+		{caseDesc: "running is initialized again", giveIns: running, wantStatus: entity.DagInstanceStatusInit},
+		{caseDesc: "paused keeps paused", giveIns: paused, wantStatus: entity.DagInstanceStatusPaused},
+		{caseDesc: "init", giveIns: initIns, wantStatus: entity.DagInstanceStatusInit},
+	}
+	for _, tc := range tests {
+		ins, err := store.GetDagInstance(tc.giveIns.ID)
+		require.NoError(t, err, tc.caseDesc)
+		assert.Equal(t, "w1", ins.Worker, tc.caseDesc)
+		assert.Equal(t, tc.wantStatus, ins.Status, tc.caseDesc)
+	}
+
+	task, err := store.GetTaskIns(runningTask.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.TaskInstanceStatusFailed, task.Status)
+	assert.Equal(t, mod.ReasonWorkerDead, task.Reason)
+	task, err = store.GetTaskIns(successTask.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.TaskInstanceStatusSuccess, task.Status)
+	assert.Equal(t, "", task.Reason)
+
+	// the dead worker may come back, but it can not write the instance any more
+	err = mod.PatchDagInsIfOwned("dead", &entity.DagInstance{ID: running.ID, Status: entity.DagInstanceStatusSuccess})
+	assert.True(t, errors.Is(err, mod.ErrDagInsNotOwned))
+	ins, err := store.GetDagInstance(running.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.DagInstanceStatusInit, ins.Status)
+}
+
+func TestDefDispatcher_RedispatchMismatchedWorker(t *testing.T) {
+	store := initTestEnv(t, map[string]string{"zone": "a"})
+	require.NoError(t, store.CreateDag(&entity.Dag{ID: "dag"}))
+	require.NoError(t, store.Heartbeat(&entity.Node{Key: "w2", HeartbeatAt: time.Now().UnixMilli(), Labels: map[string]string{"zone": "b"}}))
+
+	newIns := func(worker, selector string, status entity.DagInstanceStatus) *entity.DagInstance {
+		return createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: worker, Selector: selector, Status: status})
+	}
+	matched := newIns("w1", "zone=a", entity.DagInstanceStatusInit)
+	mismatched := newIns("w1", "zone=b", entity.DagInstanceStatusInit)
+	// the running instance is already started, it is not moved
+	running := newIns("w1", "zone=b", entity.DagInstanceStatusRunning)
+	// no other worker matches, it just waits
+	noCandidate := newIns("w1", "zone=c", entity.DagInstanceStatusInit)
+	unassigned := newIns("", "zone=b", entity.DagInstanceStatusInit)
+
+	require.NoError(t, mod.NewDefDispatcher(mod.DispatchStrategyRoundRobin).Do())
+
+	tests := []struct {
+		caseDesc   string
+		giveIns    *entity.DagInstance
+		wantWorker string
+	}{
+		{caseDesc: "matched", giveIns: matched, wantWorker: "w1"},
+		{caseDesc: "mismatched", giveIns: mismatched, wantWorker: "w2"},
+		{caseDesc: "running", giveIns: running, wantWorker: "w1"},
+		{caseDesc: "no candidate", giveIns: noCandidate, wantWorker: "w1"},
+		{caseDesc: "unassigned", giveIns: unassigned, wantWorker: "w2"},
+	}
+	for _, tc := range tests {
+		ins, err := store.GetDagInstance(tc.giveIns.ID)
+		require.NoError(t, err, tc.caseDesc)
+		assert.Equal(t, tc.wantWorker, ins.Worker, tc.caseDesc)
+		assert.Equal(t, tc.giveIns.Status, ins.Status, tc.caseDesc)
+	}
+}
+
+func TestDefDispatcher_OnlyAliveWorkers(t *testing.T) {
+	store := initTestEnv(t, nil)
+	require.NoError(t, store.CreateDag(&entity.Dag{ID: "dag"}))
+	require.NoError(t, store.Heartbeat(&entity.Node{Key: "dead", HeartbeatAt: time.Now().Add(-time.Hour).UnixMilli()}))
+
+	var ins []*entity.DagInstance
+	for i := 0; i < 4; i++ {
+		ins = append(ins, createDagIns(t, store, &entity.DagInstance{DagID: "dag", Status: entity.DagInstanceStatusInit}))
+	}
+	require.NoError(t, mod.NewDefDispatcher(mod.DispatchStrategyLeastLoaded).Do())
+	for _, i := range ins {
+		got, err := store.GetDagInstance(i.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "w1", got.Worker)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/weeyp/fastflow/pkg/render"
@@ -15,26 +16,43 @@ import (
 	"github.com/weeyp/fastflow/pkg/entity"
 	"github.com/weeyp/fastflow/pkg/entity/run"
 	"github.com/weeyp/fastflow/pkg/event"
+	"github.com/weeyp/fastflow/pkg/expr"
 	"github.com/weeyp/fastflow/pkg/log"
 )
 
+const (
+	// DefaultPriorityAging is the default waiting time for a queued task instance to gain one priority
+	DefaultPriorityAging = 10 * time.Second
+)
+
 const (
 	ReasonSuccessAfterCanceled = "success after canceled"
 	ReasonParentCancel         = "parent success but already be canceled"
+	ReasonDagInsCanceled       = "dag instance is canceled"
+	ReasonDagInsTimeout        = "dag instance is timeout"
+	ReasonBranchNotChosen      = "branch is not chosen by task[%s]"
+	ReasonCanceledInPool       = "canceled while waiting for pool[%s]"
+	ReasonCanceledBeforeStart  = "canceled before it is started"
+	ReasonCanceledInRetry      = "canceled while waiting for retry"
+	ReasonTriggerRuleNotMet    = "trigger rule %s can never be met"
 )
 
 // DefExecutor is default executor
 type DefExecutor struct {
-	cancelMap    sync.Map                  // map[string]context.CancelFunc
-	workerNumber int                       // number of worker
-	workerQueue  chan *entity.TaskInstance // worker queue
-	workerWg     sync.WaitGroup            // worker wait group
-	initWg       sync.WaitGroup            // init wait group
-	timeout      time.Duration             // default timeout
-	initQueue    chan *initPayload         // init queue
+	cancelMap    sync.Map          // map[string]context.CancelFunc
+	rateLimiters sync.Map          // map[string]*rateLimiter
+	workerNumber int               // number of worker
+	workerQueue  *taskQueue        // worker queue
+	workerWg     sync.WaitGroup    // worker wait group
+	initWg       sync.WaitGroup    // init wait group
+	timeout      time.Duration     // default timeout
+	initQueue    chan *initPayload // init queue
 
 	paramRender *render.TplRender // param render
 
+	pools       map[string]*taskPool // pools limit the running task instances
+	actionPools map[string]string    // the default pool of actions
+
 	closeCh chan struct{} // close channel
 	lock    sync.RWMutex  // lock
 }
@@ -43,13 +61,18 @@ type DefExecutor struct {
 type initPayload struct {
 	dagIns  *entity.DagInstance
 	taskIns *entity.TaskInstance
+	// status is the status of task instance before it waits for pool,
+	// because store may patch the task instance in place
+	status entity.TaskInstanceStatus
+	// ctx is canceled when the task instance is canceled after it is sent to worker queue
+	ctx context.Context
 }
 
 // NewDefExecutor  create a default executor
 func NewDefExecutor(timeout time.Duration, workers int) *DefExecutor {
 	return &DefExecutor{
 		workerNumber: workers,
-		workerQueue:  make(chan *entity.TaskInstance),
+		workerQueue:  newTaskQueue(DefaultPriorityAging, workers),
 		timeout:      timeout,
 		initQueue:    make(chan *initPayload),
 		closeCh:      make(chan struct{}, 1),
@@ -57,6 +80,23 @@ func NewDefExecutor(timeout time.Duration, workers int) *DefExecutor {
 	}
 }
 
+// SetPools set the pools which limit the running task instances, it should be called before Init
+func (e *DefExecutor) SetPools(opts []PoolOption) error {
+	pools, actionPools, err := buildTaskPools(opts)
+	if err != nil {
+		return err
+	}
+	e.pools, e.actionPools = pools, actionPools
+	return nil
+}
+
+// SetPriorityAging set the waiting time for a queued task instance to gain one priority
+func (e *DefExecutor) SetPriorityAging(aging time.Duration) {
+	if aging > 0 {
+		e.workerQueue.aging = aging
+	}
+}
+
 // Init init executor
 func (e *DefExecutor) Init() {
 	e.initWg.Add(1)
@@ -69,8 +109,12 @@ func (e *DefExecutor) Init() {
 }
 
 func (e *DefExecutor) subWorkerQueue() {
-	for taskIns := range e.workerQueue {
-		e.workerDo(taskIns)
+	for {
+		payload, ok := e.workerQueue.pop()
+		if !ok {
+			break
+		}
+		e.workerDo(payload)
 	}
 	e.workerWg.Done()
 }
@@ -100,27 +144,248 @@ func (e *DefExecutor) initWorkerTask(dagIns *entity.DagInstance, taskIns *entity
 		return
 	}
 
+	// the task is loaded from store when it is waiting, so it is not started yet
+	if taskIns.Status == entity.TaskInstanceStatusWaiting {
+		taskIns.Status = entity.TaskInstanceStatusInit
+	}
+	if e.waitRetry(taskIns) {
+		return
+	}
+	pool, err := e.getPool(taskIns)
+	if err != nil {
+		taskIns.Status = entity.TaskInstanceStatusFailed
+		taskIns.Reason = err.Error()
+		e.completeNotStartedTask(taskIns)
+		return
+	}
+
+	// the task can be canceled before it is sent to worker queue
+	e.cancelMap.Store(taskIns.ID, context.CancelFunc(func() {
+		if pool != nil {
+			e.cancelWaitingTask(pool, taskIns.ID)
+		}
+	}))
+	payload := &initPayload{dagIns: dagIns, taskIns: taskIns, status: taskIns.Status}
+	if pool != nil && !pool.acquire(payload) {
+		markWaiting(taskIns)
+		return
+	}
+	e.startTask(payload, false)
+}
+
+// startTask send the task instance to worker queue, it is delayed when its action exceeds the rate limit,
+// waiting means the task instance is already marked waiting
+func (e *DefExecutor) startTask(payload *initPayload, waiting bool) {
+	if limiter := e.getRateLimiter(payload.taskIns.ActionName); limiter != nil {
+		if delay := limiter.reserve(time.Now()); delay > 0 {
+			if !waiting {
+				markWaiting(payload.taskIns)
+			}
+			time.AfterFunc(delay, func() {
+				e.sendToWorker(payload, true)
+			})
+			return
+		}
+	}
+	e.sendToWorker(payload, waiting)
+}
+
+func (e *DefExecutor) sendToWorker(payload *initPayload, waiting bool) {
+	select {
+	case <-e.closeCh:
+		log.Info("executor has already closed, so will not execute task instances")
+		return
+	default:
+	}
+
+	taskIns := payload.taskIns
+	if waiting {
+		taskIns.Status = payload.status
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, loaded := e.cancelMap.Swap(taskIns.ID, cancel); !loaded {
+		// it is canceled when it is delayed or taking the slot
+		e.cancelMap.Delete(taskIns.ID)
+		cancel()
+		taskIns.Status = entity.TaskInstanceStatusCanceled
+		taskIns.Reason = ReasonCanceledBeforeStart
+		e.completeNotStartedTask(taskIns)
+		e.releasePool(taskIns)
+		return
+	}
+
+	if waiting {
+		if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+			ID:     taskIns.ID,
+			Status: taskIns.Status,
+		}); err != nil {
+			log.Errorf("patch task instance[%s] to %s failed: %s", taskIns.ID, taskIns.Status, err)
+		}
+	}
+	payload.ctx = ctx
+	e.workerQueue.push(payload, taskPriority(payload.dagIns, taskIns))
+}
+
+// waitRetry delay the retrying task instance until its retry time, then let parser push it again,
+// so it will not be started when its dag instance is paused. It can be canceled during waiting,
+// return false if the task instance can be started now
+func (e *DefExecutor) waitRetry(taskIns *entity.TaskInstance) bool {
+	if taskIns.Status != entity.TaskInstanceStatusRetrying {
+		return false
+	}
+	delay := time.Until(time.UnixMilli(taskIns.RetryAt))
+	if delay <= 0 {
+		return false
+	}
+
+	// claimed make sure only one of retrying and canceling happens
+	var claimed atomic.Bool
+	e.cancelMap.Store(taskIns.ID, context.CancelFunc(func() {
+		if !claimed.CompareAndSwap(false, true) {
+			return
+		}
+		taskIns.Status = entity.TaskInstanceStatusCanceled
+		taskIns.Reason = ReasonCanceledInRetry
+		e.completeNotStartedTask(taskIns)
+	}))
+	time.AfterFunc(delay, func() {
+		if !claimed.CompareAndSwap(false, true) {
+			return
+		}
+		e.cancelMap.Delete(taskIns.ID)
+		// the task may be canceled with its dag instance or forced by command during waiting
+		latest, err := GetStore().GetTaskIns(taskIns.ID)
+		if err != nil {
+			log.Errorf("get task instance[%s] failed: %s", taskIns.ID, err)
+			return
+		}
+		if latest.Status != entity.TaskInstanceStatusRetrying {
+			return
+		}
+		GetParser().EntryTaskIns(taskIns)
+	})
+	return true
+}
+
+// markWaiting save the waiting status, the status before waiting is restored when it is sent to worker queue
+func markWaiting(taskIns *entity.TaskInstance) {
+	if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+		ID:     taskIns.ID,
+		Status: entity.TaskInstanceStatusWaiting,
+	}); err != nil {
+		log.Errorf("patch task instance[%s] to waiting failed: %s", taskIns.ID, err)
+	}
+}
+
+// getRateLimiter return the rate limiter of action, nil means the action is not rate limited
+func (e *DefExecutor) getRateLimiter(actionName string) *rateLimiter {
+	if limiter, ok := e.rateLimiters.Load(actionName); ok {
+		return limiter.(*rateLimiter)
+	}
+
+	// the action without limit is not cached, it may be registered or replaced later
+	act, ok := ActionMap[actionName].(run.RateLimitedAction)
+	if !ok {
+		return nil
+	}
+	rate, burst := act.RateLimit()
+	if rate <= 0 {
+		return nil
+	}
+	actual, _ := e.rateLimiters.LoadOrStore(actionName, newRateLimiter(rate, burst))
+	return actual.(*rateLimiter)
+}
+
+// taskPriority return the priority of task instance, it is the sum of the priorities of dag and task
+func taskPriority(dagIns *entity.DagInstance, taskIns *entity.TaskInstance) int {
+	return dagIns.Priority + taskIns.Priority
+}
+
+// prepareTask initial the context of task instance when it is popped by worker, so its timeout does not include
+// the time waiting in worker queue, and return the function to release the context
+func (e *DefExecutor) prepareTask(ctx context.Context, dagIns *entity.DagInstance, taskIns *entity.TaskInstance) context.CancelFunc {
 	defTimeout := e.timeout
 	if taskIns.TimeoutSecs != 0 {
 		defTimeout = time.Duration(taskIns.TimeoutSecs) * time.Second
 	}
-	c, cancel := context.WithTimeout(context.TODO(), defTimeout)
+	c, cancel := context.WithTimeout(ctx, defTimeout)
 	dagIns.ShareData.Save = func(data *entity.ShareData) error {
-		return GetStore().PatchDagIns(&entity.DagInstance{ID: taskIns.DagInsID, ShareData: data})
+		return patchOwnedDagIns(&entity.DagInstance{ID: taskIns.DagInsID, ShareData: data})
 	}
 	c = CtxWithRunningTaskIns(c, taskIns)
 	taskIns.InitialDep(
-		run.NewDefExecuteContext(c, dagIns.ShareData, taskIns.Trace, dagIns.VarsGetter(), dagIns.VarsIterator()),
+		run.NewDefExecuteContext(c, dagIns.ShareData, taskIns.Trace, dagIns.VarsGetter(), dagIns.VarsIterator(),
+			run.WithBranchFunc(func(taskIds []string) {
+				taskIns.Branches = taskIds
+			}),
+			run.WithOutputFunc(func(output map[string]interface{}) {
+				taskIns.Output = output
+			})),
 		func(instance *entity.TaskInstance) error {
+			// the dag instance may be re-dispatched when current worker is treated as dead
+			if err := checkDagInsOwned(GetKeeper().WorkerKey(), taskIns.DagInsID); err != nil {
+				return err
+			}
 			return GetStore().PatchTaskIns(instance)
 		}, dagIns)
-	e.cancelMap.Store(taskIns.ID, cancel)
-	e.workerQueue <- taskIns
+	return cancel
+}
+
+// getPool return the pool used by task instance, nil means it is not limited by any pool
+func (e *DefExecutor) getPool(taskIns *entity.TaskInstance) (*taskPool, error) {
+	name := taskIns.Pool
+	if name == "" {
+		name = e.actionPools[taskIns.ActionName]
+	}
+	if name == "" {
+		return nil, nil
+	}
+	pool, ok := e.pools[name]
+	if !ok {
+		return nil, fmt.Errorf("pool[%s] is not defined", name)
+	}
+	return pool, nil
+}
+
+// releasePool free the slot of task instance, and start the next waiting one
+func (e *DefExecutor) releasePool(taskIns *entity.TaskInstance) {
+	pool, _ := e.getPool(taskIns)
+	if pool == nil {
+		return
+	}
+	if next := pool.release(taskIns.ID); next != nil {
+		// it is released by worker, which can not wait for the full worker queue
+		go e.startTask(next, true)
+	}
+}
+
+// cancelWaitingTask remove the task instance from waiting queue of pool and mark it canceled
+func (e *DefExecutor) cancelWaitingTask(pool *taskPool, taskInsId string) {
+	payload := pool.remove(taskInsId)
+	if payload == nil {
+		return
+	}
+	payload.taskIns.Status = entity.TaskInstanceStatusCanceled
+	payload.taskIns.Reason = fmt.Sprintf(ReasonCanceledInPool, pool.name)
+	e.completeNotStartedTask(payload.taskIns)
+}
+
+// completeNotStartedTask save the task instance which is completed without running, then let parser handle it
+func (e *DefExecutor) completeNotStartedTask(taskIns *entity.TaskInstance) {
+	if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+		ID:     taskIns.ID,
+		Status: taskIns.Status,
+		Reason: taskIns.Reason,
+	}); err != nil {
+		log.Errorf("patch task instance[%s] to %s failed: %s", taskIns.ID, taskIns.Status, err)
+		return
+	}
+	GetParser().EntryTaskIns(taskIns)
 }
 
 // Push task to execute
 func (e *DefExecutor) Push(dagIns *entity.DagInstance, taskIns *entity.TaskInstance) {
-	isActive, err := taskIns.DoPreCheck(dagIns)
+	isActive, err := taskIns.DoPreCheck(dagIns, taskInsGetter(taskIns.DagInsID))
 	if err != nil {
 		log.Errorf("do task pre-check failed:%s", err)
 		return
@@ -160,20 +425,26 @@ func (e *DefExecutor) Push(dagIns *entity.DagInstance, taskIns *entity.TaskInsta
 	}
 }
 
-func (e *DefExecutor) workerDo(taskIns *entity.TaskInstance) {
+func (e *DefExecutor) workerDo(payload *initPayload) {
+	taskIns := payload.taskIns
 	switch taskIns.Status {
 	case entity.TaskInstanceStatusInit, entity.TaskInstanceStatusEnding, entity.TaskInstanceStatusRetrying:
 	default:
 		log.Warnf("this task instance[%s] is not executable, status[%s]", taskIns.ID, taskIns.Status)
+		e.releasePool(taskIns)
 		return
 	}
 
+	cancel := e.prepareTask(payload.ctx, payload.dagIns, taskIns)
+	defer cancel()
 	goevent.Publish(&event.TaskBegin{
 		TaskIns: taskIns,
 	})
 	err := e.runAction(taskIns)
 	e.handleTaskError(taskIns, err)
 	e.cancelMap.Delete(taskIns.ID)
+	// release before parser handles it, because the task may be pushed again
+	e.releasePool(taskIns)
 	GetParser().EntryTaskIns(taskIns)
 	goevent.Publish(&event.TaskCompleted{
 		TaskIns: taskIns,
@@ -229,17 +500,25 @@ func weakDecode(input interface{}, output interface{}) error {
 }
 
 func (e *DefExecutor) renderParams(taskIns *entity.TaskInstance) error {
-	data := map[string]interface{}{}
-
-	dagInstance := taskIns.RelatedDagInstance
-	if dagInstance != nil {
-		data["vars"] = dagInstance.Vars
-		if dagInstance.ShareData != nil {
-			data["shareData"] = dagInstance.ShareData.Dict
-		}
+	data, err := buildRenderData(taskIns.RelatedDagInstance, taskIns)
+	if err != nil {
+		return fmt.Errorf("build render data failed: %w", err)
 	}
-
-	err := value.MapValue(taskIns.Params).WalkString(func(walkContext *value.WalkContext, v string) error {
+	exprEnv := buildExprEnv(taskIns.RelatedDagInstance, taskIns)
+	err = value.MapValue(taskIns.Params).WalkString(func(walkContext *value.WalkContext, v string) error {
+		// the string contains expressions will not be rendered as template
+		if expr.HasTemplate(v) {
+			tpl, err := expr.ParseTemplate(v)
+			if err != nil {
+				return err
+			}
+			result, err := tpl.Render(exprEnv)
+			if err != nil {
+				return err
+			}
+			walkContext.Setter(result)
+			return nil
+		}
 		if strings.Contains(v, "{{") && strings.Contains(v, "}}") {
 			result, err := e.paramRender.Render(v, data)
 			if err != nil {
@@ -256,6 +535,80 @@ func (e *DefExecutor) renderParams(taskIns *entity.TaskInstance) error {
 	return nil
 }
 
+// buildRenderData build the data used to render the templates of task instance
+func buildRenderData(dagIns *entity.DagInstance, taskIns *entity.TaskInstance) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	if dagIns != nil {
+		data["vars"] = dagIns.Vars
+		if dagIns.ShareData != nil {
+			data["shareData"] = dagIns.ShareData.Dict
+		}
+	}
+	if taskIns.MapParentID != "" {
+		data["item"] = taskIns.MapItem
+	}
+
+	tasks, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+		DagInsID: taskIns.DagInsID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	data["tasks"] = buildTasksRenderData(tasks)
+	return data, nil
+}
+
+// buildExprEnv build the env used to evaluate the expressions in params of task instance
+func buildExprEnv(dagIns *entity.DagInstance, taskIns *entity.TaskInstance) expr.Env {
+	if dagIns == nil {
+		dagIns = &entity.DagInstance{}
+	}
+	env := dagIns.ExprEnv(taskInsGetter(taskIns.DagInsID))
+	if taskIns.MapParentID != "" {
+		env["item"] = taskIns.MapItem
+	}
+	return env
+}
+
+// buildTasksRenderData build the data of tasks, so params can reference the output of other tasks,
+// such as "{{.tasks.taskId.output.field}}"
+func buildTasksRenderData(tasks []*entity.TaskInstance) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for _, t := range tasks {
+		ret[t.TaskID] = map[string]interface{}{
+			"status": t.Status,
+			"output": t.Output,
+		}
+	}
+	return ret
+}
+
+// taskInsGetter return a getter of task instance by task id,
+// task instances are loaded only when it is called at first time
+func taskInsGetter(dagInsId string) entity.TaskInsGetter {
+	var (
+		once  sync.Once
+		tasks map[string]*entity.TaskInstance
+	)
+	return func(taskId string) (*entity.TaskInstance, bool) {
+		once.Do(func() {
+			ret, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+				DagInsID: dagInsId,
+			})
+			if err != nil {
+				log.Errorf("list task instances of dag instance[%s] failed: %s", dagInsId, err)
+				return
+			}
+			tasks = map[string]*entity.TaskInstance{}
+			for _, t := range ret {
+				tasks[t.TaskID] = t
+			}
+		})
+		t, ok := tasks[taskId]
+		return t, ok
+	}
+}
+
 // Close
 func (e *DefExecutor) Close() {
 	e.lock.Lock()
@@ -266,13 +619,18 @@ func (e *DefExecutor) Close() {
 
 	close(e.initQueue)
 	e.initWg.Wait()
-	close(e.workerQueue)
+	e.workerQueue.close()
 	e.workerWg.Wait()
 }
 
 func (e *DefExecutor) handleTaskError(taskIns *entity.TaskInstance, err error) {
 	_, ok := e.cancelMap.Load(taskIns.ID)
 	if err != nil {
+		// canceled task should not be retried
+		if ok && e.retryTask(taskIns, err) {
+			return
+		}
+
 		taskIns.Reason = err.Error()
 		setStatus := entity.TaskInstanceStatusFailed
 		if !ok {
@@ -299,3 +657,28 @@ func (e *DefExecutor) handleTaskError(taskIns *entity.TaskInstance, err error) {
 		log.Errorf("tag canceled task instance[%s] failed: %s", taskIns.ID, pErr)
 	}
 }
+
+// retryTask schedule next attempt of the failed task according to its retry policy,
+// the task is pushed again by parser, and waits in executor until the retry time,
+// return false if the task should not be retried
+func (e *DefExecutor) retryTask(taskIns *entity.TaskInstance, err error) bool {
+	if taskIns.Attempt == 0 {
+		taskIns.Attempt = 1
+	}
+	if !taskIns.Retry.ShouldRetry(taskIns.Attempt, err) {
+		return false
+	}
+
+	delay := taskIns.Retry.Delay(taskIns.Attempt)
+	taskIns.Reason = err.Error()
+	taskIns.Attempt++
+	taskIns.RetryAt = time.Now().Add(delay).UnixMilli()
+	taskIns.Trace(fmt.Sprintf("attempt %d failed, retry after %s", taskIns.Attempt-1, delay))
+	if sErr := taskIns.SetStatus(entity.TaskInstanceStatusRetrying); sErr != nil {
+		log.Error("set status failed",
+			"task_id", taskIns.ID,
+			"err", sErr)
+		return false
+	}
+	return true
+}
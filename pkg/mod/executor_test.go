@@ -0,0 +1,149 @@
+package mod_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/entity/run"
+	"github.com/weeyp/fastflow/pkg/mod"
+)
+
+// recordParser record the task instances entered by executor
+type recordParser struct {
+	entered chan *entity.TaskInstance
+}
+
+func (p *recordParser) InitialDagIns(dagIns *entity.DagInstance) {}
+
+func (p *recordParser) EntryTaskIns(taskIns *entity.TaskInstance) {
+	p.entered <- taskIns
+}
+
+func initTestExecutor(t *testing.T) (*mod.DefExecutor, *recordParser) {
+	parser := &recordParser{entered: make(chan *entity.TaskInstance, 10)}
+	mod.SetParser(parser)
+	exe := mod.NewDefExecutor(time.Minute, 1)
+	exe.Init()
+	t.Cleanup(exe.Close)
+	return exe, parser
+}
+
+func createRetryingTask(t *testing.T, store mod.Store, retryAt time.Time) (*entity.DagInstance, *entity.TaskInstance) {
+	dagIns := createDagIns(t, store, &entity.DagInstance{
+		DagID:     "dag",
+		Worker:    "w1",
+		Status:    entity.DagInstanceStatusRunning,
+		ShareData: &entity.ShareData{},
+	})
+	taskIns := &entity.TaskInstance{
+		DagInsID: dagIns.ID,
+		TaskID:   "task",
+		Status:   entity.TaskInstanceStatusRetrying,
+		RetryAt:  retryAt.UnixMilli(),
+	}
+	require.NoError(t, store.BatchCreatTaskIns([]*entity.TaskInstance{taskIns}))
+	return dagIns, taskIns
+}
+
+func TestDefExecutor_WaitRetry(t *testing.T) {
+	store := initTestEnv(t, nil)
+	exe, parser := initTestExecutor(t)
+
+	retryAt := time.Now().Add(200 * time.Millisecond)
+	dagIns, taskIns := createRetryingTask(t, store, retryAt)
+	exe.Push(dagIns, taskIns)
+
+	select {
+	case entered := <-parser.entered:
+		assert.GreaterOrEqual(t, time.Now().UnixMilli(), retryAt.UnixMilli())
+		// parser decides whether to push it again
+		assert.Equal(t, entity.TaskInstanceStatusRetrying, entered.Status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("task instance is not retried")
+	}
+}
+
+func TestDefExecutor_CancelWaitingRetry(t *testing.T) {
+	store := initTestEnv(t, nil)
+	exe, parser := initTestExecutor(t)
+
+	dagIns, taskIns := createRetryingTask(t, store, time.Now().Add(time.Hour))
+	exe.Push(dagIns, taskIns)
+
+	var entered *entity.TaskInstance
+	assert.Eventually(t, func() bool {
+		require.NoError(t, exe.CancelTaskIns([]string{taskIns.ID}))
+		select {
+		case entered = <-parser.entered:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NotNil(t, entered)
+	assert.Equal(t, entity.TaskInstanceStatusCanceled, entered.Status)
+
+	got, err := store.GetTaskIns(taskIns.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.TaskInstanceStatusCanceled, got.Status)
+	assert.Equal(t, mod.ReasonCanceledInRetry, got.Reason)
+}
+
+// sleepAction sleep a while unless it is canceled or timeout
+type sleepAction struct {
+	duration time.Duration
+}
+
+func (a *sleepAction) Name() string {
+	return "sleep"
+}
+
+func (a *sleepAction) Run(ctx run.ExecuteContext, params interface{}) error {
+	select {
+	case <-time.After(a.duration):
+		return nil
+	case <-ctx.Context().Done():
+		return ctx.Context().Err()
+	}
+}
+
+func TestDefExecutor_TimeoutExcludesQueueing(t *testing.T) {
+	store := initTestEnv(t, nil)
+	exe, parser := initTestExecutor(t)
+	mod.ActionMap["sleep"] = &sleepAction{duration: 600 * time.Millisecond}
+	defer delete(mod.ActionMap, "sleep")
+
+	dagIns := createDagIns(t, store, &entity.DagInstance{
+		DagID:     "dag",
+		Worker:    "w1",
+		Status:    entity.DagInstanceStatusRunning,
+		ShareData: &entity.ShareData{},
+	})
+	var tasks []*entity.TaskInstance
+	for _, id := range []string{"task1", "task2"} {
+		tasks = append(tasks, &entity.TaskInstance{
+			DagInsID:    dagIns.ID,
+			TaskID:      id,
+			ActionName:  "sleep",
+			TimeoutSecs: 1,
+			Status:      entity.TaskInstanceStatusInit,
+		})
+	}
+	require.NoError(t, store.BatchCreatTaskIns(tasks))
+	// there is only one worker, so the second task waits for the first one in queue
+	for _, taskIns := range tasks {
+		exe.Push(dagIns, taskIns)
+	}
+
+	for range tasks {
+		select {
+		case entered := <-parser.entered:
+			assert.Equal(t, entity.TaskInstanceStatusSuccess, entered.Status, entered.TaskID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("task instance is not completed")
+		}
+	}
+}
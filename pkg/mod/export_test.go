@@ -0,0 +1,38 @@
+package mod
+
+import (
+	"time"
+
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+// MissedScheduleTimes export missedScheduleTimes for testing
+var MissedScheduleTimes = missedScheduleTimes
+
+// PatchDagInsIfOwned export patchDagInsIfOwned for testing
+var PatchDagInsIfOwned = patchDagInsIfOwned
+
+// WatchDagInsCmd export watchDagInsCmd for testing
+func (p *DefParser) WatchDagInsCmd() error {
+	return p.watchDagInsCmd()
+}
+
+// WatchDueDagIns export watchDueDagIns for testing
+func (p *DefParser) WatchDueDagIns() error {
+	return p.watchDueDagIns()
+}
+
+// ExecuteNext export executeNext for testing
+func (p *DefParser) ExecuteNext(taskIns *entity.TaskInstance) error {
+	return p.executeNext(taskIns)
+}
+
+// SetNow replace the clock of scheduler for testing
+func (s *DefScheduler) SetNow(now func() time.Time) {
+	s.now = now
+}
+
+// Tick export tick for testing
+func (s *DefScheduler) Tick() {
+	s.tick()
+}
@@ -0,0 +1,194 @@
+package mod
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiningrush/goevent"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/event"
+	"github.com/weeyp/fastflow/pkg/log"
+)
+
+// KeeperOption used to initial default keeper
+type KeeperOption struct {
+	// WorkerKey is the unique identity of worker, it must be different in each worker
+	WorkerKey string
+	// Labels of worker, dag instances which have selector only run at matched workers
+	Labels map[string]string
+	// HeartbeatInterval default 1s
+	HeartbeatInterval time.Duration
+	// UnhealthyTime is the duration that a worker is treated as dead after the last heartbeat,
+	// it is also the lease time of leader, default 5s
+	UnhealthyTime time.Duration
+}
+
+// DefKeeper is default keeper, it is based on the Store
+type DefKeeper struct {
+	opt      *KeeperOption
+	isLeader int32 // 1 means current worker is leader
+
+	closeCh chan struct{}  // close channel
+	wg      sync.WaitGroup // wait group
+	once    sync.Once      // close once
+}
+
+// NewDefKeeper create a default keeper
+func NewDefKeeper(opt *KeeperOption) *DefKeeper {
+	if opt.HeartbeatInterval == 0 {
+		opt.HeartbeatInterval = time.Second
+	}
+	if opt.UnhealthyTime == 0 {
+		opt.UnhealthyTime = 5 * time.Second
+	}
+	return &DefKeeper{
+		opt:     opt,
+		closeCh: make(chan struct{}),
+	}
+}
+
+// Init register the worker and campaign leader, then keep them in background
+func (k *DefKeeper) Init() error {
+	if k.opt.WorkerKey == "" {
+		return fmt.Errorf("worker key cannot be empty")
+	}
+	if k.opt.UnhealthyTime <= k.opt.HeartbeatInterval {
+		return fmt.Errorf("unhealthy time must be greater than heartbeat interval")
+	}
+
+	if err := k.heartbeat(); err != nil {
+		return fmt.Errorf("first heartbeat failed: %w", err)
+	}
+	if err := k.campaign(); err != nil {
+		return fmt.Errorf("first campaign failed: %w", err)
+	}
+
+	k.wg.Add(1)
+	go k.goKeep()
+	return nil
+}
+
+func (k *DefKeeper) goKeep() {
+	defer k.wg.Done()
+
+	ticker := time.NewTicker(k.opt.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.closeCh:
+			return
+		case <-ticker.C:
+			if err := k.heartbeat(); err != nil {
+				k.handleErr(fmt.Errorf("heartbeat failed: %w", err))
+			}
+			if err := k.campaign(); err != nil {
+				k.handleErr(fmt.Errorf("campaign failed: %w", err))
+			}
+		}
+	}
+}
+
+func (k *DefKeeper) heartbeat() error {
+	return GetStore().Heartbeat(&entity.Node{
+		Key:         k.opt.WorkerKey,
+		HeartbeatAt: time.Now().UnixMilli(),
+		Labels:      k.opt.Labels,
+	})
+}
+
+func (k *DefKeeper) campaign() error {
+	isLeader, err := GetStore().CampaignLeader(k.opt.WorkerKey, k.opt.UnhealthyTime)
+	if err != nil {
+		// we can not ensure the lease is still held, so give up leader to avoid split brain
+		isLeader = false
+	}
+	k.setLeader(isLeader)
+	return err
+}
+
+func (k *DefKeeper) setLeader(isLeader bool) {
+	var newVal int32
+	if isLeader {
+		newVal = 1
+	}
+	if atomic.SwapInt32(&k.isLeader, newVal) == newVal {
+		return
+	}
+
+	log.Infof("worker[%s] leader changed, is leader: %t", k.opt.WorkerKey, isLeader)
+	goevent.Publish(&event.LeaderChanged{
+		IsLeader:  isLeader,
+		WorkerKey: k.opt.WorkerKey,
+	})
+}
+
+// IsLeader indicate whether current worker is leader
+func (k *DefKeeper) IsLeader() bool {
+	return atomic.LoadInt32(&k.isLeader) == 1
+}
+
+// IsAlive check whether the worker is alive
+func (k *DefKeeper) IsAlive(workerKey string) (bool, error) {
+	nodes, err := k.AliveNodes()
+	if err != nil {
+		return false, err
+	}
+	for i := range nodes {
+		if nodes[i].Key == workerKey {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AliveNodes return all alive workers
+func (k *DefKeeper) AliveNodes() ([]*entity.Node, error) {
+	nodes, err := GetStore().ListNode()
+	if err != nil {
+		return nil, err
+	}
+
+	var aliveNodes []*entity.Node
+	deadline := time.Now().Add(-k.opt.UnhealthyTime).UnixMilli()
+	for i := range nodes {
+		if heartbeatMilli(nodes[i]) >= deadline {
+			aliveNodes = append(aliveNodes, nodes[i])
+		}
+	}
+	return aliveNodes, nil
+}
+
+// heartbeatMilli return the heartbeat time of node in unix milliseconds,
+// the node which is not upgraded yet reports its heartbeat in seconds
+func heartbeatMilli(node *entity.Node) int64 {
+	if node.HeartbeatAt < 1e12 {
+		return node.HeartbeatAt * 1000
+	}
+	return node.HeartbeatAt
+}
+
+// WorkerKey return the key of current worker
+func (k *DefKeeper) WorkerKey() string {
+	return k.opt.WorkerKey
+}
+
+// WorkerLabels return the labels of current worker
+func (k *DefKeeper) WorkerLabels() map[string]string {
+	return k.opt.Labels
+}
+
+// Close keeper
+func (k *DefKeeper) Close() {
+	k.once.Do(func() {
+		close(k.closeCh)
+		k.wg.Wait()
+	})
+}
+
+func (k *DefKeeper) handleErr(err error) {
+	log.Error("keeper get some error",
+		"module", "keeper",
+		"err", err)
+}
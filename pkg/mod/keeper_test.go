@@ -0,0 +1,65 @@
+package mod_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/mod"
+	"github.com/weeyp/fastflow/store/cache"
+)
+
+func TestDefKeeper_LeaderHandover(t *testing.T) {
+	mod.SetStore(cache.NewMemCache())
+	newKeeper := func(key string) *mod.DefKeeper {
+		k := mod.NewDefKeeper(&mod.KeeperOption{
+			WorkerKey:         key,
+			HeartbeatInterval: 20 * time.Millisecond,
+			UnhealthyTime:     100 * time.Millisecond,
+		})
+		require.NoError(t, k.Init())
+		t.Cleanup(k.Close)
+		return k
+	}
+
+	k1 := newKeeper("w1")
+	k2 := newKeeper("w2")
+	assert.True(t, k1.IsLeader())
+	assert.False(t, k2.IsLeader())
+
+	// the lease is renewed by heartbeat, so leader is not changed
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, k1.IsLeader())
+	assert.False(t, k2.IsLeader())
+
+	// the lease expires after the leader stops
+	k1.Close()
+	assert.Eventually(t, k2.IsLeader, 2*time.Second, 10*time.Millisecond)
+
+	// the unhealthy time is less than one second, it is not rounded
+	assert.Eventually(t, func() bool {
+		nodes, err := k2.AliveNodes()
+		require.NoError(t, err)
+		return len(nodes) == 1 && nodes[0].Key == "w2"
+	}, 500*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestDefKeeper_AliveNodesInSeconds(t *testing.T) {
+	store := cache.NewMemCache()
+	mod.SetStore(store)
+	k := mod.NewDefKeeper(&mod.KeeperOption{WorkerKey: "w1"})
+	require.NoError(t, k.Init())
+	t.Cleanup(k.Close)
+
+	// the heartbeat of worker which is not upgraded is in seconds
+	require.NoError(t, store.Heartbeat(&entity.Node{Key: "old", HeartbeatAt: time.Now().Unix()}))
+	require.NoError(t, store.Heartbeat(&entity.Node{Key: "dead", HeartbeatAt: time.Now().Add(-time.Hour).Unix()}))
+	alive, err := k.IsAlive("old")
+	require.NoError(t, err)
+	assert.True(t, alive)
+	alive, err = k.IsAlive("dead")
+	require.NoError(t, err)
+	assert.False(t, alive)
+}
@@ -0,0 +1,296 @@
+package mod
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+// pushTask push task instance to executor, the task which maps over items will be expanded instead
+func (p *DefParser) pushTask(tree *TaskTree, taskIns *entity.TaskInstance) error {
+	// the task will be pushed again when the dag instance is resumed
+	if tree.paused.Load() || tree.canceled.Load() {
+		return nil
+	}
+	if taskIns.MapOver == "" || taskIns.MapParentID != "" {
+		GetExecutor().Push(tree.DagIns, taskIns)
+		return nil
+	}
+	return p.startMappedTask(tree, taskIns)
+}
+
+// startMappedTask expand the task to mapped task instances, then push them
+func (p *DefParser) startMappedTask(tree *TaskTree, taskIns *entity.TaskInstance) error {
+	isActive, err := taskIns.DoPreCheck(tree.DagIns, taskInsGetter(taskIns.DagInsID))
+	if err != nil {
+		return fmt.Errorf("do task pre-check failed: %w", err)
+	}
+	if isActive {
+		if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+			ID:     taskIns.ID,
+			Status: taskIns.Status,
+		}); err != nil {
+			return err
+		}
+		return p.executeNext(taskIns)
+	}
+
+	mapped, err := listMappedTasks(taskIns)
+	if err != nil {
+		return err
+	}
+	if len(mapped) == 0 {
+		if mapped, err = p.expandMappedTask(tree.DagIns, taskIns); err != nil {
+			taskIns.Status = entity.TaskInstanceStatusFailed
+			taskIns.Reason = fmt.Sprintf("expand mapped task failed: %s", err)
+			if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+				ID:     taskIns.ID,
+				Status: taskIns.Status,
+				Reason: taskIns.Reason,
+			}); err != nil {
+				return err
+			}
+			return p.executeNext(taskIns)
+		}
+	}
+
+	// the task is retried, so the failed mapped task instances should run again
+	for _, t := range mapped {
+		if t.Status == entity.TaskInstanceStatusFailed || t.Status == entity.TaskInstanceStatusCanceled {
+			t.Status = entity.TaskInstanceStatusInit
+			t.Reason = ""
+			if err := GetStore().UpdateTaskIns(t); err != nil {
+				return err
+			}
+		}
+	}
+
+	taskIns.Status = entity.TaskInstanceStatusRunning
+	if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+		ID:     taskIns.ID,
+		Status: taskIns.Status,
+	}); err != nil {
+		return err
+	}
+	return p.scheduleMappedTasks(tree, taskIns, mapped, true)
+}
+
+func (p *DefParser) expandMappedTask(dagIns *entity.DagInstance, taskIns *entity.TaskInstance) ([]*entity.TaskInstance, error) {
+	data, err := buildRenderData(dagIns, taskIns)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := p.paramRender.Render(taskIns.MapOver, data)
+	if err != nil {
+		return nil, err
+	}
+	items, err := parseMapItems(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapped []*entity.TaskInstance
+	for i, item := range items {
+		params, err := copyParams(taskIns.Params)
+		if err != nil {
+			return nil, err
+		}
+		mapped = append(mapped, &entity.TaskInstance{
+			TaskID:      fmt.Sprintf("%s[%d]", taskIns.TaskID, i),
+			DagInsID:    taskIns.DagInsID,
+			Name:        taskIns.Name,
+			ActionName:  taskIns.ActionName,
+			TimeoutSecs: taskIns.TimeoutSecs,
+			Params:      params,
+			Status:      entity.TaskInstanceStatusInit,
+			Retry:       taskIns.Retry,
+			Pool:        taskIns.Pool,
+			Priority:    taskIns.Priority,
+			Attempt:     1,
+			MapParentID: taskIns.ID,
+			MapIndex:    i,
+			MapItem:     item,
+		})
+	}
+	if len(mapped) == 0 {
+		return nil, nil
+	}
+	if err := GetStore().BatchCreatTaskIns(mapped); err != nil {
+		return nil, err
+	}
+	return mapped, nil
+}
+
+// executeMappedNext handle the completed or retried mapped task instance
+func (p *DefParser) executeMappedNext(tree *TaskTree, taskIns *entity.TaskInstance) error {
+	if taskIns.Status == entity.TaskInstanceStatusInit || taskIns.Status == entity.TaskInstanceStatusRetrying {
+		// it will be pushed again when the dag instance is resumed
+		if !tree.paused.Load() {
+			GetExecutor().Push(tree.DagIns, taskIns)
+		}
+		return nil
+	}
+
+	parent, err := GetStore().GetTaskIns(taskIns.MapParentID)
+	if err != nil {
+		return err
+	}
+	if parent.Status != entity.TaskInstanceStatusRunning {
+		return nil
+	}
+	mapped, err := listMappedTasks(parent)
+	if err != nil {
+		return err
+	}
+	return p.scheduleMappedTasks(tree, parent, mapped, false)
+}
+
+// scheduleMappedTasks push the pending mapped task instances under the limit of max parallel,
+// and complete the parent task instance when all of them are completed.
+// initial means the task tree is just built, so the pushed ones are lost and should be pushed again
+func (p *DefParser) scheduleMappedTasks(
+	tree *TaskTree, parent *entity.TaskInstance, mapped []*entity.TaskInstance, initial bool) error {
+	var (
+		running   int
+		pending   []*entity.TaskInstance
+		failedIds []string
+	)
+	for _, t := range mapped {
+		switch t.Status {
+		case entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSkipped:
+		case entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusCanceled:
+			failedIds = append(failedIds, t.ID)
+		case entity.TaskInstanceStatusInit:
+			pending = append(pending, t)
+		case entity.TaskInstanceStatusWaiting, entity.TaskInstanceStatusRetrying:
+			// they are pushed already, unless the worker is restarted or the dag instance is resumed
+			if initial {
+				pending = append(pending, t)
+				continue
+			}
+			running++
+		default:
+			running++
+		}
+	}
+
+	if running == 0 && len(pending) == 0 {
+		parent.Status = entity.TaskInstanceStatusSuccess
+		if len(failedIds) > 0 {
+			parent.Status = entity.TaskInstanceStatusFailed
+			parent.Reason = fmt.Sprintf("mapped task instance[%s] failed", strings.Join(failedIds, ","))
+		}
+		parent.Output = aggregateMappedOutputs(mapped)
+		if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+			ID:     parent.ID,
+			Status: parent.Status,
+			Reason: parent.Reason,
+			Output: parent.Output,
+		}); err != nil {
+			return err
+		}
+		return p.executeNext(parent)
+	}
+
+	if tree.paused.Load() || tree.canceled.Load() {
+		return nil
+	}
+	for _, t := range pending {
+		if parent.MaxParallel > 0 && running >= parent.MaxParallel {
+			break
+		}
+		// the pushed one is persisted as waiting, so it is not pushed again by other completed ones
+		if t.Status == entity.TaskInstanceStatusInit {
+			if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+				ID:     t.ID,
+				Status: entity.TaskInstanceStatusWaiting,
+			}); err != nil {
+				return err
+			}
+		}
+		GetExecutor().Push(tree.DagIns, t)
+		running++
+	}
+	return nil
+}
+
+// MappedOutputsKey is the key of output of the task which maps over items,
+// it is the list of outputs of its mapped task instances in the order of items
+const MappedOutputsKey = "outputs"
+
+func aggregateMappedOutputs(mapped []*entity.TaskInstance) map[string]interface{} {
+	outputs := make([]interface{}, len(mapped))
+	for _, t := range mapped {
+		if t.MapIndex >= 0 && t.MapIndex < len(outputs) && t.Output != nil {
+			outputs[t.MapIndex] = t.Output
+		}
+	}
+	return map[string]interface{}{MappedOutputsKey: outputs}
+}
+
+func listMappedTasks(parent *entity.TaskInstance) ([]*entity.TaskInstance, error) {
+	tasks, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+		DagInsID: parent.DagInsID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var mapped []*entity.TaskInstance
+	for _, t := range tasks {
+		if t.MapParentID == parent.ID {
+			mapped = append(mapped, t)
+		}
+	}
+	return mapped, nil
+}
+
+// parseMapItems parse the rendered map over expression, it could be a json array or comma separated values
+func parseMapItems(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		var arr []interface{}
+		if err := json.Unmarshal([]byte(s), &arr); err != nil {
+			return nil, fmt.Errorf("parse items failed: %w", err)
+		}
+		items := make([]string, 0, len(arr))
+		for _, v := range arr {
+			if str, ok := v.(string); ok {
+				items = append(items, str)
+				continue
+			}
+			bs, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, string(bs))
+		}
+		return items, nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// copyParams deep copy params, because params will be rendered in place
+func copyParams(params map[string]interface{}) (map[string]interface{}, error) {
+	if params == nil {
+		return nil, nil
+	}
+	bs, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var ret map[string]interface{}
+	if err := json.Unmarshal(bs, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,79 @@
+package mod
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+func TestParseMapItems(t *testing.T) {
+	tests := []struct {
+		caseDesc  string
+		giveExpr  string
+		wantItems []string
+		wantErr   bool
+	}{
+		{
+			caseDesc:  "json array",
+			giveExpr:  ` ["a", 1, {"k":"v"}] `,
+			wantItems: []string{"a", "1", `{"k":"v"}`},
+		},
+		{
+			caseDesc:  "empty json array",
+			giveExpr:  "[]",
+			wantItems: []string{},
+		},
+		{
+			caseDesc:  "comma separated",
+			giveExpr:  "a, b,,c ",
+			wantItems: []string{"a", "b", "c"},
+		},
+		{
+			caseDesc: "empty",
+			giveExpr: " ",
+		},
+		{
+			caseDesc: "invalid json array",
+			giveExpr: "[a,b]",
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		items, err := parseMapItems(tc.giveExpr)
+		assert.Equal(t, tc.wantErr, err != nil, tc.caseDesc)
+		assert.Equal(t, tc.wantItems, items, tc.caseDesc)
+	}
+}
+
+func TestCopyParams(t *testing.T) {
+	params := map[string]interface{}{
+		"file": "{{.item}}",
+		"nested": map[string]interface{}{
+			"list": []interface{}{"a"},
+		},
+	}
+	copied, err := copyParams(params)
+	assert.NoError(t, err)
+	assert.Equal(t, params, copied)
+
+	copied["nested"].(map[string]interface{})["list"] = []interface{}{"b"}
+	assert.Equal(t, []interface{}{"a"}, params["nested"].(map[string]interface{})["list"])
+}
+
+func TestAggregateMappedOutputs(t *testing.T) {
+	mapped := []*entity.TaskInstance{
+		{MapIndex: 2, Output: map[string]interface{}{"n": 2}},
+		{MapIndex: 0, Output: map[string]interface{}{"n": 0}},
+		{MapIndex: 1},
+	}
+	assert.Equal(t, map[string]interface{}{
+		MappedOutputsKey: []interface{}{
+			map[string]interface{}{"n": 0},
+			nil,
+			map[string]interface{}{"n": 2},
+		},
+	}, aggregateMappedOutputs(mapped))
+	assert.Equal(t, map[string]interface{}{MappedOutputsKey: []interface{}{}}, aggregateMappedOutputs(nil))
+}
@@ -28,6 +28,7 @@ var (
 
 	defExc       Executor
 	defStore     Store
+	defKeeper    Keeper
 	defParser    Parser
 	defCommander Commander
 )
@@ -35,9 +36,18 @@ var (
 // Commander used to execute command
 type Commander interface {
 	RunDag(dagId string, specVar map[string]string) (*entity.DagInstance, error)
+	RunDagAt(dagId string, specVar map[string]string, at time.Time) (*entity.DagInstance, error)
+	RunDagAfter(dagId string, specVar map[string]string, delay time.Duration) (*entity.DagInstance, error)
 	RetryDagIns(dagInsId string, ops ...CommandOptSetter) error
 	RetryTask(taskInsIds []string, ops ...CommandOptSetter) error
 	CancelTask(taskInsIds []string, ops ...CommandOptSetter) error
+	PauseDagIns(dagInsId string, ops ...CommandOptSetter) error
+	ResumeDagIns(dagInsId string, ops ...CommandOptSetter) error
+	CancelDagIns(dagInsId, reason string, ops ...CommandOptSetter) error
+	SkipTask(taskInsIds []string, reason string, ops ...CommandOptSetter) error
+	MarkTaskSuccess(taskInsIds []string, reason string, ops ...CommandOptSetter) error
+	RerunFrom(dagInsId, taskId string, includeDownstream bool, ops ...CommandOptSetter) error
+	Backfill(dagId string, from, to time.Time) ([]*entity.DagInstance, error)
 }
 
 // CommandOption is used to set command option
@@ -52,6 +62,8 @@ type CommandOption struct {
 	// syncInterval is just work at sync mode, it is the interval of watch dag instance
 	// default is 500ms
 	syncInterval time.Duration
+	// issuer is who issue the command
+	issuer string
 }
 type CommandOptSetter func(opt *CommandOption)
 
@@ -83,6 +95,13 @@ var (
 			}
 		}
 	}
+	// CommIssuer set who issue the command, it is saved with the command,
+	// and recorded in the traces of task instances by the commands such as "skip" and "mark-success"
+	CommIssuer = func(issuer string) CommandOptSetter {
+		return func(opt *CommandOption) {
+			opt.issuer = issuer
+		}
+	}
 )
 
 // SetCommander set commander
@@ -113,6 +132,31 @@ func GetExecutor() Executor {
 	return defExc
 }
 
+// Keeper used to keep the worker alive and campaign leader
+type Keeper interface {
+	Closer
+	// IsLeader indicate whether current worker is leader
+	IsLeader() bool
+	// IsAlive check whether the worker is alive
+	IsAlive(workerKey string) (bool, error)
+	// AliveNodes return all alive workers
+	AliveNodes() ([]*entity.Node, error)
+	// WorkerKey return the key of current worker
+	WorkerKey() string
+	// WorkerLabels return the labels of current worker
+	WorkerLabels() map[string]string
+}
+
+// SetKeeper set keeper
+func SetKeeper(e Keeper) {
+	defKeeper = e
+}
+
+// GetKeeper get keeper
+func GetKeeper() Keeper {
+	return defKeeper
+}
+
 // Closer means the component need be closeFunc
 type Closer interface {
 	Close()
@@ -121,11 +165,15 @@ type Closer interface {
 // Store used to persist obj
 type Store interface {
 	Closer
+	// CreateDag create the dag, it should reject the dag which can not pass Dag.Validate or CheckSubDagCycle,
+	// so the invalid dag can not be persisted by any source
 	CreateDag(dag *entity.Dag) error
+	// CreateDagIns create the dag instance, it should set CreatedAt if it is empty
 	CreateDagIns(dagIns *entity.DagInstance) error
 	BatchCreatTaskIns(taskIns []*entity.TaskInstance) error
 	PatchTaskIns(taskIns *entity.TaskInstance) error
 	PatchDagIns(dagIns *entity.DagInstance, mustsPatchFields ...string) error
+	// UpdateDag update the dag, it should validate the dag like CreateDag
 	UpdateDag(dagIns *entity.Dag) error
 	UpdateDagIns(dagIns *entity.DagInstance) error
 	UpdateTaskIns(taskIns *entity.TaskInstance) error
@@ -133,19 +181,68 @@ type Store interface {
 	BatchUpdateTaskIns(taskIns []*entity.TaskInstance) error
 	GetTaskIns(taskIns string) (*entity.TaskInstance, error)
 	GetDag(dagId string) (*entity.Dag, error)
+	ListDag(input *ListDagInput) ([]*entity.Dag, error)
 	GetDagInstance(dagInsId string) (*entity.DagInstance, error)
 	ListDagInstance(input *ListDagInstanceInput) ([]*entity.DagInstance, error)
 	ListTaskInstance(input *ListTaskInstanceInput) ([]*entity.TaskInstance, error)
+	// CreateCommand append the command to the queue of its dag instance
+	CreateCommand(cmd *entity.Command) error
+	// PatchCommand update the status, result and executed time of command,
+	// the executed command can be removed after a while, it is only kept for the sync callers
+	PatchCommand(cmd *entity.Command) error
+	// GetCommand return a copy of command, so it can be read while the command is executing
+	GetCommand(cmdId string) (*entity.Command, error)
+	// ListCommand list copies of commands in the order of their created time
+	ListCommand(input *ListCommandInput) ([]*entity.Command, error)
+	// Heartbeat create or update the node
+	Heartbeat(node *entity.Node) error
+	ListNode() ([]*entity.Node, error)
+	// CampaignLeader try to hold or renew the leader lease, it should be atomic
+	CampaignLeader(workerKey string, leaseTime time.Duration) (bool, error)
 	Marshal(obj interface{}) ([]byte, error)
 	Unmarshal(bytes []byte, ptr interface{}) error
 }
 
+// OwnedDagInsPatcher is an optional interface of Store, it fences the dag instance which is re-dispatched,
+// the store which does not implement it is checked by reading the instance before patching, which is not atomic
+type OwnedDagInsPatcher interface {
+	// PatchDagInsIfOwned patch the dag instance only when its worker is the given one, it should be atomic,
+	// return false if the dag instance is owned by other worker
+	PatchDagInsIfOwned(worker string, dagIns *entity.DagInstance, mustsPatchFields ...string) (bool, error)
+}
+
+// TaskInsDeleter is an optional interface of Store, the mapped task instances are deleted by it
+// when their task is rerun, so the task is expanded again with the latest items,
+// the store which does not implement it resets and reuses them
+type TaskInsDeleter interface {
+	BatchDeleteTaskIns(taskInsIds []string) error
+}
+
+// ListDagInput list dag input
+type ListDagInput struct {
+	Status []entity.DagStatus
+}
+
 // ListDagInstanceInput list dag instance input
 type ListDagInstanceInput struct {
 	DagID      string
+	Worker     string
+	Trigger    entity.Trigger
 	UpdatedEnd int64
 	Status     []entity.DagInstanceStatus
-	HasCmd     bool
+	// Deprecated: commands are queued in store by CreateCommand, HasCmd only finds the instances
+	// whose command is saved in DagInstance.Cmd by the earlier versions
+	HasCmd bool
+
+	// ParentDagInsID and ParentTaskInsID are used to find the instances started as sub dag
+	ParentDagInsID  string
+	ParentTaskInsID string
+}
+
+// ListCommandInput list command input
+type ListCommandInput struct {
+	DagInsID string
+	Status   []entity.CommandStatus
 }
 
 // ListTaskInstanceInput list task instance input
@@ -0,0 +1,49 @@
+package mod
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+// ErrDagInsNotOwned means the dag instance has been re-dispatched to another worker,
+// such as current worker was treated as dead, so current worker should stop writing it
+var ErrDagInsNotOwned = errors.New("dag instance is not owned by current worker")
+
+// patchDagInsIfOwned patch the dag instance only when it is owned by the worker,
+// it is atomic if store implements OwnedDagInsPatcher
+func patchDagInsIfOwned(worker string, dagIns *entity.DagInstance, mustsPatchFields ...string) error {
+	if patcher, ok := GetStore().(OwnedDagInsPatcher); ok {
+		patched, err := patcher.PatchDagInsIfOwned(worker, dagIns, mustsPatchFields...)
+		if err != nil {
+			return err
+		}
+		if !patched {
+			return fmt.Errorf("%w: dag instance[%s] is not owned by worker[%s]", ErrDagInsNotOwned, dagIns.ID, worker)
+		}
+		return nil
+	}
+
+	if err := checkDagInsOwned(worker, dagIns.ID); err != nil {
+		return err
+	}
+	return GetStore().PatchDagIns(dagIns, mustsPatchFields...)
+}
+
+// patchOwnedDagIns patch the dag instance which is executed by current worker
+func patchOwnedDagIns(dagIns *entity.DagInstance, mustsPatchFields ...string) error {
+	return patchDagInsIfOwned(GetKeeper().WorkerKey(), dagIns, mustsPatchFields...)
+}
+
+// checkDagInsOwned return ErrDagInsNotOwned if the dag instance is not owned by the worker
+func checkDagInsOwned(worker, dagInsId string) error {
+	dagIns, err := GetStore().GetDagInstance(dagInsId)
+	if err != nil {
+		return err
+	}
+	if dagIns.Worker != worker {
+		return fmt.Errorf("%w: dag instance[%s] is owned by worker[%s]", ErrDagInsNotOwned, dagInsId, dagIns.Worker)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package mod
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -11,6 +12,7 @@ import (
 	"github.com/weeyp/fastflow/pkg/entity"
 	"github.com/weeyp/fastflow/pkg/event"
 	"github.com/weeyp/fastflow/pkg/log"
+	"github.com/weeyp/fastflow/pkg/render"
 	"github.com/weeyp/fastflow/pkg/utils"
 )
 
@@ -21,6 +23,7 @@ type DefParser struct {
 	workerWg     sync.WaitGroup              // worker wait group
 	taskTrees    sync.Map                    // map[string]*TaskTree
 	taskTimeout  time.Duration               // default timeout
+	paramRender  *render.TplRender           // param render
 
 	closeCh chan struct{} // close channel
 	lock    sync.RWMutex  // lock
@@ -33,6 +36,7 @@ func NewDefParser(workerNumber int, taskTimeout time.Duration) *DefParser {
 		workerWg:     sync.WaitGroup{},
 		closeCh:      make(chan struct{}),
 		taskTimeout:  taskTimeout,
+		paramRender:  render.NewTplRender(),
 	}
 }
 
@@ -42,6 +46,10 @@ func (p *DefParser) Init() {
 	go p.startWatcher(p.watchScheduledDagIns)
 	p.workerWg.Add(1)
 	go p.startWatcher(p.watchDagInsCmd)
+	p.workerWg.Add(1)
+	go p.startWatcher(p.watchDagInsDeadline)
+	p.workerWg.Add(1)
+	go p.startWatcher(p.watchDueDagIns)
 
 	for i := 0; i < p.workerNumber; i++ {
 		p.workerWg.Add(1)
@@ -83,6 +91,7 @@ func (p *DefParser) watchScheduledDagIns() (err error) {
 	}()
 
 	dagIns, err := GetStore().ListDagInstance(&ListDagInstanceInput{
+		Worker: GetKeeper().WorkerKey(),
 		Status: []entity.DagInstanceStatus{
 			entity.DagInstanceStatusInit,
 		},
@@ -91,6 +100,15 @@ func (p *DefParser) watchScheduledDagIns() (err error) {
 		return
 	}
 	for i := range dagIns {
+		matched, mErr := dagIns[i].MatchLabels(GetKeeper().WorkerLabels())
+		// the labels of worker may be changed after dispatching, leader will re-dispatch it to a matched worker
+		if mErr != nil || !matched {
+			log.Warn("worker does not match the selector of dag instance, skip it",
+				utils.LogKeyDagInsID, dagIns[i].ID,
+				"selector", dagIns[i].Selector,
+				"err", mErr)
+			continue
+		}
 		if err = p.parseScheduleDagIns(dagIns[i]); err != nil {
 			return
 		}
@@ -99,6 +117,37 @@ func (p *DefParser) watchScheduledDagIns() (err error) {
 	return
 }
 
+// watchDueDagIns promote the scheduled dag instances to init when their schedule time comes,
+// only leader does it, then they are dispatched like other new instances
+func (p *DefParser) watchDueDagIns() error {
+	if !GetKeeper().IsLeader() {
+		return nil
+	}
+
+	dagIns, err := GetStore().ListDagInstance(&ListDagInstanceInput{
+		Status: []entity.DagInstanceStatus{
+			entity.DagInstanceStatusScheduled,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("watch due dag ins failed: %w", err)
+	}
+
+	now := time.Now()
+	for _, ins := range dagIns {
+		if !ins.IsDue(now) {
+			continue
+		}
+		if err := GetStore().PatchDagIns(&entity.DagInstance{
+			ID:     ins.ID,
+			Status: entity.DagInstanceStatusInit,
+		}); err != nil {
+			return fmt.Errorf("promote dag instance[%s] failed: %w", ins.ID, err)
+		}
+	}
+	return nil
+}
+
 func (p *DefParser) watchDagInsCmd() (err error) {
 	defer func() {
 		if err != nil {
@@ -106,15 +155,77 @@ func (p *DefParser) watchDagInsCmd() (err error) {
 		}
 	}()
 
+	if err := p.queueLegacyCmd(); err != nil {
+		return err
+	}
+	cmds, err := GetStore().ListCommand(&ListCommandInput{
+		Status: []entity.CommandStatus{entity.CommandStatusPending},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the commands of a dag instance are executed in order,
+	// so the later commands have to wait when one of them is not executed
+	waiting := map[string]struct{}{}
+	dagInsMap := map[string]*entity.DagInstance{}
+	var errs []error
+	for _, cmd := range cmds {
+		if _, ok := waiting[cmd.DagInsID]; ok {
+			continue
+		}
+		dagIns, ok := dagInsMap[cmd.DagInsID]
+		if !ok {
+			ins, gErr := GetStore().GetDagInstance(cmd.DagInsID)
+			if gErr != nil {
+				errs = append(errs, fmt.Errorf("get dag instance[%s] failed: %w", cmd.DagInsID, gErr))
+				waiting[cmd.DagInsID] = struct{}{}
+				continue
+			}
+			dagIns = ins
+			dagInsMap[cmd.DagInsID] = dagIns
+		}
+		// the command is executed by the worker which the dag instance is dispatched to
+		if dagIns.Worker != GetKeeper().WorkerKey() {
+			waiting[cmd.DagInsID] = struct{}{}
+			continue
+		}
+
+		dagIns.Cmd = cmd
+		if pErr := p.parseCmd(dagIns); pErr != nil {
+			errs = append(errs, pErr)
+			waiting[cmd.DagInsID] = struct{}{}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// queueLegacyCmd move the commands saved in dag instances by the earlier versions to the queue in store,
+// so they are executed like the commands created by current version
+func (p *DefParser) queueLegacyCmd() error {
 	dagIns, err := GetStore().ListDagInstance(&ListDagInstanceInput{
+		Worker: GetKeeper().WorkerKey(),
 		HasCmd: true,
 	})
 	if err != nil {
 		return err
 	}
-	for i := range dagIns {
-		if err = p.parseCmd(dagIns[i]); err != nil {
-			return err
+
+	for _, ins := range dagIns {
+		if ins.Cmd == nil {
+			continue
+		}
+		cmd := *ins.Cmd
+		cmd.ID = ""
+		cmd.DagInsID = ins.ID
+		cmd.Status = entity.CommandStatusPending
+		cmd.CreatedAt = time.Now().UnixMilli()
+		if err := GetStore().CreateCommand(&cmd); err != nil {
+			return fmt.Errorf("queue command of dag instance[%s] failed: %w", ins.ID, err)
+		}
+		ins.Cmd = nil
+		if err := GetStore().UpdateDagIns(ins); err != nil {
+			return fmt.Errorf("clear command of dag instance[%s] failed: %w", ins.ID, err)
 		}
 	}
 	return nil
@@ -131,8 +242,11 @@ func (p *DefParser) goWorker(queue <-chan *entity.TaskInstance) {
 
 func (p *DefParser) initialRunningDagIns() error {
 	dagIns, err := GetStore().ListDagInstance(&ListDagInstanceInput{
+		Worker: GetKeeper().WorkerKey(),
 		Status: []entity.DagInstanceStatus{
 			entity.DagInstanceStatusRunning,
+			// paused instances need task tree too, so they can continue when they are resumed
+			entity.DagInstanceStatusPaused,
 		},
 	})
 	if err != nil {
@@ -157,7 +271,19 @@ func (p *DefParser) InitialDagIns(dagIns *entity.DagInstance) {
 	if len(tasks) == 0 {
 		return
 	}
-	root, err := BuildRootNode(MapTaskInsToGetter(tasks))
+
+	var treeTasks, runningMappers []*entity.TaskInstance
+	for _, t := range tasks {
+		// mapped task instances are tracked by the task instance which they are expanded from
+		if t.MapParentID != "" {
+			continue
+		}
+		treeTasks = append(treeTasks, t)
+		if t.MapOver != "" && t.Status == entity.TaskInstanceStatusRunning {
+			runningMappers = append(runningMappers, t)
+		}
+	}
+	root, err := BuildRootNode(MapTaskInsToGetter(treeTasks))
 	if err != nil {
 		log.Errorf("dag instance[%s] build task tree failed: %s", dagIns.ID, err)
 		return
@@ -167,8 +293,14 @@ func (p *DefParser) InitialDagIns(dagIns *entity.DagInstance) {
 		DagIns: dagIns,
 		Root:   root,
 	}
+	tree.paused.Store(dagIns.Status == entity.DagInstanceStatusPaused)
+	taskMap := getTasksMap(tasks)
+	if _, err := resolveUnreachable(tree, taskMap); err != nil {
+		log.Errorf("dag instance[%s] resolve unreachable task instances failed: %s", dagIns.ID, err)
+		return
+	}
 	executableTaskIds := tree.Root.GetExecutableTaskIds()
-	if len(executableTaskIds) == 0 {
+	if len(executableTaskIds) == 0 && len(runningMappers) == 0 {
 		sts, taskInsId := tree.Root.ComputeStatus()
 		switch sts {
 		case TreeStatusSuccess:
@@ -183,7 +315,7 @@ func (p *DefParser) InitialDagIns(dagIns *entity.DagInstance) {
 			return
 		}
 
-		if err := GetStore().PatchDagIns(&entity.DagInstance{
+		if err := patchOwnedDagIns(&entity.DagInstance{
 			ID:     dagIns.ID,
 			Status: dagIns.Status}); err != nil {
 			log.Errorf("patch dag instance[%s] failed: %s", dagIns.ID, err)
@@ -193,10 +325,61 @@ func (p *DefParser) InitialDagIns(dagIns *entity.DagInstance) {
 	}
 
 	p.taskTrees.Store(dagIns.ID, tree)
-	taskMap := getTasksMap(tasks)
 	for _, tid := range executableTaskIds {
-		GetExecutor().Push(dagIns, taskMap[tid])
+		if err := p.pushTask(tree, taskMap[tid]); err != nil {
+			log.Errorf("dag instance[%s] push task instance[%s] failed: %s", dagIns.ID, tid, err)
+		}
+	}
+	for _, m := range runningMappers {
+		mapped, err := listMappedTasks(m)
+		if err != nil {
+			log.Errorf("dag instance[%s] list mapped task instances failed: %s", dagIns.ID, err)
+			continue
+		}
+		if err := p.scheduleMappedTasks(tree, m, mapped, true); err != nil {
+			log.Errorf("dag instance[%s] schedule mapped task instances failed: %s", dagIns.ID, err)
+		}
+	}
+}
+
+// resolveUnreachable persist the task instances whose trigger rule can never be met as upstream failed,
+// and the upstream failed ones which can be met again as init, such as their upstream tasks are retried or forced.
+// taskMap is updated if it is given, it returns the nodes which become init
+func resolveUnreachable(tree *TaskTree, taskMap map[string]*entity.TaskInstance) ([]*TaskNode, error) {
+	unreachable, reachable := tree.Root.ResolveUnreachable()
+	for _, n := range unreachable {
+		rule := n.TriggerRule
+		if rule == "" {
+			rule = entity.TriggerRuleAllSuccess
+		}
+		patch := &entity.TaskInstance{
+			ID:     n.TaskInsID,
+			Status: n.Status,
+			Reason: fmt.Sprintf(ReasonTriggerRuleNotMet, rule),
+		}
+		if err := GetStore().PatchTaskIns(patch); err != nil {
+			return nil, err
+		}
+		if t, ok := taskMap[n.TaskInsID]; ok {
+			t.Status, t.Reason = patch.Status, patch.Reason
+		}
 	}
+	for _, n := range reachable {
+		// the reason of upstream failed can not be cleared by patching
+		t, err := GetStore().GetTaskIns(n.TaskInsID)
+		if err != nil {
+			return nil, err
+		}
+		t.Status = n.Status
+		t.Reason = ""
+		if err := GetStore().UpdateTaskIns(t); err != nil {
+			return nil, err
+		}
+		if t, ok := taskMap[n.TaskInsID]; ok {
+			t.Status, t.Reason = n.Status, ""
+		}
+	}
+	return reachable, nil
 }
 
 func getTasksMap(tasks []*entity.TaskInstance) map[string]*entity.TaskInstance {
@@ -212,10 +395,54 @@ func (p *DefParser) executeNext(taskIns *entity.TaskInstance) error {
 	if !ok {
 		return fmt.Errorf("dag instance[%s] does not found task tree", taskIns.DagInsID)
 	}
+	if err := checkDagInsOwned(GetKeeper().WorkerKey(), taskIns.DagInsID); err != nil {
+		if !errors.Is(err, ErrDagInsNotOwned) {
+			return err
+		}
+		// the new worker initials it again, so current worker should not continue it
+		p.taskTrees.Delete(taskIns.DagInsID)
+		log.Warn("dag instance is re-dispatched, stop executing it",
+			utils.LogKeyDagInsID, taskIns.DagInsID,
+			"err", err)
+		return nil
+	}
+	if tree.canceled.Load() {
+		return p.completeCanceledTree(tree, taskIns)
+	}
+	if forced, ok := tree.forcedTasks.LoadAndDelete(taskIns.ID); ok {
+		latest, err := GetStore().GetTaskIns(taskIns.ID)
+		if err != nil {
+			return err
+		}
+		taskIns = forced.(*forcedTask).apply(latest)
+		if err := patchForcedTask(taskIns); err != nil {
+			return err
+		}
+	}
+	if taskIns.MapParentID != "" {
+		return p.executeMappedNext(tree, taskIns)
+	}
+	skipped, err := p.skipBranches(tree, taskIns)
+	if err != nil {
+		return err
+	}
 	ids, find := tree.Root.GetNextTaskIds(taskIns)
 	if !find {
 		return fmt.Errorf("task instance[%s] does not found normal node", taskIns.ID)
 	}
+	// the downstream tasks of skipped branches may be triggered, such as the task joining branches
+	for _, n := range skipped {
+		ids = n.executableChildren(ids)
+	}
+	reachable, err := resolveUnreachable(tree, nil)
+	if err != nil {
+		return err
+	}
+	for _, n := range reachable {
+		if n.Executable() && !utils.StringsContain(ids, n.TaskInsID) {
+			ids = append(ids, n.TaskInsID)
+		}
+	}
 	// only the tasks which is not success has no next task ids
 	if len(ids) == 0 {
 		treeStatus, taskId := tree.Root.ComputeStatus()
@@ -232,7 +459,7 @@ func (p *DefParser) executeNext(taskIns *entity.TaskInstance) error {
 
 		// tree has already completed, delete from map
 		p.taskTrees.Delete(taskIns.DagInsID)
-		if err := GetStore().PatchDagIns(&entity.DagInstance{
+		if err := patchOwnedDagIns(&entity.DagInstance{
 			ID:     tree.DagIns.ID,
 			Status: tree.DagIns.Status,
 			Reason: tree.DagIns.Reason,
@@ -246,10 +473,29 @@ func (p *DefParser) executeNext(taskIns *entity.TaskInstance) error {
 		return p.cancelChildTasks(tree, ids)
 	}
 
-	return p.pushTasks(tree.DagIns, ids)
+	return p.pushTasks(tree, ids)
 }
 
-func (p *DefParser) pushTasks(dagIns *entity.DagInstance, ids []string) error {
+// skipBranches skip the downstream tasks which are not chosen by the succeeded branch task
+func (p *DefParser) skipBranches(tree *TaskTree, taskIns *entity.TaskInstance) ([]*TaskNode, error) {
+	if taskIns.Status != entity.TaskInstanceStatusSuccess || taskIns.Branches == nil {
+		return nil, nil
+	}
+
+	skipped := tree.Root.SkipBranches(taskIns)
+	for _, n := range skipped {
+		if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+			ID:     n.TaskInsID,
+			Status: entity.TaskInstanceStatusSkipped,
+			Reason: fmt.Sprintf(ReasonBranchNotChosen, taskIns.TaskID),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return skipped, nil
+}
+
+func (p *DefParser) pushTasks(tree *TaskTree, ids []string) error {
 	tasks, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
 		IDs: ids,
 	})
@@ -257,7 +503,9 @@ func (p *DefParser) pushTasks(dagIns *entity.DagInstance, ids []string) error {
 		return err
 	}
 	for _, t := range tasks {
-		GetExecutor().Push(dagIns, t)
+		if err := p.pushTask(tree, t); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -269,7 +517,7 @@ func (p *DefParser) cancelChildTasks(tree *TaskTree, ids []string) error {
 			node.Status = entity.TaskInstanceStatusCanceled
 		}
 		return true
-	}, false)
+	})
 
 	for _, id := range ids {
 		if err := GetStore().PatchTaskIns(&entity.TaskInstance{
@@ -290,7 +538,241 @@ func (p *DefParser) cancelChildTasks(tree *TaskTree, ids []string) error {
 		return nil
 	}
 	tree.DagIns.Fail(fmt.Sprintf("task instance[%s] canceled", strings.Join(ids, ",")))
-	return GetStore().PatchDagIns(tree.DagIns)
+	return patchOwnedDagIns(tree.DagIns)
+}
+
+// completeCanceledTree only record the status of the completed task, because the dag instance is already canceled,
+// and delete the tree when there is no running task any more
+func (p *DefParser) completeCanceledTree(tree *TaskTree, taskIns *entity.TaskInstance) error {
+	if taskIns.MapParentID == "" {
+		tree.Root.GetNextTaskIds(taskIns)
+	}
+
+	running, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+		DagInsID: tree.DagIns.ID,
+		Status: []entity.TaskInstanceStatus{
+			entity.TaskInstanceStatusRunning,
+			entity.TaskInstanceStatusEnding,
+			entity.TaskInstanceStatusWaiting,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(running) == 0 {
+		p.taskTrees.Delete(tree.DagIns.ID)
+	}
+	return nil
+}
+
+// cancelDagIns cancel the running tasks, and mark the tasks which are not started as canceled,
+// the dag instance is marked canceled, or failed if it is timeout
+func (p *DefParser) cancelDagIns(dagIns *entity.DagInstance) error {
+	reason := ReasonDagInsCanceled
+	if dagIns.Cmd.Name == entity.CommandNameTimeoutDagIns {
+		reason = ReasonDagInsTimeout
+	}
+
+	tasks, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+		DagInsID: dagIns.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	var runningIds, canceledIds []string
+	for _, t := range tasks {
+		switch t.Status {
+		case entity.TaskInstanceStatusRunning, entity.TaskInstanceStatusEnding, entity.TaskInstanceStatusWaiting:
+			// mapped task instances are executed by executor, but the task which they are expanded from is not
+			if t.MapOver == "" || t.MapParentID != "" {
+				runningIds = append(runningIds, t.ID)
+				continue
+			}
+			canceledIds = append(canceledIds, t.ID)
+		case entity.TaskInstanceStatusInit, entity.TaskInstanceStatusRetrying, entity.TaskInstanceStatusBlocked:
+			canceledIds = append(canceledIds, t.ID)
+		}
+	}
+
+	// mark the tree at first, so the tasks completed during canceling will not trigger downstream tasks
+	tree, hasTree := p.getTaskTree(dagIns.ID)
+	if hasTree {
+		tree.canceled.Store(true)
+	}
+	for _, id := range canceledIds {
+		if err := GetStore().PatchTaskIns(&entity.TaskInstance{
+			ID:     id,
+			Status: entity.TaskInstanceStatusCanceled,
+			Reason: reason,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(runningIds) > 0 {
+		if err := GetExecutor().CancelTaskIns(runningIds); err != nil {
+			return err
+		}
+	}
+
+	if hasTree {
+		walkNode(tree.Root, func(node *TaskNode) bool {
+			if utils.StringsContain(canceledIds, node.TaskInsID) {
+				node.Status = entity.TaskInstanceStatusCanceled
+			}
+			return true
+		})
+		if len(runningIds) == 0 {
+			p.taskTrees.Delete(dagIns.ID)
+		}
+	}
+	if dagIns.Cmd.Name == entity.CommandNameTimeoutDagIns {
+		dagIns.Fail(dagIns.Cmd.Reason)
+		return nil
+	}
+	dagIns.MarkCanceled(dagIns.Cmd.Reason)
+	return nil
+}
+
+// resetTasks reset the task instances to init, so they can be executed again.
+// their mapped task instances are deleted, so they are expanded again with the latest items,
+// or they are reset too if store can not delete them
+func (p *DefParser) resetTasks(dagInsId string, ids []string) error {
+	tasks, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+		DagInsID: dagInsId,
+	})
+	if err != nil {
+		return err
+	}
+
+	deleter, canDelete := GetStore().(TaskInsDeleter)
+	var reset []*entity.TaskInstance
+	var deleted []string
+	for _, t := range tasks {
+		if !utils.StringsContain(ids, t.ID) && (t.MapParentID == "" || !utils.StringsContain(ids, t.MapParentID)) {
+			continue
+		}
+		if t.MapParentID != "" && canDelete {
+			deleted = append(deleted, t.ID)
+			continue
+		}
+		t.Status = entity.TaskInstanceStatusInit
+		t.Reason = ""
+		t.Output = nil
+		t.Branches = nil
+		// each run has its own retry budget, the earlier attempts are kept in traces
+		t.Attempt = 0
+		t.Attempts = nil
+		t.RetryAt = 0
+		t.Traces = append(t.Traces, entity.TraceInfo{
+			Time:    time.Now().Unix(),
+			Message: "rerun by command",
+		})
+		reset = append(reset, t)
+	}
+	if len(deleted) > 0 {
+		if err := deleter.BatchDeleteTaskIns(deleted); err != nil {
+			return err
+		}
+	}
+	return GetStore().BatchUpdateTaskIns(reset)
+}
+
+// forcedTask is the result of task instance forced by command
+type forcedTask struct {
+	status entity.TaskInstanceStatus
+	reason string
+	trace  entity.TraceInfo
+}
+
+func (f *forcedTask) apply(taskIns *entity.TaskInstance) *entity.TaskInstance {
+	taskIns.Status = f.status
+	taskIns.Reason = f.reason
+	taskIns.Traces = append(taskIns.Traces, f.trace)
+	return taskIns
+}
+
+// forceTasks force the status of tasks by command, and return a function to continue the downstream tasks
+func (p *DefParser) forceTasks(dagIns *entity.DagInstance) (func(), error) {
+	f := &forcedTask{
+		status: entity.TaskInstanceStatusSkipped,
+		reason: dagIns.Cmd.Reason,
+	}
+	if dagIns.Cmd.Name == entity.CommandNameMarkSuccess {
+		f.status = entity.TaskInstanceStatusSuccess
+	}
+	f.trace = entity.TraceInfo{
+		Time:    time.Now().Unix(),
+		Message: forcedTraceMessage(dagIns.Cmd, f.status),
+	}
+
+	tasks, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+		IDs: dagIns.Cmd.TargetTaskInsIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tree, hasTree := p.getTaskTree(dagIns.ID)
+	var runningIds []string
+	var forced []*entity.TaskInstance
+	for _, t := range tasks {
+		switch t.Status {
+		case entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSkipped:
+			continue
+		case entity.TaskInstanceStatusRunning, entity.TaskInstanceStatusEnding, entity.TaskInstanceStatusWaiting:
+			// the task is still executed by executor, so it is forced when it is completed
+			if hasTree && (t.MapOver == "" || t.MapParentID != "") {
+				tree.forcedTasks.Store(t.ID, f)
+				runningIds = append(runningIds, t.ID)
+				continue
+			}
+		}
+
+		if err := patchForcedTask(f.apply(t)); err != nil {
+			return nil, err
+		}
+		forced = append(forced, t)
+	}
+
+	if !hasTree {
+		if len(forced) == 0 {
+			return func() {}, nil
+		}
+		// the failed or blocked dag instance has no task tree, initial it again like retrying
+		dagIns.Run()
+		return func() {
+			p.InitialDagIns(dagIns)
+		}, nil
+	}
+	return func() {
+		if err := GetExecutor().CancelTaskIns(runningIds); err != nil {
+			log.Errorf("dag instance[%s] cancel forced task instances failed: %s", dagIns.ID, err)
+		}
+		for _, t := range forced {
+			p.EntryTaskIns(t)
+		}
+	}, nil
+}
+
+func forcedTraceMessage(cmd *entity.Command, status entity.TaskInstanceStatus) string {
+	msg := fmt.Sprintf("force to be %s by command", status)
+	if cmd.Issuer != "" {
+		msg += fmt.Sprintf(", issuer: %s", cmd.Issuer)
+	}
+	if cmd.Reason != "" {
+		msg += fmt.Sprintf(", reason: %s", cmd.Reason)
+	}
+	return msg
+}
+
+func patchForcedTask(taskIns *entity.TaskInstance) error {
+	return GetStore().PatchTaskIns(&entity.TaskInstance{
+		ID:     taskIns.ID,
+		Status: taskIns.Status,
+		Reason: taskIns.Reason,
+		Traces: taskIns.Traces,
+	})
 }
 
 func (p *DefParser) getTaskTree(dagInsId string) (*TaskTree, bool) {
@@ -386,10 +868,11 @@ func (p *DefParser) parseScheduleDagIns(dagIns *entity.DagInstance) error {
 		}
 
 		dagIns.Run()
-		if err := GetStore().PatchDagIns(&entity.DagInstance{
-			ID:     dagIns.ID,
-			Status: dagIns.Status,
-			Reason: dagIns.Reason,
+		if err := patchOwnedDagIns(&entity.DagInstance{
+			ID:        dagIns.ID,
+			Status:    dagIns.Status,
+			Reason:    dagIns.Reason,
+			StartedAt: dagIns.StartedAt,
 		}, "Reason"); err != nil {
 			return err
 		}
@@ -398,55 +881,136 @@ func (p *DefParser) parseScheduleDagIns(dagIns *entity.DagInstance) error {
 }
 
 func (p *DefParser) parseCmd(dagIns *entity.DagInstance) (err error) {
-	if dagIns.Cmd != nil {
-		switch dagIns.Cmd.Name {
-		case entity.CommandNameRetry:
-			hasAnyTaskRetried := false
-			defer func() {
-				if err == nil && hasAnyTaskRetried {
-					p.InitialDagIns(dagIns)
-				}
-			}()
+	if dagIns.Cmd == nil {
+		return nil
+	}
 
-			taskIns, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
-				IDs:    dagIns.Cmd.TargetTaskInsIDs,
-				Status: []entity.TaskInstanceStatus{entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusCanceled},
-			})
-			if err != nil {
-				return err
+	cmd := dagIns.Cmd
+	// cmdErr means the command can not be executed in current status of dag instance,
+	// it is saved as the result of command, and the command will not be executed again
+	var cmdErr error
+	switch cmd.Name {
+	case entity.CommandNameRetry:
+		hasAnyTaskRetried := false
+		defer func() {
+			if err == nil && hasAnyTaskRetried {
+				p.InitialDagIns(dagIns)
 			}
+		}()
 
-			for _, t := range taskIns {
-				if t.Status != entity.TaskInstanceStatusFailed &&
-					t.Status != entity.TaskInstanceStatusCanceled {
-					continue
-				}
+		taskIns, err := GetStore().ListTaskInstance(&ListTaskInstanceInput{
+			IDs:    cmd.TargetTaskInsIDs,
+			Status: []entity.TaskInstanceStatus{entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusCanceled},
+		})
+		if err != nil {
+			return err
+		}
 
-				t.Status = entity.TaskInstanceStatusRetrying
-				t.Reason = ""
-				if err := GetStore().UpdateTaskIns(t); err != nil {
-					return err
-				}
-				hasAnyTaskRetried = true
+		for _, t := range taskIns {
+			if t.Status != entity.TaskInstanceStatusFailed &&
+				t.Status != entity.TaskInstanceStatusCanceled {
+				continue
 			}
-			dagIns.Run()
-		case entity.CommandNameCancel:
-			if err := GetExecutor().CancelTaskIns(dagIns.Cmd.TargetTaskInsIDs); err != nil {
+
+			t.Status = entity.TaskInstanceStatusRetrying
+			t.Reason = ""
+			if err := GetStore().UpdateTaskIns(t); err != nil {
 				return err
 			}
+			hasAnyTaskRetried = true
+		}
+		if !hasAnyTaskRetried {
+			cmdErr = fmt.Errorf("no failed or canceled task instance to retry")
+			break
+		}
+		dagIns.Run()
+	case entity.CommandNameCancel:
+		if dagIns.Status != entity.DagInstanceStatusRunning && dagIns.Status != entity.DagInstanceStatusPaused {
+			cmdErr = fmt.Errorf("dag instance is %s, only running or paused dag instance can cancel tasks", dagIns.Status)
+			break
+		}
+		if err := GetExecutor().CancelTaskIns(cmd.TargetTaskInsIDs); err != nil {
+			return err
 		}
+	case entity.CommandNamePause:
+		// the instance may be completed before command is executed
+		if dagIns.Status != entity.DagInstanceStatusRunning {
+			cmdErr = fmt.Errorf("dag instance is %s, only running dag instance can be paused", dagIns.Status)
+			break
+		}
+		if tree, ok := p.getTaskTree(dagIns.ID); ok {
+			tree.paused.Store(true)
+		}
+		dagIns.MarkPaused()
+	case entity.CommandNameResume:
+		if dagIns.Status != entity.DagInstanceStatusPaused {
+			cmdErr = fmt.Errorf("dag instance is %s, only paused dag instance can be resumed", dagIns.Status)
+			break
+		}
+		if tree, ok := p.getTaskTree(dagIns.ID); ok {
+			tree.paused.Store(false)
+		}
+		defer func() {
+			if err == nil {
+				p.InitialDagIns(dagIns)
+			}
+		}()
+		dagIns.MarkResumed()
+	case entity.CommandNameCancelDagIns, entity.CommandNameTimeoutDagIns:
+		if dagIns.IsCompleted() {
+			cmdErr = fmt.Errorf("dag instance is already %s", dagIns.Status)
+			break
+		}
+		if err := p.cancelDagIns(dagIns); err != nil {
+			return err
+		}
+	case entity.CommandNameRerun:
+		// the instance may be started by other command before command is executed
+		if !dagIns.CanRerun() {
+			cmdErr = fmt.Errorf("dag instance is %s, only completed or blocked dag instance can be rerun", dagIns.Status)
+			break
+		}
+		if rErr := p.resetTasks(dagIns.ID, cmd.TargetTaskInsIDs); rErr != nil {
+			return rErr
+		}
+		defer func() {
+			if err == nil {
+				p.InitialDagIns(dagIns)
+			}
+		}()
+		dagIns.Run()
+	case entity.CommandNameSkip, entity.CommandNameMarkSuccess:
+		if !dagIns.CanForceTasks() {
+			cmdErr = fmt.Errorf("the tasks of %s dag instance can not be forced", dagIns.Status)
+			break
+		}
+		next, fErr := p.forceTasks(dagIns)
+		if fErr != nil {
+			return fErr
+		}
+		// continue after the command is completed, so the status of dag instance will not be overwritten
+		defer func() {
+			if err == nil {
+				next()
+			}
+		}()
+	default:
+		cmdErr = fmt.Errorf("unknown command: %s", cmd.Name)
+	}
 
-		dagIns.Cmd = nil
-		if err := GetStore().PatchDagIns(&entity.DagInstance{
-			ID:     dagIns.ID,
-			Status: dagIns.Status,
-			Cmd:    dagIns.Cmd,
-			Reason: dagIns.Reason,
-		}, "Cmd", "Reason"); err != nil {
+	dagIns.Cmd = nil
+	if cmdErr == nil {
+		if err := patchOwnedDagIns(&entity.DagInstance{
+			ID:        dagIns.ID,
+			Status:    dagIns.Status,
+			Reason:    dagIns.Reason,
+			StartedAt: dagIns.StartedAt,
+		}, "Reason"); err != nil {
 			return err
 		}
 	}
-	return nil
+	cmd.Complete(cmdErr)
+	return GetStore().PatchCommand(cmd)
 }
 
 // Close
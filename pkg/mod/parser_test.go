@@ -0,0 +1,408 @@
+package mod_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/mod"
+	"github.com/weeyp/fastflow/store/cache"
+)
+
+// cancelFailedExecutor can not cancel any task instance
+type cancelFailedExecutor struct{}
+
+func (e *cancelFailedExecutor) Push(dagIns *entity.DagInstance, taskIns *entity.TaskInstance) {}
+
+func (e *cancelFailedExecutor) CancelTaskIns(taskInsIds []string) error {
+	return errors.New("cancel failed")
+}
+
+func TestDefParser_WatchDagInsCmd(t *testing.T) {
+	store := initTestEnv(t, nil)
+	mod.SetExecutor(&cancelFailedExecutor{})
+
+	runningIns := func(worker string) *entity.DagInstance {
+		return createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: worker, Status: entity.DagInstanceStatusRunning})
+	}
+	createCmd := func(dagIns *entity.DagInstance, name entity.CommandName, createdAt int64) *entity.Command {
+		cmd := &entity.Command{
+			DagInsID:         dagIns.ID,
+			Name:             name,
+			TargetTaskInsIDs: []string{"task"},
+			Status:           entity.CommandStatusPending,
+			CreatedAt:        createdAt,
+		}
+		require.NoError(t, store.CreateCommand(cmd))
+		return cmd
+	}
+	// the resume can not be executed before pause, it fails and does not block the pause
+	executed := runningIns("w1")
+	resumeFailed := createCmd(executed, entity.CommandNameResume, 1)
+	paused := createCmd(executed, entity.CommandNamePause, 2)
+	// the commands are executed by other worker
+	otherWorker := runningIns("w2")
+	otherPause := createCmd(otherWorker, entity.CommandNamePause, 1)
+	// the cancel can not be executed now, so the later pause has to wait
+	blocked := runningIns("w1")
+	cancelErr := createCmd(blocked, entity.CommandNameCancel, 1)
+	blockedPause := createCmd(blocked, entity.CommandNamePause, 2)
+
+	err := mod.NewDefParser(1, 0).WatchDagInsCmd()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cancel failed")
+
+	tests := []struct {
+		caseDesc   string
+		giveCmd    *entity.Command
+		wantStatus entity.CommandStatus
+		wantResult string
+	}{
+		{
+			caseDesc:   "command can not be executed in current status",
+			giveCmd:    resumeFailed,
+			wantStatus: entity.CommandStatusFailed,
+			wantResult: "dag instance is running, only paused dag instance can be resumed",
+		},
+		{caseDesc: "executed after failed command", giveCmd: paused, wantStatus: entity.CommandStatusSuccess},
+		{caseDesc: "other worker", giveCmd: otherPause, wantStatus: entity.CommandStatusPending},
+		{caseDesc: "execute failed", giveCmd: cancelErr, wantStatus: entity.CommandStatusPending},
+		{caseDesc: "wait for previous command", giveCmd: blockedPause, wantStatus: entity.CommandStatusPending},
+	}
+	for _, tc := range tests {
+		cmd, err := store.GetCommand(tc.giveCmd.ID)
+		require.NoError(t, err, tc.caseDesc)
+		assert.Equal(t, tc.wantStatus, cmd.Status, tc.caseDesc)
+		assert.Equal(t, tc.wantResult, cmd.Result, tc.caseDesc)
+	}
+
+	for _, c := range []struct {
+		ins  *entity.DagInstance
+		want entity.DagInstanceStatus
+	}{
+		{executed, entity.DagInstanceStatusPaused},
+		{otherWorker, entity.DagInstanceStatusRunning},
+		{blocked, entity.DagInstanceStatusRunning},
+	} {
+		ins, err := store.GetDagInstance(c.ins.ID)
+		require.NoError(t, err)
+		assert.Equal(t, c.want, ins.Status, c.ins.ID)
+	}
+}
+
+func TestDefParser_QueueLegacyCmd(t *testing.T) {
+	store := initTestEnv(t, nil)
+	mod.SetExecutor(&recordExecutor{})
+	// the command is saved in dag instance by the earlier versions
+	legacy := createDagIns(t, store, &entity.DagInstance{
+		DagID:  "dag",
+		Worker: "w1",
+		Status: entity.DagInstanceStatusRunning,
+		Cmd:    &entity.Command{Name: entity.CommandNamePause},
+	})
+	other := createDagIns(t, store, &entity.DagInstance{
+		DagID:  "dag",
+		Worker: "w2",
+		Status: entity.DagInstanceStatusRunning,
+		Cmd:    &entity.Command{Name: entity.CommandNamePause},
+	})
+
+	require.NoError(t, mod.NewDefParser(1, 0).WatchDagInsCmd())
+
+	ins, err := store.GetDagInstance(legacy.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.DagInstanceStatusPaused, ins.Status)
+	assert.Nil(t, ins.Cmd)
+	cmds, err := store.ListCommand(&mod.ListCommandInput{DagInsID: legacy.ID})
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	assert.Equal(t, entity.CommandStatusSuccess, cmds[0].Status)
+
+	// the command is moved by the worker which the instance is dispatched to
+	ins, err = store.GetDagInstance(other.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, ins.Cmd)
+}
+
+func TestDefParser_WatchDueDagIns(t *testing.T) {
+	store := initTestEnv(t, nil)
+	scheduled := func(at time.Time) *entity.DagInstance {
+		return createDagIns(t, store, &entity.DagInstance{
+			DagID:        "dag",
+			Status:       entity.DagInstanceStatusScheduled,
+			ScheduleTime: at.Unix(),
+		})
+	}
+	due := scheduled(time.Now().Add(-time.Second))
+	notDue := scheduled(time.Now().Add(time.Hour))
+	canceled := scheduled(time.Now().Add(time.Hour))
+	// the scheduled instance is not dispatched, so it is canceled directly
+	require.NoError(t, mod.GetCommander().CancelDagIns(canceled.ID, "not needed"))
+
+	require.NoError(t, mod.NewDefParser(1, 0).WatchDueDagIns())
+
+	tests := []struct {
+		caseDesc   string
+		giveIns    *entity.DagInstance
+		wantStatus entity.DagInstanceStatus
+		wantReason string
+	}{
+		{caseDesc: "due", giveIns: due, wantStatus: entity.DagInstanceStatusInit},
+		{caseDesc: "not due", giveIns: notDue, wantStatus: entity.DagInstanceStatusScheduled},
+		{caseDesc: "canceled before due", giveIns: canceled, wantStatus: entity.DagInstanceStatusCanceled, wantReason: "not needed"},
+	}
+	for _, tc := range tests {
+		ins, err := store.GetDagInstance(tc.giveIns.ID)
+		require.NoError(t, err, tc.caseDesc)
+		assert.Equal(t, tc.wantStatus, ins.Status, tc.caseDesc)
+		assert.Equal(t, tc.wantReason, ins.Reason, tc.caseDesc)
+	}
+}
+
+// staleStore return the dag instances which are not dispatched yet, like they are read before dispatching
+type staleStore struct {
+	*cache.MemCache
+}
+
+func (s *staleStore) GetDagInstance(dagInsId string) (*entity.DagInstance, error) {
+	ins, err := s.MemCache.GetDagInstance(dagInsId)
+	if err != nil {
+		return nil, err
+	}
+	stale := *ins
+	stale.Worker = ""
+	return &stale, nil
+}
+
+func TestDefCommander_CancelDispatchedDagIns(t *testing.T) {
+	store := initTestEnv(t, nil)
+	dagIns := createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: "w1", Status: entity.DagInstanceStatusInit})
+	mod.SetStore(&staleStore{MemCache: store})
+
+	// the instance is dispatched after it is read by commander, so the command is left to its worker
+	require.NoError(t, mod.GetCommander().CancelDagIns(dagIns.ID, "not needed"))
+	ins, err := store.GetDagInstance(dagIns.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.DagInstanceStatusInit, ins.Status)
+	cmds, err := store.ListCommand(&mod.ListCommandInput{DagInsID: dagIns.ID})
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	assert.Equal(t, entity.CommandName(entity.CommandNameCancelDagIns), cmds[0].Name)
+}
+
+func TestDefParser_PauseHooks(t *testing.T) {
+	store := initTestEnv(t, nil)
+	mod.SetExecutor(&recordExecutor{})
+	var called []entity.DagInstanceStatus
+	entity.HookDagInstance = entity.DagInstanceLifecycleHook{
+		BeforePause: func(dagIns *entity.DagInstance) {
+			called = append(called, dagIns.Status)
+		},
+	}
+	defer func() {
+		entity.HookDagInstance = entity.DagInstanceLifecycleHook{}
+	}()
+
+	dagIns := createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: "w1", Status: entity.DagInstanceStatusRunning})
+	require.NoError(t, mod.GetCommander().PauseDagIns(dagIns.ID))
+	// the hook is not called until the command is executed
+	assert.Empty(t, called)
+
+	require.NoError(t, mod.NewDefParser(1, 0).WatchDagInsCmd())
+	assert.Equal(t, []entity.DagInstanceStatus{entity.DagInstanceStatusRunning}, called)
+	ins, err := store.GetDagInstance(dagIns.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.DagInstanceStatusPaused, ins.Status)
+}
+
+// recordExecutor record the pushed task instances
+type recordExecutor struct {
+	pushed []string
+}
+
+func (e *recordExecutor) Push(dagIns *entity.DagInstance, taskIns *entity.TaskInstance) {
+	e.pushed = append(e.pushed, taskIns.ID)
+}
+
+func (e *recordExecutor) CancelTaskIns(taskInsIds []string) error {
+	return nil
+}
+
+func TestDefParser_RetryWhenPaused(t *testing.T) {
+	store := initTestEnv(t, nil)
+	exe := &recordExecutor{}
+	mod.SetExecutor(exe)
+
+	dagIns := createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: "w1", Status: entity.DagInstanceStatusPaused})
+	taskIns := &entity.TaskInstance{ID: "task-ins", TaskID: "task", DagInsID: dagIns.ID, Status: entity.TaskInstanceStatusRetrying}
+	require.NoError(t, store.BatchCreatTaskIns([]*entity.TaskInstance{taskIns}))
+
+	p := mod.NewDefParser(1, 0)
+	p.InitialDagIns(dagIns)
+	// the retry time is reached, but the dag instance is paused
+	require.NoError(t, p.ExecuteNext(taskIns))
+	assert.Empty(t, exe.pushed)
+
+	dagIns.Status = entity.DagInstanceStatusRunning
+	p.InitialDagIns(dagIns)
+	assert.Equal(t, []string{taskIns.ID}, exe.pushed)
+	require.NoError(t, p.ExecuteNext(taskIns))
+	assert.Equal(t, []string{taskIns.ID, taskIns.ID}, exe.pushed)
+}
+
+func TestDefParser_RerunResetAttempts(t *testing.T) {
+	store := initTestEnv(t, nil)
+	exe := &recordExecutor{}
+	mod.SetExecutor(exe)
+
+	dagIns := createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: "w1", Status: entity.DagInstanceStatusFailed})
+	taskIns := &entity.TaskInstance{
+		ID:       "task-ins",
+		TaskID:   "task",
+		DagInsID: dagIns.ID,
+		Status:   entity.TaskInstanceStatusFailed,
+		Retry:    &entity.RetryPolicy{MaxAttempts: 3},
+		Attempt:  3,
+		Attempts: []entity.TaskAttempt{{Attempt: 1}, {Attempt: 2}, {Attempt: 3}},
+		RetryAt:  1,
+	}
+	require.NoError(t, store.BatchCreatTaskIns([]*entity.TaskInstance{taskIns}))
+	require.NoError(t, store.CreateCommand(&entity.Command{
+		DagInsID:         dagIns.ID,
+		Name:             entity.CommandNameRerun,
+		TargetTaskInsIDs: []string{taskIns.ID},
+		Status:           entity.CommandStatusPending,
+	}))
+
+	require.NoError(t, mod.NewDefParser(1, 0).WatchDagInsCmd())
+	assert.Equal(t, []string{taskIns.ID}, exe.pushed)
+
+	got, err := store.GetTaskIns(taskIns.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.TaskInstanceStatusInit, got.Status)
+	assert.Equal(t, 0, got.Attempt)
+	assert.Empty(t, got.Attempts)
+	assert.Equal(t, int64(0), got.RetryAt)
+	// the rerun task has the whole retry budget
+	assert.True(t, got.Retry.ShouldRetry(1, errors.New("failed")))
+}
+
+func TestDefParser_UpstreamFailed(t *testing.T) {
+	store := initTestEnv(t, nil)
+	exe := &recordExecutor{}
+	mod.SetExecutor(exe)
+
+	// task1 -> task2
+	dagIns := createDagIns(t, store, &entity.DagInstance{DagID: "dag", Worker: "w1", Status: entity.DagInstanceStatusRunning})
+	task1 := &entity.TaskInstance{ID: "task1-ins", TaskID: "task1", DagInsID: dagIns.ID, Status: entity.TaskInstanceStatusInit}
+	task2 := &entity.TaskInstance{ID: "task2-ins", TaskID: "task2", DagInsID: dagIns.ID, Status: entity.TaskInstanceStatusInit,
+		DependOn: []string{"task1"}}
+	require.NoError(t, store.BatchCreatTaskIns([]*entity.TaskInstance{task1, task2}))
+
+	p := mod.NewDefParser(1, 0)
+	p.InitialDagIns(dagIns)
+	require.Equal(t, []string{task1.ID}, exe.pushed)
+
+	require.NoError(t, store.PatchTaskIns(&entity.TaskInstance{ID: task1.ID, Status: entity.TaskInstanceStatusFailed}))
+	require.NoError(t, p.ExecuteNext(&entity.TaskInstance{ID: task1.ID, DagInsID: dagIns.ID, Status: entity.TaskInstanceStatusFailed}))
+	got, err := store.GetTaskIns(task2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.TaskInstanceStatusUpstreamFailed, got.Status)
+	assert.Equal(t, "trigger rule all_success can never be met", got.Reason)
+	ins, err := store.GetDagInstance(dagIns.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.DagInstanceStatusFailed, ins.Status)
+
+	// the downstream task can be executed again after its upstream task is retried
+	require.NoError(t, store.CreateCommand(&entity.Command{
+		DagInsID:         dagIns.ID,
+		Name:             entity.CommandNameRetry,
+		TargetTaskInsIDs: []string{task1.ID},
+		Status:           entity.CommandStatusPending,
+	}))
+	require.NoError(t, p.WatchDagInsCmd())
+	assert.Equal(t, []string{task1.ID, task1.ID}, exe.pushed)
+	got, err = store.GetTaskIns(task2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.TaskInstanceStatusInit, got.Status)
+	assert.Equal(t, "", got.Reason)
+
+	require.NoError(t, store.PatchTaskIns(&entity.TaskInstance{ID: task1.ID, Status: entity.TaskInstanceStatusSuccess}))
+	require.NoError(t, p.ExecuteNext(&entity.TaskInstance{ID: task1.ID, DagInsID: dagIns.ID, Status: entity.TaskInstanceStatusSuccess}))
+	assert.Equal(t, []string{task1.ID, task1.ID, task2.ID}, exe.pushed)
+}
+
+func TestDefParser_MappedTask(t *testing.T) {
+	store := initTestEnv(t, nil)
+	exe := &recordExecutor{}
+	mod.SetExecutor(exe)
+
+	dagIns := createDagIns(t, store, &entity.DagInstance{
+		DagID:     "dag",
+		Worker:    "w1",
+		Status:    entity.DagInstanceStatusRunning,
+		ShareData: &entity.ShareData{Dict: map[string]string{"files": "a,b,c"}},
+	})
+	parent := &entity.TaskInstance{ID: "process-ins", TaskID: "process", DagInsID: dagIns.ID,
+		Status: entity.TaskInstanceStatusInit, MapOver: "{{.shareData.files}}", MaxParallel: 2}
+	require.NoError(t, store.BatchCreatTaskIns([]*entity.TaskInstance{parent}))
+
+	listMapped := func() map[string]*entity.TaskInstance {
+		tasks, err := store.ListTaskInstance(&mod.ListTaskInstanceInput{DagInsID: dagIns.ID})
+		require.NoError(t, err)
+		ret := map[string]*entity.TaskInstance{}
+		for _, task := range tasks {
+			if task.MapParentID == parent.ID {
+				ret[task.MapItem] = task
+			}
+		}
+		return ret
+	}
+	complete := func(p *mod.DefParser, taskIns *entity.TaskInstance, output map[string]interface{}) {
+		require.NoError(t, store.PatchTaskIns(&entity.TaskInstance{ID: taskIns.ID, Status: entity.TaskInstanceStatusSuccess, Output: output}))
+		require.NoError(t, p.ExecuteNext(&entity.TaskInstance{ID: taskIns.ID, DagInsID: dagIns.ID,
+			MapParentID: parent.ID, Status: entity.TaskInstanceStatusSuccess}))
+	}
+
+	p := mod.NewDefParser(1, 0)
+	p.InitialDagIns(dagIns)
+	mapped := listMapped()
+	require.Len(t, mapped, 3)
+	assert.Equal(t, []string{mapped["a"].ID, mapped["b"].ID}, exe.pushed)
+	// the pushed ones are persisted, so they are not pushed again after a tree is rebuilt
+	assert.Equal(t, entity.TaskInstanceStatusWaiting, mapped["a"].Status)
+	assert.Equal(t, entity.TaskInstanceStatusInit, mapped["c"].Status)
+
+	complete(p, mapped["a"], map[string]interface{}{"file": "a"})
+	assert.Equal(t, []string{mapped["a"].ID, mapped["b"].ID, mapped["c"].ID}, exe.pushed)
+	complete(p, mapped["c"], map[string]interface{}{"file": "c"})
+	complete(p, mapped["b"], nil)
+
+	got, err := store.GetTaskIns(parent.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entity.TaskInstanceStatusSuccess, got.Status)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"file": "a"},
+		nil,
+		map[string]interface{}{"file": "c"},
+	}, got.Output[mod.MappedOutputsKey])
+	ins, err := store.GetDagInstance(dagIns.ID)
+	require.NoError(t, err)
+	require.Equal(t, entity.DagInstanceStatusSuccess, ins.Status)
+
+	// the mapped task instances are expanded again with the latest items when it is rerun
+	ins.ShareData.Dict["files"] = "x"
+	require.NoError(t, store.CreateCommand(&entity.Command{
+		DagInsID:         dagIns.ID,
+		Name:             entity.CommandNameRerun,
+		TargetTaskInsIDs: []string{parent.ID},
+		Status:           entity.CommandStatusPending,
+	}))
+	require.NoError(t, p.WatchDagInsCmd())
+	mapped = listMapped()
+	require.Len(t, mapped, 1)
+	assert.Contains(t, exe.pushed, mapped["x"].ID)
+}
@@ -0,0 +1,107 @@
+package mod
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PoolOption define a named pool, the task instances using it can not run more than its slots at the same time
+type PoolOption struct {
+	Name  string
+	Slots int
+	// Actions use the pool by default, the task can use another pool by its "pool" field
+	Actions []string
+}
+
+// taskPool limits the running task instances, the excess ones wait in order until a slot is released
+type taskPool struct {
+	name    string
+	slots   int
+	running map[string]struct{}
+	waiting []*initPayload
+	lock    sync.Mutex
+}
+
+func newTaskPool(name string, slots int) *taskPool {
+	return &taskPool{
+		name:    name,
+		slots:   slots,
+		running: map[string]struct{}{},
+	}
+}
+
+// acquire take a slot for the task instance, it is queued if there is no free slot
+func (p *taskPool) acquire(payload *initPayload) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.running[payload.taskIns.ID]; ok {
+		return true
+	}
+	if len(p.running) < p.slots {
+		p.running[payload.taskIns.ID] = struct{}{}
+		return true
+	}
+	for _, w := range p.waiting {
+		if w.taskIns.ID == payload.taskIns.ID {
+			return false
+		}
+	}
+	p.waiting = append(p.waiting, payload)
+	return false
+}
+
+// release free the slot of the task instance, and return the next waiting one which takes the slot
+func (p *taskPool) release(taskInsId string) *initPayload {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.running, taskInsId)
+	if len(p.waiting) == 0 || len(p.running) >= p.slots {
+		return nil
+	}
+	next := p.waiting[0]
+	p.waiting = p.waiting[1:]
+	p.running[next.taskIns.ID] = struct{}{}
+	return next
+}
+
+// remove the task instance from waiting queue, return nil if it is not waiting
+func (p *taskPool) remove(taskInsId string) *initPayload {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, w := range p.waiting {
+		if w.taskIns.ID == taskInsId {
+			p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+			return w
+		}
+	}
+	return nil
+}
+
+// buildTaskPools check the options and build pools, and the map from action to its default pool
+func buildTaskPools(opts []PoolOption) (map[string]*taskPool, map[string]string, error) {
+	pools := map[string]*taskPool{}
+	actionPools := map[string]string{}
+	for _, opt := range opts {
+		if opt.Name == "" {
+			return nil, nil, fmt.Errorf("pool name cannot be empty")
+		}
+		if opt.Slots <= 0 {
+			return nil, nil, fmt.Errorf("pool[%s] slots must be greater than 0", opt.Name)
+		}
+		if _, ok := pools[opt.Name]; ok {
+			return nil, nil, fmt.Errorf("pool[%s] is duplicated", opt.Name)
+		}
+		pools[opt.Name] = newTaskPool(opt.Name, opt.Slots)
+
+		for _, act := range opt.Actions {
+			if pool, ok := actionPools[act]; ok {
+				return nil, nil, fmt.Errorf("action[%s] already uses pool[%s]", act, pool)
+			}
+			actionPools[act] = opt.Name
+		}
+	}
+	return pools, actionPools, nil
+}
@@ -0,0 +1,85 @@
+package mod
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+func TestTaskPool(t *testing.T) {
+	payload := func(id string) *initPayload {
+		return &initPayload{taskIns: &entity.TaskInstance{ID: id}}
+	}
+	pool := newTaskPool("db", 1)
+
+	assert.True(t, pool.acquire(payload("t1")))
+	assert.True(t, pool.acquire(payload("t1")))
+	assert.False(t, pool.acquire(payload("t2")))
+	assert.False(t, pool.acquire(payload("t2")))
+	assert.False(t, pool.acquire(payload("t3")))
+	assert.Len(t, pool.waiting, 2)
+
+	assert.Equal(t, "t3", pool.remove("t3").taskIns.ID)
+	assert.Nil(t, pool.remove("t3"))
+
+	next := pool.release("t1")
+	assert.Equal(t, "t2", next.taskIns.ID)
+	assert.Equal(t, map[string]struct{}{"t2": {}}, pool.running)
+	assert.Nil(t, pool.release("t2"))
+	assert.Empty(t, pool.running)
+}
+
+func TestBuildTaskPools(t *testing.T) {
+	tests := []struct {
+		caseDesc        string
+		giveOpts        []PoolOption
+		wantActionPools map[string]string
+		wantErr         error
+	}{
+		{
+			caseDesc: "normal",
+			giveOpts: []PoolOption{
+				{Name: "db", Slots: 5, Actions: []string{"query", "update"}},
+				{Name: "cpu", Slots: 2},
+			},
+			wantActionPools: map[string]string{"query": "db", "update": "db"},
+		},
+		{
+			caseDesc: "empty name",
+			giveOpts: []PoolOption{{Slots: 5}},
+			wantErr:  fmt.Errorf("pool name cannot be empty"),
+		},
+		{
+			caseDesc: "invalid slots",
+			giveOpts: []PoolOption{{Name: "db"}},
+			wantErr:  fmt.Errorf("pool[db] slots must be greater than 0"),
+		},
+		{
+			caseDesc: "duplicated pool",
+			giveOpts: []PoolOption{{Name: "db", Slots: 5}, {Name: "db", Slots: 2}},
+			wantErr:  fmt.Errorf("pool[db] is duplicated"),
+		},
+		{
+			caseDesc: "action in multiple pools",
+			giveOpts: []PoolOption{
+				{Name: "db", Slots: 5, Actions: []string{"query"}},
+				{Name: "cpu", Slots: 2, Actions: []string{"query"}},
+			},
+			wantErr: fmt.Errorf("action[query] already uses pool[db]"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			pools, actionPools, err := buildTaskPools(tc.giveOpts)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Len(t, pools, len(tc.giveOpts))
+			assert.Equal(t, tc.wantActionPools, actionPools)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package mod
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// taskQueue is the queue of task instances waiting for executor workers, higher priority ones are dequeued first,
+// and a task instance gains one priority every aging interval it waits, so low priority ones will not starve.
+// It holds at most size task instances, push blocks when it is full, so the senders slow down with workers
+type taskQueue struct {
+	items    taskHeap
+	size     int
+	aging    time.Duration
+	seq      uint64
+	closed   bool
+	now      func() time.Time
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	lock     sync.Mutex
+}
+
+func newTaskQueue(aging time.Duration, size int) *taskQueue {
+	if size < 1 {
+		size = 1
+	}
+	q := &taskQueue{
+		size:  size,
+		aging: aging,
+		now:   time.Now,
+	}
+	q.notEmpty = sync.NewCond(&q.lock)
+	q.notFull = sync.NewCond(&q.lock)
+	return q
+}
+
+// push the task instance to queue, it blocks until the queue is not full,
+// and it is ignored after the queue is closed
+func (q *taskQueue) push(payload *initPayload, priority int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.items) >= q.size && !q.closed {
+		q.notFull.Wait()
+	}
+	if q.closed {
+		return
+	}
+	q.seq++
+	// aging does not change the order of queued task instances,
+	// so a priority is just worth an aging interval of waiting time
+	heap.Push(&q.items, &queueItem{
+		payload: payload,
+		key:     q.now().UnixNano() - int64(priority)*q.aging.Nanoseconds(),
+		seq:     q.seq,
+	})
+	q.notEmpty.Signal()
+}
+
+// pop block until there is a task instance, return false when the queue is closed
+func (q *taskQueue) pop() (*initPayload, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	q.notFull.Signal()
+	return heap.Pop(&q.items).(*queueItem).payload, true
+}
+
+// close the queue and wake up all waiting workers and senders, the queued task instances are dropped,
+// they will be executed again when their dag instances are recovered
+func (q *taskQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.items = nil
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+type queueItem struct {
+	payload *initPayload
+	key     int64
+	seq     uint64
+}
+
+// taskHeap implements heap.Interface, the item with the smallest key is at the top
+type taskHeap []*queueItem
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x interface{}) {
+	*h = append(*h, x.(*queueItem))
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
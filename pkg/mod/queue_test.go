@@ -0,0 +1,100 @@
+package mod
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+func TestTaskQueue(t *testing.T) {
+	type push struct {
+		id       string
+		priority int
+		waited   time.Duration
+	}
+	tests := []struct {
+		caseDesc   string
+		givePushes []push
+		wantIds    []string
+	}{
+		{
+			caseDesc:   "same priority",
+			givePushes: []push{{id: "t1"}, {id: "t2"}, {id: "t3"}},
+			wantIds:    []string{"t1", "t2", "t3"},
+		},
+		{
+			caseDesc:   "higher priority first",
+			givePushes: []push{{id: "t1"}, {id: "t2", priority: 2}, {id: "t3", priority: 1}, {id: "t4", priority: -1}},
+			wantIds:    []string{"t2", "t3", "t1", "t4"},
+		},
+		{
+			caseDesc:   "aging",
+			givePushes: []push{{id: "t1", waited: 30 * time.Second}, {id: "t2", priority: 2}, {id: "t3", priority: 5}},
+			wantIds:    []string{"t3", "t1", "t2"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			now := time.Now()
+			q := newTaskQueue(10*time.Second, len(tc.givePushes))
+			for _, p := range tc.givePushes {
+				q.now = func() time.Time { return now.Add(-p.waited) }
+				q.push(&initPayload{taskIns: &entity.TaskInstance{ID: p.id}}, p.priority)
+			}
+
+			var ids []string
+			for range tc.givePushes {
+				payload, ok := q.pop()
+				assert.True(t, ok)
+				ids = append(ids, payload.taskIns.ID)
+			}
+			assert.Equal(t, tc.wantIds, ids)
+
+			q.push(&initPayload{taskIns: &entity.TaskInstance{ID: "dropped"}}, 0)
+			q.close()
+			_, ok := q.pop()
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestTaskQueue_Full(t *testing.T) {
+	q := newTaskQueue(10*time.Second, 1)
+	q.push(&initPayload{taskIns: &entity.TaskInstance{ID: "t1"}}, 0)
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push(&initPayload{taskIns: &entity.TaskInstance{ID: "t2"}}, 0)
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push should block when queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	payload, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, "t1", payload.taskIns.ID)
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push should continue after pop")
+	}
+
+	// the blocked sender is waked up when queue is closed
+	closed := make(chan struct{})
+	go func() {
+		q.push(&initPayload{taskIns: &entity.TaskInstance{ID: "t3"}}, 0)
+		close(closed)
+	}()
+	q.close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("push should return after queue is closed")
+	}
+}
@@ -0,0 +1,67 @@
+package mod
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weeyp/fastflow/pkg/entity/run"
+)
+
+func TestRateLimiter_Reserve(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		caseDesc  string
+		giveAfter time.Duration
+		wantDelay time.Duration
+	}{
+		{caseDesc: "first burst token", wantDelay: 0},
+		{caseDesc: "second burst token", wantDelay: 0},
+		{caseDesc: "wait next token", wantDelay: 500 * time.Millisecond},
+		{caseDesc: "reserve after reserved token", wantDelay: time.Second},
+		{caseDesc: "tokens refilled", giveAfter: 10 * time.Second, wantDelay: 0},
+		{caseDesc: "refilled tokens no more than burst", giveAfter: 10 * time.Second, wantDelay: 0},
+		{caseDesc: "burst exhausted", giveAfter: 10 * time.Second, wantDelay: 500 * time.Millisecond},
+		{caseDesc: "clock goes back", giveAfter: 5 * time.Second, wantDelay: time.Second},
+	}
+
+	limiter := newRateLimiter(2, 2)
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			assert.Equal(t, tc.wantDelay, limiter.reserve(now.Add(tc.giveAfter)))
+		})
+	}
+}
+
+// limitedAction is rate limited by its rate
+type limitedAction struct {
+	rate float64
+}
+
+func (a *limitedAction) Name() string {
+	return "limited"
+}
+
+func (a *limitedAction) Run(ctx run.ExecuteContext, params interface{}) error {
+	return nil
+}
+
+func (a *limitedAction) RateLimit() (float64, int) {
+	return a.rate, 1
+}
+
+func TestDefExecutor_GetRateLimiter(t *testing.T) {
+	defer delete(ActionMap, "limited")
+	e := NewDefExecutor(time.Second, 1)
+
+	// the action is not registered yet
+	assert.Nil(t, e.getRateLimiter("limited"))
+
+	ActionMap["limited"] = &limitedAction{}
+	assert.Nil(t, e.getRateLimiter("limited"))
+
+	ActionMap["limited"] = &limitedAction{rate: 1}
+	limiter := e.getRateLimiter("limited")
+	assert.NotNil(t, limiter)
+	assert.Same(t, limiter, e.getRateLimiter("limited"))
+}
@@ -0,0 +1,235 @@
+package mod
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/log"
+	"github.com/weeyp/fastflow/pkg/utils/cron"
+)
+
+// DefScheduler is default scheduler, it watches dags which has cron expression
+// and creates dag instance when the schedule time is reached
+type DefScheduler struct {
+	entries  map[string]*scheduleEntry // map[dagId]*scheduleEntry
+	interval time.Duration             // interval of checking schedules
+	now      func() time.Time          // clock of scheduler, it is replaced in testing
+
+	closeCh chan struct{}  // close channel
+	wg      sync.WaitGroup // wait group
+	once    sync.Once      // close once
+}
+
+type scheduleEntry struct {
+	cron     string
+	catchUp  entity.CatchUpPolicy
+	schedule *cron.Schedule
+	next     time.Time
+}
+
+// NewDefScheduler create a default scheduler
+func NewDefScheduler() *DefScheduler {
+	return &DefScheduler{
+		entries:  map[string]*scheduleEntry{},
+		interval: time.Second,
+		now:      time.Now,
+		closeCh:  make(chan struct{}),
+	}
+}
+
+// Init init scheduler
+func (s *DefScheduler) Init() {
+	s.wg.Add(1)
+	go s.watch()
+}
+
+func (s *DefScheduler) watch() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+// tick sync the dags and fire the schedules which are reached
+func (s *DefScheduler) tick() {
+	now := s.now()
+	if err := s.syncDags(now); err != nil {
+		s.handleErr(err)
+	}
+	s.fire(now)
+}
+
+// syncDags make entries consistent with the dags in store,
+// so the changes of dag(such as created, updated or stopped) will be reflected
+func (s *DefScheduler) syncDags(now time.Time) error {
+	dags, err := GetStore().ListDag(&ListDagInput{
+		Status: []entity.DagStatus{entity.DagStatusNormal},
+	})
+	if err != nil {
+		return fmt.Errorf("list dags failed: %w", err)
+	}
+
+	existed := map[string]struct{}{}
+	for _, dag := range dags {
+		if dag.Cron == "" {
+			continue
+		}
+		existed[dag.ID] = struct{}{}
+
+		entry, ok := s.entries[dag.ID]
+		if ok && entry.cron == dag.Cron {
+			entry.catchUp = dag.CatchUp
+			continue
+		}
+		schedule, err := cron.Parse(dag.Cron)
+		if err != nil {
+			log.Errorf("dag[%s] has an invalid cron expression: %s", dag.ID, err)
+			delete(s.entries, dag.ID)
+			continue
+		}
+		entry = &scheduleEntry{
+			cron:     dag.Cron,
+			catchUp:  dag.CatchUp,
+			schedule: schedule,
+			next:     schedule.Next(now),
+		}
+		s.entries[dag.ID] = entry
+
+		// only the entry which is newly watched need to catch up,
+		// a changed cron expression should not trigger the schedules of old one
+		if !ok {
+			if err := s.catchUp(dag.ID, entry, now); err != nil {
+				s.handleErr(fmt.Errorf("dag[%s] catch up failed: %w", dag.ID, err))
+			}
+		}
+	}
+
+	for dagId := range s.entries {
+		if _, ok := existed[dagId]; !ok {
+			delete(s.entries, dagId)
+		}
+	}
+	return nil
+}
+
+// catchUp run the schedules which are missed since the latest cron dag instance
+func (s *DefScheduler) catchUp(dagId string, entry *scheduleEntry, now time.Time) error {
+	if entry.catchUp == "" || entry.catchUp == entity.CatchUpPolicySkip {
+		return nil
+	}
+
+	dagIns, err := GetStore().ListDagInstance(&ListDagInstanceInput{
+		DagID:   dagId,
+		Trigger: entity.TriggerCron,
+	})
+	if err != nil {
+		return err
+	}
+	var latest int64
+	for i := range dagIns {
+		if dagIns[i].ScheduleTime > latest {
+			latest = dagIns[i].ScheduleTime
+		}
+	}
+	// never be scheduled, so nothing is missed
+	if latest == 0 {
+		return nil
+	}
+
+	for _, t := range missedScheduleTimes(entry.schedule, entry.catchUp, time.Unix(latest, 0), now) {
+		if err := s.runDag(dagId, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DefScheduler) fire(now time.Time) {
+	for dagId, entry := range s.entries {
+		if entry.next.IsZero() || entry.next.After(now) {
+			continue
+		}
+
+		if err := s.runDag(dagId, entry.next); err != nil {
+			s.handleErr(fmt.Errorf("run cron dag[%s] failed: %w", dagId, err))
+		}
+		entry.next = entry.schedule.Next(now)
+	}
+}
+
+func (s *DefScheduler) runDag(dagId string, scheduleTime time.Time) error {
+	dag, err := GetStore().GetDag(dagId)
+	if err != nil {
+		return err
+	}
+
+	dagIns, err := dag.RunWithScheduleTime(entity.TriggerCron, nil, scheduleTime)
+	if err != nil {
+		return err
+	}
+	return GetStore().CreateDagIns(dagIns)
+}
+
+// MaxCatchUpInstances is the max number of dag instances created by catching up once,
+// the older missed schedules are dropped after a long outage
+const MaxCatchUpInstances = 100
+
+// missedScheduleTimes return the schedule times in (latest, now] according to the catch-up policy
+func missedScheduleTimes(schedule *cron.Schedule, policy entity.CatchUpPolicy, latest, now time.Time) []time.Time {
+	limit := 0
+	switch policy {
+	case entity.CatchUpPolicyAll:
+		limit = MaxCatchUpInstances
+	case entity.CatchUpPolicyLatest:
+		limit = 1
+	default:
+		return nil
+	}
+
+	// only keep the latest ones, the outage may be long
+	var missed []time.Time
+	count := 0
+	for t := schedule.Next(latest); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		count++
+		missed = append(missed, t)
+		if len(missed) > limit {
+			missed = missed[1:]
+		}
+	}
+	if policy == entity.CatchUpPolicyAll && count > limit {
+		log.Warnf("%d schedules are missed, only the latest %d of them will be run", count, limit)
+	}
+	return missed
+}
+
+// scheduleTimesBetween return the schedule times in (start, end]
+func scheduleTimesBetween(schedule *cron.Schedule, start, end time.Time) (ret []time.Time) {
+	for t := schedule.Next(start); !t.IsZero() && !t.After(end); t = schedule.Next(t) {
+		ret = append(ret, t)
+	}
+	return
+}
+
+// Close scheduler
+func (s *DefScheduler) Close() {
+	s.once.Do(func() {
+		close(s.closeCh)
+		s.wg.Wait()
+	})
+}
+
+func (s *DefScheduler) handleErr(err error) {
+	log.Error("scheduler get some error",
+		"module", "scheduler",
+		"err", err)
+}
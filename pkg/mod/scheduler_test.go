@@ -0,0 +1,148 @@
+package mod_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/mod"
+	"github.com/weeyp/fastflow/pkg/utils/cron"
+	"github.com/weeyp/fastflow/store/cache"
+)
+
+func TestMissedScheduleTimes(t *testing.T) {
+	latest := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := time.Date(2023, 1, 1, 13, 30, 0, 0, time.UTC)
+	schedule := cron.MustParse("@hourly")
+
+	tests := []struct {
+		givePolicy entity.CatchUpPolicy
+		wantTimes  []time.Time
+	}{
+		{
+			givePolicy: entity.CatchUpPolicySkip,
+		},
+		{
+			givePolicy: "",
+		},
+		{
+			givePolicy: entity.CatchUpPolicyLatest,
+			wantTimes: []time.Time{
+				time.Date(2023, 1, 1, 13, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			givePolicy: entity.CatchUpPolicyAll,
+			wantTimes: []time.Time{
+				time.Date(2023, 1, 1, 11, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 13, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		ret := mod.MissedScheduleTimes(schedule, tc.givePolicy, latest, now)
+		assert.Equal(t, tc.wantTimes, ret, string(tc.givePolicy))
+	}
+}
+
+func TestMissedScheduleTimes_Limited(t *testing.T) {
+	latest := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := latest.Add(24 * time.Hour)
+
+	ret := mod.MissedScheduleTimes(cron.MustParse("* * * * *"), entity.CatchUpPolicyAll, latest, now)
+	require.Len(t, ret, mod.MaxCatchUpInstances)
+	assert.Equal(t, now.Add(-(mod.MaxCatchUpInstances-1)*time.Minute), ret[0])
+	assert.Equal(t, now, ret[len(ret)-1])
+}
+
+// fakeClock only moves when it is set
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestDefScheduler_Tick(t *testing.T) {
+	store := cache.NewMemCache()
+	mod.SetStore(store)
+	dag := &entity.Dag{
+		ID:     "cron-dag",
+		Status: entity.DagStatusNormal,
+		Cron:   "*/5 * * * *",
+		Tasks:  []entity.Task{{ID: "task", ActionName: "action"}},
+	}
+	require.NoError(t, store.CreateDag(dag))
+
+	scheduleTimes := func() (ret []time.Time) {
+		dagIns, err := store.ListDagInstance(&mod.ListDagInstanceInput{DagID: dag.ID, Trigger: entity.TriggerCron})
+		require.NoError(t, err)
+		for _, ins := range dagIns {
+			ret = append(ret, time.Unix(ins.ScheduleTime, 0).UTC())
+		}
+		sort.Slice(ret, func(i, j int) bool {
+			return ret[i].Before(ret[j])
+		})
+		return
+	}
+	at := func(hour, min int) time.Time {
+		return time.Date(2023, 1, 1, hour, min, 0, 0, time.UTC)
+	}
+
+	clock := &fakeClock{now: at(10, 2)}
+	s := mod.NewDefScheduler()
+	s.SetNow(clock.Now)
+	tests := []struct {
+		caseDesc  string
+		giveNow   time.Time
+		giveDag   func()
+		wantTimes []time.Time
+	}{
+		{caseDesc: "not reached", giveNow: at(10, 2)},
+		{caseDesc: "reached", giveNow: at(10, 5), wantTimes: []time.Time{at(10, 5)}},
+		{caseDesc: "fired only once", giveNow: at(10, 6), wantTimes: []time.Time{at(10, 5)}},
+		{caseDesc: "next schedule", giveNow: at(10, 11), wantTimes: []time.Time{at(10, 5), at(10, 10)}},
+		{
+			caseDesc: "cron is changed",
+			giveNow:  at(10, 16),
+			giveDag: func() {
+				dag.Cron = "@hourly"
+			},
+			wantTimes: []time.Time{at(10, 5), at(10, 10)},
+		},
+		{caseDesc: "changed cron is reached", giveNow: at(11, 0), wantTimes: []time.Time{at(10, 5), at(10, 10), at(11, 0)}},
+		{
+			caseDesc: "dag is stopped",
+			giveNow:  at(12, 0),
+			giveDag: func() {
+				dag.Status = entity.DagStatusStopped
+			},
+			wantTimes: []time.Time{at(10, 5), at(10, 10), at(11, 0)},
+		},
+	}
+	for _, tc := range tests {
+		if tc.giveDag != nil {
+			tc.giveDag()
+			require.NoError(t, store.UpdateDag(dag), tc.caseDesc)
+		}
+		clock.now = tc.giveNow
+		s.Tick()
+		assert.Equal(t, tc.wantTimes, scheduleTimes(), tc.caseDesc)
+	}
+
+	// the scheduler of new leader catches up the latest missed schedule
+	dag.Status = entity.DagStatusNormal
+	dag.CatchUp = entity.CatchUpPolicyLatest
+	require.NoError(t, store.UpdateDag(dag))
+	clock.now = at(15, 30)
+	s = mod.NewDefScheduler()
+	s.SetNow(clock.Now)
+	s.Tick()
+	assert.Equal(t, []time.Time{at(10, 5), at(10, 10), at(11, 0), at(15, 0)}, scheduleTimes())
+}
@@ -0,0 +1,71 @@
+package mod
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/entity/run"
+	"github.com/weeyp/fastflow/pkg/utils/data"
+)
+
+// ErrSubDagCycle means a dag runs itself as a sub dag, directly or through other dags
+var ErrSubDagCycle = errors.New("sub dag cycle")
+
+// CheckSubDagCycle return ErrSubDagCycle if the dag runs itself as a sub dag, directly or through other dags.
+// The referenced dags are read from store, and the ones which are not created yet are ignored,
+// the store should call it in CreateDag and UpdateDag after Dag.Validate
+func CheckSubDagCycle(dag *entity.Dag) error {
+	return checkSubDagCycle(dag, []string{dag.ID}, map[string]bool{})
+}
+
+// checkSubDagCycle walk the sub dags in depth first, path is the dags from the checked one to current one,
+// checked records the dags whose sub dags have no cycle
+func checkSubDagCycle(dag *entity.Dag, path []string, checked map[string]bool) error {
+	for _, id := range subDagIDs(dag) {
+		for i := range path {
+			if path[i] == id {
+				return fmt.Errorf("%w: %s -> %s", ErrSubDagCycle, strings.Join(path[i:], " -> "), id)
+			}
+		}
+		if checked[id] {
+			continue
+		}
+
+		sub, err := GetStore().GetDag(id)
+		if errors.Is(err, data.ErrDataNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("get sub dag[%s] failed: %w", id, err)
+		}
+		if err := checkSubDagCycle(sub, append(path[:len(path):len(path)], id), checked); err != nil {
+			return err
+		}
+		checked[id] = true
+	}
+	return nil
+}
+
+// subDagIDs return the ids of dags run by the tasks of dag
+func subDagIDs(dag *entity.Dag) (ids []string) {
+	for _, task := range dag.Tasks {
+		act, ok := ActionMap[task.ActionName].(run.SubDagAction)
+		if !ok {
+			continue
+		}
+
+		var params interface{}
+		if paramAct, ok := act.(run.ParameterAction); ok && task.Params != nil {
+			params = paramAct.ParameterNew()
+			if params != nil {
+				if err := weakDecode(task.Params, params); err != nil {
+					continue
+				}
+			}
+		}
+		ids = append(ids, act.SubDagIDs(params)...)
+	}
+	return
+}
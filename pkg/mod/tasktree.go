@@ -3,8 +3,11 @@ package mod
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/utils"
 )
 
 const (
@@ -17,6 +20,7 @@ type TaskInfoGetter interface {
 	GetID() string
 	GetGraphID() string
 	GetStatus() entity.TaskInstanceStatus
+	GetTriggerRule() entity.TriggerRule
 }
 
 // MapTaskInsToGetter map task instance to getter
@@ -100,25 +104,61 @@ func buildGraphNodeMap(tasks []TaskInfoGetter) (map[string]*TaskNode, error) {
 type TaskTree struct {
 	DagIns *entity.DagInstance
 	Root   *TaskNode
+
+	// paused means the dag instance is paused, so no new task should be pushed
+	paused atomic.Bool
+	// canceled means the dag instance is canceled, the tree is kept until the running tasks are completed
+	canceled atomic.Bool
+	// forcedTasks record the running task instances which are forced by command, map[string]*entity.TaskInstance,
+	// they are forced to the recorded status when they are completed
+	forcedTasks sync.Map
 }
 
 // NewTaskNodeFromGetter new task node from getter
 func NewTaskNodeFromGetter(instance TaskInfoGetter) *TaskNode {
 	return &TaskNode{
-		TaskInsID: instance.GetID(),
-		Status:    instance.GetStatus(),
+		TaskInsID:   instance.GetID(),
+		TaskID:      instance.GetGraphID(),
+		Status:      instance.GetStatus(),
+		TriggerRule: instance.GetTriggerRule(),
 	}
 }
 
 // TaskNode task node
 type TaskNode struct {
-	TaskInsID string
-	Status    entity.TaskInstanceStatus
+	TaskInsID   string
+	TaskID      string
+	Status      entity.TaskInstanceStatus
+	TriggerRule entity.TriggerRule
 
 	children []*TaskNode
 	parents  []*TaskNode
 }
 
+// triggerState is the state of task's trigger rule
+type triggerState int
+
+const (
+	// triggerStateWaiting means some upstream tasks are not completed, the rule can not be decided yet
+	triggerStateWaiting triggerState = iota
+	// triggerStateReady means the rule is met, task can be executed
+	triggerStateReady
+	// triggerStateUnreachable means the rule can never be met, task will not be executed
+	triggerStateUnreachable
+)
+
+// upstreamResult is the result of a task seen by its downstream tasks
+type upstreamResult int
+
+const (
+	upstreamResultPending upstreamResult = iota
+	upstreamResultSucceeded
+	upstreamResultFailed
+	// upstreamResultUnreachable means the task will never be executed,
+	// it is treated as failed by all trigger rules except none_failed
+	upstreamResultUnreachable
+)
+
 type TreeStatus string
 
 const (
@@ -158,6 +198,10 @@ func bfsCheckCycle(waitQueue []*TaskNode, visited map[string]struct{}, incomplet
 
 	for i := 0; i < queueLen; i++ {
 		cur := waitQueue[i]
+		// the node is appended by each of its parents, only the first completed one is handled
+		if _, ok := visited[cur.TaskInsID]; ok {
+			continue
+		}
 		if !isParentCompleted(cur) {
 			incomplete[cur.TaskInsID] = cur
 			continue
@@ -175,38 +219,58 @@ func bfsCheckCycle(waitQueue []*TaskNode, visited map[string]struct{}, incomplet
 
 // ComputeStatus compute status
 func (t *TaskNode) ComputeStatus() (status TreeStatus, srcTaskInsId string) {
+	var blockedTaskInsId, failedTaskInsId string
+	states := triggerStates{}
 	walkNode(t, func(node *TaskNode) bool {
 		switch node.Status {
 		case entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusCanceled:
-			status = TreeStatusFailed
-			srcTaskInsId = node.TaskInsID
+			if failedTaskInsId == "" {
+				failedTaskInsId = node.TaskInsID
+			}
 			return true
 		case entity.TaskInstanceStatusBlocked:
-			status = TreeStatusBlocked
-			srcTaskInsId = node.TaskInsID
+			if blockedTaskInsId == "" {
+				blockedTaskInsId = node.TaskInsID
+			}
 			return true
 		case entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSkipped:
 			return true
-		default:
-			status = TreeStatusRunning
-			srcTaskInsId = node.TaskInsID
-			return false
+		case entity.TaskInstanceStatusInit, entity.TaskInstanceStatusUpstreamFailed:
+			// the task which is waiting for upstream tasks is decided by them,
+			// and the task whose trigger rule can never be met will not be executed
+			if states.of(node) != triggerStateReady {
+				return true
+			}
 		}
-	}, false)
-	if srcTaskInsId != "" {
+		status = TreeStatusRunning
+		srcTaskInsId = node.TaskInsID
+		return false
+	})
+	switch {
+	case status == TreeStatusRunning:
 		return
+	case blockedTaskInsId != "":
+		return TreeStatusBlocked, blockedTaskInsId
+	case failedTaskInsId != "":
+		return TreeStatusFailed, failedTaskInsId
 	}
 	return TreeStatusSuccess, ""
 }
 
-func walkNode(root *TaskNode, walkFunc func(node *TaskNode) bool, walkChildrenIgnoreStatus bool) {
-	dfsWalk(root, walkFunc, walkChildrenIgnoreStatus)
+// walkNode walk all nodes once, it stops when walkFunc return false
+func walkNode(root *TaskNode, walkFunc func(node *TaskNode) bool) {
+	dfsWalk(root, walkFunc, map[*TaskNode]struct{}{})
 }
 
 func dfsWalk(
 	root *TaskNode,
 	walkFunc func(node *TaskNode) bool,
-	walkChildrenIgnoreStatus bool) bool {
+	visited map[*TaskNode]struct{}) bool {
+
+	if _, ok := visited[root]; ok {
+		return true
+	}
+	visited[root] = struct{}{}
 
 	if root.TaskInsID != virtualTaskRootID {
 		if !walkFunc(root) {
@@ -214,23 +278,30 @@ func dfsWalk(
 		}
 	}
 
-	// we cannot execute children, but should execute brother nodes
-	if !walkChildrenIgnoreStatus && !root.CanExecuteChild() {
-		return true
-	}
 	for _, c := range root.children {
-		// if children's parent is not just root, we must check it
-		if len(c.parents) > 1 && !c.CanBeExecuted() {
-			continue
-		}
-
-		if !dfsWalk(c, walkFunc, walkChildrenIgnoreStatus) {
+		if !dfsWalk(c, walkFunc, visited) {
 			return false
 		}
 	}
 	return true
 }
 
+// Descendants return the id of task instance and all of its descendants
+func (t *TaskNode) Descendants(taskInsId string) (ids []string, find bool) {
+	walkNode(t, func(node *TaskNode) bool {
+		if node.TaskInsID != taskInsId {
+			return true
+		}
+		find = true
+		walkNode(node, func(n *TaskNode) bool {
+			ids = append(ids, n.TaskInsID)
+			return true
+		})
+		return false
+	})
+	return
+}
+
 // AppendChild append child
 func (t *TaskNode) AppendChild(task *TaskNode) {
 	t.children = append(t.children, task)
@@ -241,33 +312,141 @@ func (t *TaskNode) AppendParent(task *TaskNode) {
 	t.parents = append(t.parents, task)
 }
 
-// CanExecuteChild check whether task could execute child
-func (t *TaskNode) CanExecuteChild() bool {
-	return t.Status == entity.TaskInstanceStatusSuccess || t.Status == entity.TaskInstanceStatusSkipped
+// CanBeExecuted check whether the trigger rule of task is met
+func (t *TaskNode) CanBeExecuted() bool {
+	return t.triggerState() == triggerStateReady
 }
 
-// CanBeExecuted check whether task could be executed
-func (t *TaskNode) CanBeExecuted() bool {
+func (t *TaskNode) triggerState() triggerState {
+	return triggerStates{}.of(t)
+}
+
+// triggerStates cache the trigger state of nodes in one evaluation, so each node is computed once
+// no matter how many downstream tasks depend on it
+type triggerStates map[*TaskNode]triggerState
+
+func (s triggerStates) of(t *TaskNode) triggerState {
+	if state, ok := s[t]; ok {
+		return state
+	}
+	state := s.compute(t)
+	s[t] = state
+	return state
+}
+
+func (s triggerStates) compute(t *TaskNode) triggerState {
 	if len(t.parents) == 0 {
-		return true
+		return triggerStateReady
 	}
 
+	var succeeded, failed, unreachable int
 	for _, p := range t.parents {
-		if !p.CanExecuteChild() {
-			return false
+		switch s.result(p) {
+		case upstreamResultSucceeded:
+			succeeded++
+		case upstreamResultFailed:
+			failed++
+		case upstreamResultUnreachable:
+			unreachable++
 		}
 	}
-	return true
+	total, done := len(t.parents), succeeded+failed+unreachable
+
+	switch t.TriggerRule {
+	case entity.TriggerRuleAllDone:
+		if done == total {
+			return triggerStateReady
+		}
+	case entity.TriggerRuleOneSuccess:
+		if succeeded > 0 {
+			return triggerStateReady
+		}
+		if done == total {
+			return triggerStateUnreachable
+		}
+	case entity.TriggerRuleOneFailed:
+		if failed+unreachable > 0 {
+			return triggerStateReady
+		}
+		if done == total {
+			return triggerStateUnreachable
+		}
+	case entity.TriggerRuleAllFailed:
+		if failed+unreachable == total {
+			return triggerStateReady
+		}
+		if succeeded > 0 {
+			return triggerStateUnreachable
+		}
+	case entity.TriggerRuleNoneFailed:
+		// unlike all_success, the upstream task which will never be executed is not treated as failed
+		if failed > 0 {
+			return triggerStateUnreachable
+		}
+		if done == total {
+			return triggerStateReady
+		}
+	default:
+		if failed+unreachable > 0 {
+			return triggerStateUnreachable
+		}
+		if succeeded == total {
+			return triggerStateReady
+		}
+	}
+	return triggerStateWaiting
+}
+
+// result return the result of task which its downstream tasks depend on
+func (s triggerStates) result(t *TaskNode) upstreamResult {
+	switch t.Status {
+	case entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSkipped:
+		return upstreamResultSucceeded
+	case entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusCanceled:
+		return upstreamResultFailed
+	case entity.TaskInstanceStatusInit, entity.TaskInstanceStatusUpstreamFailed:
+		// the upstream failed task is decided again, its upstream tasks may be retried or forced
+		if s.of(t) == triggerStateUnreachable {
+			return upstreamResultUnreachable
+		}
+	}
+	return upstreamResultPending
+}
+
+// ResolveUnreachable mark the init tasks whose trigger rule can never be met as upstream failed,
+// and mark the upstream failed tasks which can be met again as init, it returns the changed nodes
+func (t *TaskNode) ResolveUnreachable() (unreachable, reachable []*TaskNode) {
+	states := triggerStates{}
+	walkNode(t, func(node *TaskNode) bool {
+		state := states.of(node)
+		switch {
+		case node.Status == entity.TaskInstanceStatusInit && state == triggerStateUnreachable:
+			unreachable = append(unreachable, node)
+		case node.Status == entity.TaskInstanceStatusUpstreamFailed && state != triggerStateUnreachable:
+			reachable = append(reachable, node)
+		}
+		return true
+	})
+
+	// the states are computed before changing, init and upstream failed are treated in the same way
+	for _, node := range unreachable {
+		node.Status = entity.TaskInstanceStatusUpstreamFailed
+	}
+	for _, node := range reachable {
+		node.Status = entity.TaskInstanceStatusInit
+	}
+	return
 }
 
 // GetExecutableTaskIds is unique task id map
 func (t *TaskNode) GetExecutableTaskIds() (executables []string) {
+	states := triggerStates{}
 	walkNode(t, func(node *TaskNode) bool {
-		if node.Executable() {
+		if node.executable(states) {
 			executables = append(executables, node.TaskInsID)
 		}
 		return true
-	}, false)
+	})
 	return
 }
 
@@ -278,42 +457,103 @@ func (t *TaskNode) GetNextTaskIds(completedOrRetryTask *entity.TaskInstance) (ex
 			find = true
 			node.Status = completedOrRetryTask.Status
 
-			if node.Status == entity.TaskInstanceStatusInit {
+			// the retrying task is pushed again, executor delays it until its retry time
+			if node.Status == entity.TaskInstanceStatusInit || node.Status == entity.TaskInstanceStatusRetrying {
 				executable = append(executable, node.TaskInsID)
 				return false
 			}
 
-			if !node.CanExecuteChild() {
-				return false
-			}
-			for i := range node.children {
-				if node.children[i].Executable() {
-					executable = append(executable, node.children[i].TaskInsID)
-				}
-			}
+			executable = node.executableChildren(executable)
 			return false
 		}
 		return true
-	}, false)
+	})
 
 	return
 }
 
-// Executable check whether task could be executed
-func (t *TaskNode) Executable() bool {
-	if t.Status == entity.TaskInstanceStatusInit ||
-		t.Status == entity.TaskInstanceStatusRetrying ||
-		t.Status == entity.TaskInstanceStatusEnding {
-		if len(t.parents) == 0 {
+// SkipBranches mark the children which are not chosen by the branch task as skipped,
+// and propagate to the descendants whose parents are all skipped, it returns the skipped nodes
+func (t *TaskNode) SkipBranches(branchTask *entity.TaskInstance) (skipped []*TaskNode) {
+	if branchTask.Branches == nil {
+		return nil
+	}
+	walkNode(t, func(node *TaskNode) bool {
+		if branchTask.ID != node.TaskInsID {
 			return true
 		}
+		for _, c := range node.children {
+			if !utils.StringsContain(branchTask.Branches, c.TaskID) {
+				skipped = c.skip(skipped)
+			}
+		}
+		return false
+	})
+	return
+}
 
-		for i := range t.parents {
-			if !t.parents[i].CanExecuteChild() {
-				return false
+func (t *TaskNode) skip(skipped []*TaskNode) []*TaskNode {
+	if t.Status != entity.TaskInstanceStatusInit {
+		return skipped
+	}
+	t.Status = entity.TaskInstanceStatusSkipped
+	skipped = append(skipped, t)
+
+	for _, c := range t.children {
+		allParentsSkipped := true
+		for _, p := range c.parents {
+			if p.Status != entity.TaskInstanceStatusSkipped {
+				allParentsSkipped = false
+				break
 			}
 		}
-		return true
+		if allParentsSkipped {
+			skipped = c.skip(skipped)
+		}
+	}
+	return skipped
+}
+
+// executableChildren append the executable downstream tasks,
+// it looks through the children which will not be executed, because their downstream tasks may be triggered by them
+func (t *TaskNode) executableChildren(executable []string) []string {
+	return t.appendExecutableChildren(executable, triggerStates{}, map[*TaskNode]struct{}{})
+}
+
+func (t *TaskNode) appendExecutableChildren(
+	executable []string, states triggerStates, visited map[*TaskNode]struct{}) []string {
+
+	for _, c := range t.children {
+		if _, ok := visited[c]; ok {
+			continue
+		}
+		visited[c] = struct{}{}
+
+		if c.executable(states) {
+			if !utils.StringsContain(executable, c.TaskInsID) {
+				executable = append(executable, c.TaskInsID)
+			}
+			continue
+		}
+		if (c.Status == entity.TaskInstanceStatusInit || c.Status == entity.TaskInstanceStatusUpstreamFailed) &&
+			states.of(c) == triggerStateUnreachable {
+			executable = c.appendExecutableChildren(executable, states, visited)
+		}
+	}
+	return executable
+}
+
+// Executable check whether task could be executed
+func (t *TaskNode) Executable() bool {
+	return t.executable(triggerStates{})
+}
+
+func (t *TaskNode) executable(states triggerStates) bool {
+	if t.Status == entity.TaskInstanceStatusInit ||
+		t.Status == entity.TaskInstanceStatusRetrying ||
+		t.Status == entity.TaskInstanceStatusEnding ||
+		t.Status == entity.TaskInstanceStatusWaiting {
+		return states.of(t) == triggerStateReady
 	}
 	return false
 }
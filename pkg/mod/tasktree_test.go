@@ -0,0 +1,444 @@
+package mod
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weeyp/fastflow/pkg/entity"
+)
+
+func TestTaskNode_TriggerRule(t *testing.T) {
+	tests := []struct {
+		caseDesc        string
+		giveRule        entity.TriggerRule
+		giveParents     []entity.TaskInstanceStatus
+		wantExecutable  bool
+		wantUnreachable bool
+	}{
+		{
+			caseDesc:       "all success",
+			giveParents:    []entity.TaskInstanceStatus{entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSkipped},
+			wantExecutable: true,
+		},
+		{
+			caseDesc:    "all success waiting",
+			giveParents: []entity.TaskInstanceStatus{entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusRunning},
+		},
+		{
+			caseDesc:        "all success with failed parent",
+			giveParents:     []entity.TaskInstanceStatus{entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusRunning},
+			wantUnreachable: true,
+		},
+		{
+			caseDesc:       "all done",
+			giveRule:       entity.TriggerRuleAllDone,
+			giveParents:    []entity.TaskInstanceStatus{entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusCanceled, entity.TaskInstanceStatusSuccess},
+			wantExecutable: true,
+		},
+		{
+			caseDesc:    "all done waiting",
+			giveRule:    entity.TriggerRuleAllDone,
+			giveParents: []entity.TaskInstanceStatus{entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusBlocked},
+		},
+		{
+			caseDesc:       "one success",
+			giveRule:       entity.TriggerRuleOneSuccess,
+			giveParents:    []entity.TaskInstanceStatus{entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusRunning},
+			wantExecutable: true,
+		},
+		{
+			caseDesc:        "one success but all failed",
+			giveRule:        entity.TriggerRuleOneSuccess,
+			giveParents:     []entity.TaskInstanceStatus{entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusFailed},
+			wantUnreachable: true,
+		},
+		{
+			caseDesc:       "one failed",
+			giveRule:       entity.TriggerRuleOneFailed,
+			giveParents:    []entity.TaskInstanceStatus{entity.TaskInstanceStatusCanceled, entity.TaskInstanceStatusRunning},
+			wantExecutable: true,
+		},
+		{
+			caseDesc:        "one failed but all success",
+			giveRule:        entity.TriggerRuleOneFailed,
+			giveParents:     []entity.TaskInstanceStatus{entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSuccess},
+			wantUnreachable: true,
+		},
+		{
+			caseDesc:       "all failed",
+			giveRule:       entity.TriggerRuleAllFailed,
+			giveParents:    []entity.TaskInstanceStatus{entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusCanceled},
+			wantExecutable: true,
+		},
+		{
+			caseDesc:        "all failed but one success",
+			giveRule:        entity.TriggerRuleAllFailed,
+			giveParents:     []entity.TaskInstanceStatus{entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusRunning},
+			wantUnreachable: true,
+		},
+		{
+			caseDesc:       "none failed",
+			giveRule:       entity.TriggerRuleNoneFailed,
+			giveParents:    []entity.TaskInstanceStatus{entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSkipped},
+			wantExecutable: true,
+		},
+		{
+			caseDesc:        "none failed but one failed",
+			giveRule:        entity.TriggerRuleNoneFailed,
+			giveParents:     []entity.TaskInstanceStatus{entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusFailed},
+			wantUnreachable: true,
+		},
+	}
+
+	for _, tc := range tests {
+		child := &TaskNode{TaskInsID: "child", Status: entity.TaskInstanceStatusInit, TriggerRule: tc.giveRule}
+		for _, s := range tc.giveParents {
+			p := &TaskNode{Status: s}
+			p.AppendChild(child)
+			child.AppendParent(p)
+		}
+		assert.Equal(t, tc.wantExecutable, child.Executable(), tc.caseDesc)
+		assert.Equal(t, tc.wantUnreachable, child.triggerState() == triggerStateUnreachable, tc.caseDesc)
+	}
+}
+
+func TestTaskNode_NoneFailed(t *testing.T) {
+	// task1 -> task2(one_failed) -> task3
+	//       -------------------- -> task3
+	tests := []struct {
+		caseDesc       string
+		giveRule       entity.TriggerRule
+		giveStatus     entity.TaskInstanceStatus
+		wantExecutable []string
+	}{
+		{
+			caseDesc:   "all success treats unreachable upstream as failed",
+			giveStatus: entity.TaskInstanceStatusSuccess,
+		},
+		{
+			caseDesc:       "none failed ignores unreachable upstream",
+			giveRule:       entity.TriggerRuleNoneFailed,
+			giveStatus:     entity.TaskInstanceStatusSuccess,
+			wantExecutable: []string{"task3"},
+		},
+		{
+			caseDesc:       "none failed with failed upstream",
+			giveRule:       entity.TriggerRuleNoneFailed,
+			giveStatus:     entity.TaskInstanceStatusFailed,
+			wantExecutable: []string{"task2"},
+		},
+	}
+
+	for _, tc := range tests {
+		root, err := BuildRootNode(MapTaskInsToGetter([]*entity.TaskInstance{
+			{ID: "task1", TaskID: "task1", Status: tc.giveStatus},
+			{ID: "task2", TaskID: "task2", Status: entity.TaskInstanceStatusInit, DependOn: []string{"task1"},
+				TriggerRule: entity.TriggerRuleOneFailed},
+			{ID: "task3", TaskID: "task3", Status: entity.TaskInstanceStatusInit, DependOn: []string{"task1", "task2"},
+				TriggerRule: tc.giveRule},
+		}))
+		assert.NoError(t, err, tc.caseDesc)
+		assert.ElementsMatch(t, tc.wantExecutable, root.GetExecutableTaskIds(), tc.caseDesc)
+	}
+}
+
+func TestTaskNode_ResolveUnreachable(t *testing.T) {
+	// task1 -> task2 -> task3
+	//       -> task4(one_failed)
+	tests := []struct {
+		caseDesc        string
+		giveStatus      entity.TaskInstanceStatus
+		giveDownstream  entity.TaskInstanceStatus
+		wantUnreachable []string
+		wantReachable   []string
+		wantStatus      TreeStatus
+	}{
+		{
+			caseDesc:        "failed upstream",
+			giveStatus:      entity.TaskInstanceStatusFailed,
+			giveDownstream:  entity.TaskInstanceStatusInit,
+			wantUnreachable: []string{"task2", "task3"},
+			wantStatus:      TreeStatusRunning,
+		},
+		{
+			caseDesc:        "succeeded upstream",
+			giveStatus:      entity.TaskInstanceStatusSuccess,
+			giveDownstream:  entity.TaskInstanceStatusInit,
+			wantUnreachable: []string{"task4"},
+			wantStatus:      TreeStatusRunning,
+		},
+		{
+			caseDesc:       "resolved again",
+			giveStatus:     entity.TaskInstanceStatusFailed,
+			giveDownstream: entity.TaskInstanceStatusUpstreamFailed,
+			wantStatus:     TreeStatusRunning,
+		},
+		{
+			caseDesc:       "upstream is retried",
+			giveStatus:     entity.TaskInstanceStatusRetrying,
+			giveDownstream: entity.TaskInstanceStatusUpstreamFailed,
+			wantReachable:  []string{"task2", "task3"},
+			wantStatus:     TreeStatusRunning,
+		},
+	}
+
+	for _, tc := range tests {
+		task4Status := entity.TaskInstanceStatusRunning
+		if tc.giveStatus == entity.TaskInstanceStatusSuccess {
+			task4Status = entity.TaskInstanceStatusInit
+		}
+		root, err := BuildRootNode(MapTaskInsToGetter([]*entity.TaskInstance{
+			{ID: "task1", TaskID: "task1", Status: tc.giveStatus},
+			{ID: "task2", TaskID: "task2", Status: tc.giveDownstream, DependOn: []string{"task1"}},
+			{ID: "task3", TaskID: "task3", Status: tc.giveDownstream, DependOn: []string{"task2"}},
+			{ID: "task4", TaskID: "task4", Status: task4Status, DependOn: []string{"task1"},
+				TriggerRule: entity.TriggerRuleOneFailed},
+		}))
+		assert.NoError(t, err, tc.caseDesc)
+
+		unreachable, reachable := root.ResolveUnreachable()
+		var unreachableIds, reachableIds []string
+		for _, n := range unreachable {
+			unreachableIds = append(unreachableIds, n.TaskInsID)
+			assert.Equal(t, entity.TaskInstanceStatusUpstreamFailed, n.Status, tc.caseDesc)
+		}
+		for _, n := range reachable {
+			reachableIds = append(reachableIds, n.TaskInsID)
+			assert.Equal(t, entity.TaskInstanceStatusInit, n.Status, tc.caseDesc)
+		}
+		assert.ElementsMatch(t, tc.wantUnreachable, unreachableIds, tc.caseDesc)
+		assert.ElementsMatch(t, tc.wantReachable, reachableIds, tc.caseDesc)
+		sts, _ := root.ComputeStatus()
+		assert.Equal(t, tc.wantStatus, sts, tc.caseDesc)
+	}
+}
+
+func TestTaskNode_ComputeStatus(t *testing.T) {
+	// task1 -> task2 -> task3(all_done)
+	//       -> task4(one_failed)
+	buildTasks := func(statuses ...entity.TaskInstanceStatus) []*entity.TaskInstance {
+		return []*entity.TaskInstance{
+			{ID: "task1", TaskID: "task1", Status: statuses[0]},
+			{ID: "task2", TaskID: "task2", Status: statuses[1], DependOn: []string{"task1"}},
+			{ID: "task3", TaskID: "task3", Status: statuses[2], DependOn: []string{"task2"}, TriggerRule: entity.TriggerRuleAllDone},
+			{ID: "task4", TaskID: "task4", Status: statuses[3], DependOn: []string{"task1"}, TriggerRule: entity.TriggerRuleOneFailed},
+		}
+	}
+	tests := []struct {
+		caseDesc       string
+		giveTasks      []*entity.TaskInstance
+		wantStatus     TreeStatus
+		wantSrcTaskIns string
+		wantExecutable []string
+	}{
+		{
+			caseDesc: "success",
+			giveTasks: buildTasks(entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSuccess,
+				entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusInit),
+			wantStatus: TreeStatusSuccess,
+		},
+		{
+			caseDesc: "cleanup task should run after failure",
+			giveTasks: buildTasks(entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusInit,
+				entity.TaskInstanceStatusInit, entity.TaskInstanceStatusInit),
+			wantStatus:     TreeStatusRunning,
+			wantSrcTaskIns: "task3",
+			wantExecutable: []string{"task3", "task4"},
+		},
+		{
+			caseDesc: "failed after cleanup",
+			giveTasks: buildTasks(entity.TaskInstanceStatusFailed, entity.TaskInstanceStatusInit,
+				entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusSuccess),
+			wantStatus:     TreeStatusFailed,
+			wantSrcTaskIns: "task1",
+		},
+		{
+			caseDesc: "waiting tasks are decided by upstream",
+			giveTasks: buildTasks(entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusBlocked,
+				entity.TaskInstanceStatusInit, entity.TaskInstanceStatusInit),
+			wantStatus:     TreeStatusBlocked,
+			wantSrcTaskIns: "task2",
+		},
+		{
+			caseDesc: "running",
+			giveTasks: buildTasks(entity.TaskInstanceStatusSuccess, entity.TaskInstanceStatusRetrying,
+				entity.TaskInstanceStatusInit, entity.TaskInstanceStatusInit),
+			wantStatus:     TreeStatusRunning,
+			wantSrcTaskIns: "task2",
+			wantExecutable: []string{"task2"},
+		},
+	}
+
+	for _, tc := range tests {
+		root, err := BuildRootNode(MapTaskInsToGetter(tc.giveTasks))
+		assert.NoError(t, err, tc.caseDesc)
+		status, srcTaskIns := root.ComputeStatus()
+		assert.Equal(t, tc.wantStatus, status, tc.caseDesc)
+		assert.Equal(t, tc.wantSrcTaskIns, srcTaskIns, tc.caseDesc)
+		assert.ElementsMatch(t, tc.wantExecutable, root.GetExecutableTaskIds(), tc.caseDesc)
+	}
+}
+
+func TestTaskNode_GetNextTaskIds(t *testing.T) {
+	// task1 -> task2 -> task3(all_done)
+	tasks := []*entity.TaskInstance{
+		{ID: "task1", TaskID: "task1", Status: entity.TaskInstanceStatusRunning},
+		{ID: "task2", TaskID: "task2", Status: entity.TaskInstanceStatusInit, DependOn: []string{"task1"}},
+		{ID: "task3", TaskID: "task3", Status: entity.TaskInstanceStatusInit, DependOn: []string{"task2"}, TriggerRule: entity.TriggerRuleAllDone},
+	}
+	root, err := BuildRootNode(MapTaskInsToGetter(tasks))
+	assert.NoError(t, err)
+
+	ids, find := root.GetNextTaskIds(&entity.TaskInstance{ID: "task1", Status: entity.TaskInstanceStatusFailed})
+	assert.True(t, find)
+	assert.Equal(t, []string{"task3"}, ids)
+
+	ids, find = root.GetNextTaskIds(&entity.TaskInstance{ID: "task3", Status: entity.TaskInstanceStatusSuccess})
+	assert.True(t, find)
+	assert.Empty(t, ids)
+	status, srcTaskIns := root.ComputeStatus()
+	assert.Equal(t, TreeStatusFailed, status)
+	assert.Equal(t, "task1", srcTaskIns)
+}
+
+func TestTaskNode_WideAndDeep(t *testing.T) {
+	// every task depends on the whole previous layer, the first layer failed,
+	// so only the last layer(all_done) can be executed after looking through all layers
+	const width, depth = 3, 40
+	var tasks []*entity.TaskInstance
+	for l := 0; l < depth; l++ {
+		for w := 0; w < width; w++ {
+			taskIns := &entity.TaskInstance{
+				ID:     fmt.Sprintf("task-%d-%d", l, w),
+				TaskID: fmt.Sprintf("task-%d-%d", l, w),
+				Status: entity.TaskInstanceStatusInit,
+			}
+			if l == 0 {
+				taskIns.Status = entity.TaskInstanceStatusFailed
+			}
+			if l == depth-1 {
+				taskIns.TriggerRule = entity.TriggerRuleAllDone
+			}
+			for p := 0; l > 0 && p < width; p++ {
+				taskIns.DependOn = append(taskIns.DependOn, fmt.Sprintf("task-%d-%d", l-1, p))
+			}
+			tasks = append(tasks, taskIns)
+		}
+	}
+	wantExecutable := []string{"task-39-0", "task-39-1", "task-39-2"}
+
+	root, err := BuildRootNode(MapTaskInsToGetter(tasks))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, wantExecutable, root.GetExecutableTaskIds())
+	status, srcTaskIns := root.ComputeStatus()
+	assert.Equal(t, TreeStatusRunning, status)
+	assert.Contains(t, wantExecutable, srcTaskIns)
+
+	ids, find := root.GetNextTaskIds(tasks[0])
+	assert.True(t, find)
+	assert.ElementsMatch(t, wantExecutable, ids)
+}
+
+func TestTaskNode_SkipBranches(t *testing.T) {
+	// branch -> task1 -> join
+	//        -> task2 -> join
+	//        -> task3 -> task4
+	tasks := []*entity.TaskInstance{
+		{ID: "branch", TaskID: "branch", Status: entity.TaskInstanceStatusRunning},
+		{ID: "task1-ins", TaskID: "task1", Status: entity.TaskInstanceStatusInit, DependOn: []string{"branch"}},
+		{ID: "task2-ins", TaskID: "task2", Status: entity.TaskInstanceStatusInit, DependOn: []string{"branch"}},
+		{ID: "task3-ins", TaskID: "task3", Status: entity.TaskInstanceStatusInit, DependOn: []string{"branch"}},
+		{ID: "task4-ins", TaskID: "task4", Status: entity.TaskInstanceStatusInit, DependOn: []string{"task3"}},
+		{ID: "join-ins", TaskID: "join", Status: entity.TaskInstanceStatusInit, DependOn: []string{"task1", "task2"}},
+	}
+	tests := []struct {
+		caseDesc       string
+		giveBranches   []string
+		wantSkipped    []string
+		wantExecutable []string
+	}{
+		{
+			caseDesc:       "not a branch task",
+			wantExecutable: []string{"task1-ins", "task2-ins", "task3-ins"},
+		},
+		{
+			caseDesc:       "choose one branch",
+			giveBranches:   []string{"task1"},
+			wantSkipped:    []string{"task2-ins", "task3-ins", "task4-ins"},
+			wantExecutable: []string{"task1-ins"},
+		},
+		{
+			caseDesc:       "choose none",
+			giveBranches:   []string{},
+			wantSkipped:    []string{"task1-ins", "task2-ins", "task3-ins", "task4-ins", "join-ins"},
+			wantExecutable: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		root, err := BuildRootNode(MapTaskInsToGetter(tasks))
+		assert.NoError(t, err, tc.caseDesc)
+
+		branchTask := &entity.TaskInstance{ID: "branch", Status: entity.TaskInstanceStatusSuccess, Branches: tc.giveBranches}
+		var skipped []string
+		for _, n := range root.SkipBranches(branchTask) {
+			skipped = append(skipped, n.TaskInsID)
+		}
+		assert.ElementsMatch(t, tc.wantSkipped, skipped, tc.caseDesc)
+
+		ids, find := root.GetNextTaskIds(branchTask)
+		assert.True(t, find, tc.caseDesc)
+		assert.ElementsMatch(t, tc.wantExecutable, ids, tc.caseDesc)
+	}
+}
+
+func TestTaskNode_Descendants(t *testing.T) {
+	// task1 -> task2 -> task4
+	//       -> task3 -> task4
+	// task5
+	tasks := []*entity.TaskInstance{
+		{ID: "task1-ins", TaskID: "task1", Status: entity.TaskInstanceStatusSuccess},
+		{ID: "task2-ins", TaskID: "task2", Status: entity.TaskInstanceStatusSuccess, DependOn: []string{"task1"}},
+		{ID: "task3-ins", TaskID: "task3", Status: entity.TaskInstanceStatusSuccess, DependOn: []string{"task1"}},
+		{ID: "task4-ins", TaskID: "task4", Status: entity.TaskInstanceStatusSuccess, DependOn: []string{"task2", "task3"}},
+		{ID: "task5-ins", TaskID: "task5", Status: entity.TaskInstanceStatusSuccess},
+	}
+	tests := []struct {
+		caseDesc  string
+		giveInsId string
+		wantIds   []string
+		wantFind  bool
+	}{
+		{
+			caseDesc:  "root",
+			giveInsId: "task1-ins",
+			wantIds:   []string{"task1-ins", "task2-ins", "task3-ins", "task4-ins"},
+			wantFind:  true,
+		},
+		{
+			caseDesc:  "middle",
+			giveInsId: "task3-ins",
+			wantIds:   []string{"task3-ins", "task4-ins"},
+			wantFind:  true,
+		},
+		{
+			caseDesc:  "leaf",
+			giveInsId: "task5-ins",
+			wantIds:   []string{"task5-ins"},
+			wantFind:  true,
+		},
+		{
+			caseDesc:  "not found",
+			giveInsId: "task6-ins",
+		},
+	}
+
+	root, err := BuildRootNode(MapTaskInsToGetter(tasks))
+	assert.NoError(t, err)
+	for _, tc := range tests {
+		ids, find := root.Descendants(tc.giveInsId)
+		assert.Equal(t, tc.wantFind, find, tc.caseDesc)
+		assert.ElementsMatch(t, tc.wantIds, ids, tc.caseDesc)
+	}
+}
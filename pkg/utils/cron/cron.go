@@ -0,0 +1,56 @@
+package cron
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// parser accept 5 fields(minute hour day-of-month month day-of-week),
+// 6 fields(with second at first) and descriptors such as "@daily", "@hourly"
+var parser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// Schedule describe when a job should be triggered, it is based on github.com/robfig/cron/v3
+type Schedule struct {
+	schedule cron.Schedule
+}
+
+// Parse parse a standard cron expression, it supports:
+// 5 fields: minute hour day-of-month month day-of-week
+// 6 fields: second minute hour day-of-month month day-of-week
+// and descriptors such as "@daily", "@hourly"
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("cron expression can not be empty")
+	}
+	// "@every" is not a calendar schedule, and the time zone should be decided by the location of time
+	if strings.HasPrefix(expr, "@every") || strings.HasPrefix(expr, "TZ=") || strings.HasPrefix(expr, "CRON_TZ=") {
+		return nil, fmt.Errorf("unsupported cron expression: %s", expr)
+	}
+
+	s, err := parser.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parse cron[%s] failed: %w", expr, err)
+	}
+	return &Schedule{schedule: s}, nil
+}
+
+// MustParse is like Parse but panics if the expression is invalid
+func MustParse(expr string) *Schedule {
+	s, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Next returns the next time this schedule is activated, greater than the given time.
+// If no time can be found to satisfy the schedule, return the zero time.
+func (s *Schedule) Next(t time.Time) time.Time {
+	return s.schedule.Next(t)
+}
@@ -0,0 +1,63 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		caseDesc string
+		giveExpr string
+		wantErr  bool
+	}{
+		{caseDesc: "five fields", giveExpr: "*/5 * * * *"},
+		{caseDesc: "six fields", giveExpr: "0 */5 * * * *"},
+		{caseDesc: "names", giveExpr: "0 9 * jan-mar mon-fri"},
+		{caseDesc: "list and range", giveExpr: "0,30 9-18 1,15 * ?"},
+		{caseDesc: "descriptor", giveExpr: "@daily"},
+		{caseDesc: "empty", giveExpr: "", wantErr: true},
+		{caseDesc: "unknown descriptor", giveExpr: "@sometimes", wantErr: true},
+		{caseDesc: "interval is not supported", giveExpr: "@every 1m", wantErr: true},
+		{caseDesc: "too few fields", giveExpr: "* * * *", wantErr: true},
+		{caseDesc: "out of range", giveExpr: "60 * * * *", wantErr: true},
+		{caseDesc: "reverse range", giveExpr: "* 10-5 * * *", wantErr: true},
+		{caseDesc: "zero step", giveExpr: "*/0 * * * *", wantErr: true},
+		{caseDesc: "not a number", giveExpr: "a * * * *", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		_, err := Parse(tc.giveExpr)
+		assert.Equal(t, tc.wantErr, err != nil, tc.caseDesc)
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	tests := []struct {
+		giveExpr string
+		giveTime string
+		wantTime string
+	}{
+		{giveExpr: "* * * * *", giveTime: "2023-01-01T10:00:00Z", wantTime: "2023-01-01T10:01:00Z"},
+		{giveExpr: "*/15 * * * *", giveTime: "2023-01-01T10:07:30Z", wantTime: "2023-01-01T10:15:00Z"},
+		{giveExpr: "*/10 * * * * *", giveTime: "2023-01-01T10:00:05Z", wantTime: "2023-01-01T10:00:10Z"},
+		{giveExpr: "0 9 * * mon-fri", giveTime: "2023-01-06T10:00:00Z", wantTime: "2023-01-09T09:00:00Z"},
+		{giveExpr: "0 0 1 * *", giveTime: "2023-01-31T23:59:59Z", wantTime: "2023-02-01T00:00:00Z"},
+		{giveExpr: "0 0 29 2 *", giveTime: "2023-01-01T00:00:00Z", wantTime: "2024-02-29T00:00:00Z"},
+		{giveExpr: "@hourly", giveTime: "2023-12-31T23:30:00Z", wantTime: "2024-01-01T00:00:00Z"},
+		// day-of-month OR day-of-week when both are restricted
+		{giveExpr: "0 0 15 * sun", giveTime: "2023-01-02T00:00:00Z", wantTime: "2023-01-08T00:00:00Z"},
+	}
+
+	for _, tc := range tests {
+		give, err := time.Parse(time.RFC3339, tc.giveTime)
+		assert.NoError(t, err)
+		want, err := time.Parse(time.RFC3339, tc.wantTime)
+		assert.NoError(t, err)
+
+		next := MustParse(tc.giveExpr).Next(give)
+		assert.Equal(t, want, next, tc.giveExpr)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 )
 
+// Selector is used to match labels, such as "zone=a", "zone in (a,b)" or "gpu"
 type Selector struct {
 	Key    string
 	Op     SelectorOp
@@ -15,12 +16,19 @@ type Selector struct {
 type SelectorOp string
 
 const (
-	SelectorOpEqual SelectorOp = "="
-	SelectorOpIn    SelectorOp = "in"
+	SelectorOpEqual    SelectorOp = "="
+	SelectorOpNotEqual SelectorOp = "!="
+	SelectorOpIn       SelectorOp = "in"
+	SelectorOpNotIn    SelectorOp = "notin"
+	// SelectorOpExists is expressed by a single key, such as "gpu"
+	SelectorOpExists SelectorOp = "exists"
 )
 
+// PareSelectors parse selector expression to selectors, multiple selectors are separated by ",",
+// supported format:
+// key=value, key!=value, key in (v1,v2), key notin (v1,v2), key
 func PareSelectors(selector string) (selectors []Selector, err error) {
-	if selector == "" {
+	if strings.TrimSpace(selector) == "" {
 		return nil, errors.New("selector expression can not be empty")
 	}
 
@@ -28,38 +36,131 @@ func PareSelectors(selector string) (selectors []Selector, err error) {
 	if err != nil {
 		return nil, err
 	}
-	var rs []Selector
 	selectorExprs := splitStringsWithIdx(selector, idx)
 	for i := range selectorExprs {
-		eqIdx := strings.Index(selectorExprs[i], string(SelectorOpEqual))
-		inIdx := strings.Index(selectorExprs[i], fmt.Sprintf(" %s ", SelectorOpIn))
-
-		s := Selector{}
-		opIdx, opLen := 0, 0
-		switch {
-		case eqIdx > -1:
-			opIdx, opLen = eqIdx, 1
-			s.Op = SelectorOpEqual
-		case inIdx > -1:
-			opIdx, opLen = inIdx, 4
-			s.Op = SelectorOpIn
-
-		default:
-			return nil, fmt.Errorf("selector string '%v' operator is not '=' or 'in'", selectorExprs[i])
+		s, err := parseSelector(selectorExprs[i])
+		if err != nil {
+			return nil, err
 		}
+		selectors = append(selectors, s)
+	}
+	return selectors, nil
+}
+
+func parseSelector(expr string) (s Selector, err error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return s, fmt.Errorf("selector string can not be empty")
+	}
 
-		key, val := getTrimKeyValue(selectorExprs[i], opIdx, opLen)
-		s.Key = key
-		if s.Op == SelectorOpEqual {
-			s.Values = []string{val}
-		} else {
-			s.Values = strings.Split(val[1:len(val)-1], ",")
+	neIdx := strings.Index(expr, string(SelectorOpNotEqual))
+	eqIdx := strings.Index(expr, string(SelectorOpEqual))
+	notInIdx := strings.Index(expr, fmt.Sprintf(" %s ", SelectorOpNotIn))
+	inIdx := strings.Index(expr, fmt.Sprintf(" %s ", SelectorOpIn))
+
+	opIdx, opLen := 0, 0
+	switch {
+	case neIdx > -1:
+		opIdx, opLen = neIdx, len(SelectorOpNotEqual)
+		s.Op = SelectorOpNotEqual
+	case eqIdx > -1:
+		opIdx, opLen = eqIdx, len(SelectorOpEqual)
+		s.Op = SelectorOpEqual
+	case notInIdx > -1:
+		opIdx, opLen = notInIdx, len(SelectorOpNotIn)+2
+		s.Op = SelectorOpNotIn
+	case inIdx > -1:
+		opIdx, opLen = inIdx, len(SelectorOpIn)+2
+		s.Op = SelectorOpIn
+	default:
+		if strings.ContainsAny(expr, " ()") {
+			return s, fmt.Errorf("selector string '%v' operator is not one of '=', '!=', 'in', 'notin'", expr)
 		}
-		s.Values = strings.Split(val, ",")
-		selectors = append(selectors, s)
+		s.Key, s.Op = expr, SelectorOpExists
+		return s, nil
+	}
+
+	key, val := getTrimKeyValue(expr, opIdx, opLen)
+	if key == "" {
+		return s, fmt.Errorf("selector string '%v' does not have a key", expr)
+	}
+	s.Key = key
+
+	switch s.Op {
+	case SelectorOpEqual, SelectorOpNotEqual:
+		if val == "" || strings.ContainsAny(val, "() ") {
+			return s, fmt.Errorf("selector string '%v' has an invalid value", expr)
+		}
+		s.Values = []string{val}
+	case SelectorOpIn, SelectorOpNotIn:
+		if len(val) < 2 || val[0] != '(' || val[len(val)-1] != ')' {
+			return s, fmt.Errorf("selector string '%v' values must be enclosed in '()'", expr)
+		}
+		for _, v := range strings.Split(val[1:len(val)-1], ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				return s, fmt.Errorf("selector string '%v' has an empty value", expr)
+			}
+			s.Values = append(s.Values, v)
+		}
+	}
+	return s, nil
+}
+
+// Match return if the labels meet the selector
+func (s Selector) Match(labels map[string]string) bool {
+	v, ok := labels[s.Key]
+	switch s.Op {
+	case SelectorOpExists:
+		return ok
+	case SelectorOpEqual, SelectorOpIn:
+		return ok && stringInSlice(v, s.Values)
+	case SelectorOpNotEqual, SelectorOpNotIn:
+		return !ok || !stringInSlice(v, s.Values)
 	}
-	return rs, nil
+	return false
 }
+
+// MatchSelectors return if the labels meet all selectors
+func MatchSelectors(selectors []Selector, labels map[string]string) bool {
+	for i := range selectors {
+		if !selectors[i].Match(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabels parse labels expression such as "zone=a,gpu=false"
+func ParseLabels(labels string) (map[string]string, error) {
+	ret := map[string]string{}
+	if strings.TrimSpace(labels) == "" {
+		return ret, nil
+	}
+
+	for _, kv := range strings.Split(labels, ",") {
+		idx := strings.Index(kv, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("label '%v' is not a key-value pair", kv)
+		}
+		key, val := getTrimKeyValue(kv, idx, 1)
+		if key == "" {
+			return nil, fmt.Errorf("label '%v' does not have a key", kv)
+		}
+		ret[key] = val
+	}
+	return ret, nil
+}
+
+func stringInSlice(str string, arr []string) bool {
+	for i := range arr {
+		if arr[i] == str {
+			return true
+		}
+	}
+	return false
+}
+
 func scanAllSplits(s string) ([]int, error) {
 	multipleValueStart := false
 	var splitsIdx []int
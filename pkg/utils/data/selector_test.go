@@ -0,0 +1,130 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPareSelectors(t *testing.T) {
+	tests := []struct {
+		caseDesc string
+		giveExpr string
+		wantSels []Selector
+		wantErr  bool
+	}{
+		{
+			caseDesc: "equal",
+			giveExpr: "zone=a",
+			wantSels: []Selector{{Key: "zone", Op: SelectorOpEqual, Values: []string{"a"}}},
+		},
+		{
+			caseDesc: "not equal",
+			giveExpr: "zone != a",
+			wantSels: []Selector{{Key: "zone", Op: SelectorOpNotEqual, Values: []string{"a"}}},
+		},
+		{
+			caseDesc: "in",
+			giveExpr: "zone in (a, b)",
+			wantSels: []Selector{{Key: "zone", Op: SelectorOpIn, Values: []string{"a", "b"}}},
+		},
+		{
+			caseDesc: "not in",
+			giveExpr: "zone notin (a,b)",
+			wantSels: []Selector{{Key: "zone", Op: SelectorOpNotIn, Values: []string{"a", "b"}}},
+		},
+		{
+			caseDesc: "exists",
+			giveExpr: "gpu",
+			wantSels: []Selector{{Key: "gpu", Op: SelectorOpExists}},
+		},
+		{
+			caseDesc: "multiple",
+			giveExpr: "zone in (a,b),gpu=false, ssd",
+			wantSels: []Selector{
+				{Key: "zone", Op: SelectorOpIn, Values: []string{"a", "b"}},
+				{Key: "gpu", Op: SelectorOpEqual, Values: []string{"false"}},
+				{Key: "ssd", Op: SelectorOpExists},
+			},
+		},
+		{caseDesc: "empty", giveExpr: " ", wantErr: true},
+		{caseDesc: "empty item", giveExpr: "zone=a,", wantErr: true},
+		{caseDesc: "missing key", giveExpr: "=a", wantErr: true},
+		{caseDesc: "missing value", giveExpr: "zone=", wantErr: true},
+		{caseDesc: "unclosed bracket", giveExpr: "zone in (a,b", wantErr: true},
+		{caseDesc: "in without bracket", giveExpr: "zone in a", wantErr: true},
+		{caseDesc: "empty value in bracket", giveExpr: "zone in (a,)", wantErr: true},
+		{caseDesc: "unknown operator", giveExpr: "zone like a", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		sels, err := PareSelectors(tc.giveExpr)
+		assert.Equal(t, tc.wantErr, err != nil, tc.caseDesc)
+		assert.Equal(t, tc.wantSels, sels, tc.caseDesc)
+	}
+}
+
+func TestSelector_Match(t *testing.T) {
+	labels := map[string]string{
+		"zone": "a",
+		"gpu":  "false",
+	}
+	tests := []struct {
+		giveExpr  string
+		wantMatch bool
+	}{
+		{giveExpr: "zone=a", wantMatch: true},
+		{giveExpr: "zone=b", wantMatch: false},
+		{giveExpr: "region=a", wantMatch: false},
+		{giveExpr: "zone!=b", wantMatch: true},
+		{giveExpr: "zone!=a", wantMatch: false},
+		{giveExpr: "region!=a", wantMatch: true},
+		{giveExpr: "zone in (a,b)", wantMatch: true},
+		{giveExpr: "zone in (b,c)", wantMatch: false},
+		{giveExpr: "region in (a)", wantMatch: false},
+		{giveExpr: "zone notin (b,c)", wantMatch: true},
+		{giveExpr: "zone notin (a,c)", wantMatch: false},
+		{giveExpr: "region notin (a)", wantMatch: true},
+		{giveExpr: "gpu", wantMatch: true},
+		{giveExpr: "ssd", wantMatch: false},
+		{giveExpr: "zone=a,gpu=false", wantMatch: true},
+		{giveExpr: "zone=a,gpu=true", wantMatch: false},
+	}
+
+	for _, tc := range tests {
+		sels, err := PareSelectors(tc.giveExpr)
+		assert.NoError(t, err, tc.giveExpr)
+		assert.Equal(t, tc.wantMatch, MatchSelectors(sels, labels), tc.giveExpr)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		giveLabels string
+		wantLabels map[string]string
+		wantErr    bool
+	}{
+		{
+			giveLabels: "zone=a, gpu=false",
+			wantLabels: map[string]string{"zone": "a", "gpu": "false"},
+		},
+		{
+			giveLabels: "",
+			wantLabels: map[string]string{},
+		},
+		{
+			giveLabels: "zone",
+			wantErr:    true,
+		},
+		{
+			giveLabels: "=a",
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		labels, err := ParseLabels(tc.giveLabels)
+		assert.Equal(t, tc.wantErr, err != nil, tc.giveLabels)
+		assert.Equal(t, tc.wantLabels, labels, tc.giveLabels)
+	}
+}
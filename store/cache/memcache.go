@@ -10,12 +10,33 @@ import (
 	"github.com/weeyp/fastflow/pkg/utils/data"
 	"github.com/weeyp/fastflow/store"
 	"reflect"
+	"sort"
+	"sync"
+	"time"
 )
 
+// executedCmdTTL is how long the executed commands are kept, so the sync callers can get their results
+const executedCmdTTL = 10 * time.Minute
+
 type MemCache struct {
 	dags    *cache.Cache
 	dagIns  *cache.Cache
 	taskIns *cache.Cache
+	nodes   *cache.Cache
+	cmds    *cache.Cache
+
+	executedCmdTTL time.Duration
+
+	leader      leaderLease
+	leaderMutex sync.Mutex
+
+	// dagInsMutex make the patches of dag instance atomic, so the owner of it can be compared and set
+	dagInsMutex sync.Mutex
+}
+
+type leaderLease struct {
+	workerKey string
+	expiredAt time.Time
 }
 
 func NewMemCache() *MemCache {
@@ -23,6 +44,11 @@ func NewMemCache() *MemCache {
 		dags:    cache.New(cache.NoExpiration, cache.NoExpiration),
 		dagIns:  cache.New(cache.NoExpiration, cache.NoExpiration),
 		taskIns: cache.New(cache.NoExpiration, cache.NoExpiration),
+		nodes:   cache.New(cache.NoExpiration, cache.NoExpiration),
+		// the executed commands are expired, so they need to be cleaned up
+		cmds: cache.New(cache.NoExpiration, time.Minute),
+
+		executedCmdTTL: executedCmdTTL,
 	}
 }
 
@@ -51,6 +77,12 @@ func (m *MemCache) updateItem(id string, item interface{}, c *cache.Cache) error
 }
 
 func (m *MemCache) CreateDag(dag *entity.Dag) error {
+	if err := dag.Validate(); err != nil {
+		return fmt.Errorf("dag[%s] is invalid: %w", dag.ID, err)
+	}
+	if err := mod.CheckSubDagCycle(dag); err != nil {
+		return fmt.Errorf("dag[%s] is invalid: %w", dag.ID, err)
+	}
 	if dag.ID == "" {
 		dag.ID = store.NextStringID()
 	}
@@ -58,6 +90,12 @@ func (m *MemCache) CreateDag(dag *entity.Dag) error {
 }
 
 func (m *MemCache) UpdateDag(dag *entity.Dag) error {
+	if err := dag.Validate(); err != nil {
+		return fmt.Errorf("dag[%s] is invalid: %w", dag.ID, err)
+	}
+	if err := mod.CheckSubDagCycle(dag); err != nil {
+		return fmt.Errorf("dag[%s] is invalid: %w", dag.ID, err)
+	}
 	return m.updateItem(dag.ID, dag, m.dags)
 }
 
@@ -70,14 +108,53 @@ func (m *MemCache) GetDag(dagId string) (*entity.Dag, error) {
 	return nil, data.ErrDataNotFound
 }
 
+func (m *MemCache) ListDag(input *mod.ListDagInput) ([]*entity.Dag, error) {
+	var dagList []*entity.Dag
+	for _, item := range m.dags.Items() {
+		dag, ok := item.Object.(*entity.Dag)
+		if !ok {
+			continue
+		}
+		if len(input.Status) > 0 && !utils.ConsumerContains(input.Status, dag.Status) {
+			continue
+		}
+		dagList = append(dagList, dag)
+	}
+	return dagList, nil
+}
+
 func (m *MemCache) CreateDagIns(dagIns *entity.DagInstance) error {
 	if dagIns.ID == "" {
 		dagIns.ID = store.NextStringID()
 	}
+	if dagIns.CreatedAt == 0 {
+		dagIns.CreatedAt = time.Now().UnixMilli()
+	}
 	return m.createItem(dagIns.ID, dagIns, m.dagIns)
 }
 
 func (m *MemCache) PatchDagIns(dagIns *entity.DagInstance, mustsPatchFields ...string) error {
+	m.dagInsMutex.Lock()
+	defer m.dagInsMutex.Unlock()
+	return m.patchDagIns(dagIns, mustsPatchFields...)
+}
+
+// PatchDagInsIfOwned patch the dag instance only when it is owned by the worker
+func (m *MemCache) PatchDagInsIfOwned(worker string, dagIns *entity.DagInstance, mustsPatchFields ...string) (bool, error) {
+	m.dagInsMutex.Lock()
+	defer m.dagInsMutex.Unlock()
+
+	old, found := m.dagIns.Get(dagIns.ID)
+	if !found {
+		return false, data.ErrDataNotFound
+	}
+	if old.(*entity.DagInstance).Worker != worker {
+		return false, nil
+	}
+	return true, m.patchDagIns(dagIns, mustsPatchFields...)
+}
+
+func (m *MemCache) patchDagIns(dagIns *entity.DagInstance, mustsPatchFields ...string) error {
 	// Get the existing DagInstance
 	oldDagInsInterface, found := m.dagIns.Get(dagIns.ID)
 	if !found {
@@ -90,7 +167,7 @@ func (m *MemCache) PatchDagIns(dagIns *entity.DagInstance, mustsPatchFields ...s
 	}
 
 	// Use reflection to patch fields
-	filedSlice := []string{"Status", "Reason", "Cmd", "ShareData"}
+	filedSlice := []string{"Worker", "Status", "Reason", "ShareData", "StartedAt", "SLAMissedAt"}
 	dagInsValue := reflect.ValueOf(dagIns).Elem()
 	oldDagInsValue := reflect.ValueOf(oldDagIns).Elem()
 
@@ -101,7 +178,7 @@ func (m *MemCache) PatchDagIns(dagIns *entity.DagInstance, mustsPatchFields ...s
 		// Check that the fields are valid
 		if oldField.IsValid() && newField.IsValid() {
 			switch fieldName {
-			case "Status", "Reason": // string fields
+			case "Worker", "Status", "Reason": // string fields
 				if newField.String() != "" {
 					oldField.Set(newField)
 				}
@@ -109,8 +186,8 @@ func (m *MemCache) PatchDagIns(dagIns *entity.DagInstance, mustsPatchFields ...s
 				if !newField.IsNil() {
 					oldField.Set(newField)
 				}
-			case "Cmd": // map field
-				if utils.StringsContain(mustsPatchFields, "Cmd") || !newField.IsNil() {
+			case "StartedAt", "SLAMissedAt": // int fields
+				if newField.Int() != 0 {
 					oldField.Set(newField)
 				}
 			}
@@ -155,16 +232,28 @@ func (m *MemCache) ListDagInstance(input *mod.ListDagInstanceInput) ([]*entity.D
 		if input.DagID != "" && dagIns.DagID != input.DagID {
 			continue
 		}
+		if input.Worker != "" && dagIns.Worker != input.Worker {
+			continue
+		}
+		if input.Trigger != "" && dagIns.Trigger != input.Trigger {
+			continue
+		}
 
 		if len(input.Status) > 0 && !utils.ConsumerContains(input.Status, dagIns.Status) {
 			continue
 		}
-
 		if input.HasCmd && dagIns.Cmd == nil {
 			continue
 		}
 
-		// other checks for UpdatedEnd, Status, HasCmd, Limit, Offset
+		if input.ParentDagInsID != "" && dagIns.ParentDagInsID != input.ParentDagInsID {
+			continue
+		}
+		if input.ParentTaskInsID != "" && dagIns.ParentTaskInsID != input.ParentTaskInsID {
+			continue
+		}
+
+		// other checks for UpdatedEnd, Status, Limit, Offset
 		dagInsList = append(dagInsList, dagIns)
 	}
 	return dagInsList, nil
@@ -198,7 +287,7 @@ func (m *MemCache) PatchTaskIns(taskIns *entity.TaskInstance) error {
 	// Use reflection to patch fields
 	taskInsValue := reflect.ValueOf(taskIns).Elem()
 	oldTaskInsValue := reflect.ValueOf(oldTaskIns).Elem()
-	filedSlice := []string{"Status", "Reason", "Traces"}
+	filedSlice := []string{"Status", "Reason", "Traces", "Attempt", "Attempts", "RetryAt", "Branches", "Output"}
 
 	for _, fieldName := range filedSlice {
 		oldField := oldTaskInsValue.FieldByName(fieldName)
@@ -211,10 +300,18 @@ func (m *MemCache) PatchTaskIns(taskIns *entity.TaskInstance) error {
 				if newField.String() != "" {
 					oldField.Set(newField)
 				}
-			case "Traces": // slice field
+			case "Traces", "Attempts", "Output": // slice or map field
 				if newField.Len() > 0 {
 					oldField.Set(newField)
 				}
+			case "Branches": // empty branches means no downstream task is chosen, only nil is ignored
+				if !newField.IsNil() {
+					oldField.Set(newField)
+				}
+			case "Attempt", "RetryAt": // int fields
+				if newField.Int() != 0 {
+					oldField.Set(newField)
+				}
 			}
 		}
 	}
@@ -238,6 +335,14 @@ func (m *MemCache) BatchUpdateTaskIns(taskIns []*entity.TaskInstance) error {
 	return nil
 }
 
+// BatchDeleteTaskIns delete the task instances, it is used to expand the mapped task again
+func (m *MemCache) BatchDeleteTaskIns(taskInsIds []string) error {
+	for _, id := range taskInsIds {
+		m.taskIns.Delete(id)
+	}
+	return nil
+}
+
 func (m *MemCache) GetTaskIns(taskIns string) (*entity.TaskInstance, error) {
 	if taskIns, found := m.taskIns.Get(taskIns); found {
 		return taskIns.(*entity.TaskInstance), nil
@@ -267,6 +372,108 @@ func (m *MemCache) ListTaskInstance(input *mod.ListTaskInstanceInput) ([]*entity
 	return taskInsList, nil
 }
 
+func (m *MemCache) CreateCommand(cmd *entity.Command) error {
+	if cmd.ID == "" {
+		cmd.ID = store.NextStringID()
+	}
+	return m.createItem(cmd.ID, copyCommand(cmd), m.cmds)
+}
+
+func (m *MemCache) PatchCommand(cmd *entity.Command) error {
+	oldCmd, err := m.GetCommand(cmd.ID)
+	if err != nil {
+		return err
+	}
+
+	// oldCmd is a copy, so the command which is being read will not be changed
+	if cmd.Status != "" {
+		oldCmd.Status = cmd.Status
+	}
+	oldCmd.Result = cmd.Result
+	if cmd.ExecutedAt != 0 {
+		oldCmd.ExecutedAt = cmd.ExecutedAt
+	}
+	// the executed command will never be executed again, only keep it for a while
+	expiration := cache.NoExpiration
+	if oldCmd.Status != entity.CommandStatusPending {
+		expiration = m.executedCmdTTL
+	}
+	m.cmds.Set(cmd.ID, oldCmd, expiration)
+	return nil
+}
+
+// GetCommand return a copy of command, it will not be changed by the executing
+func (m *MemCache) GetCommand(cmdId string) (*entity.Command, error) {
+	if cmd, found := m.cmds.Get(cmdId); found {
+		return copyCommand(cmd.(*entity.Command)), nil
+	}
+	return nil, data.ErrDataNotFound
+}
+
+func (m *MemCache) ListCommand(input *mod.ListCommandInput) ([]*entity.Command, error) {
+	var cmdList []*entity.Command
+	for _, item := range m.cmds.Items() {
+		cmd, ok := item.Object.(*entity.Command)
+		if !ok {
+			continue
+		}
+		if input.DagInsID != "" && cmd.DagInsID != input.DagInsID {
+			continue
+		}
+		if len(input.Status) > 0 && !utils.ConsumerContains(input.Status, cmd.Status) {
+			continue
+		}
+		cmdList = append(cmdList, copyCommand(cmd))
+	}
+	// the ids are increasing, so they decide the order of commands created at the same time
+	sort.Slice(cmdList, func(i, j int) bool {
+		if cmdList[i].CreatedAt != cmdList[j].CreatedAt {
+			return cmdList[i].CreatedAt < cmdList[j].CreatedAt
+		}
+		return cmdList[i].ID < cmdList[j].ID
+	})
+	return cmdList, nil
+}
+
+func copyCommand(cmd *entity.Command) *entity.Command {
+	copied := *cmd
+	copied.TargetTaskInsIDs = append([]string(nil), cmd.TargetTaskInsIDs...)
+	return &copied
+}
+
+func (m *MemCache) Heartbeat(node *entity.Node) error {
+	m.nodes.Set(node.Key, node, cache.NoExpiration)
+	return nil
+}
+
+func (m *MemCache) ListNode() ([]*entity.Node, error) {
+	var nodeList []*entity.Node
+	for _, item := range m.nodes.Items() {
+		node, ok := item.Object.(*entity.Node)
+		if !ok {
+			continue
+		}
+		nodeList = append(nodeList, node)
+	}
+	return nodeList, nil
+}
+
+func (m *MemCache) CampaignLeader(workerKey string, leaseTime time.Duration) (bool, error) {
+	m.leaderMutex.Lock()
+	defer m.leaderMutex.Unlock()
+
+	now := time.Now()
+	// the lease is held by another worker and not expired
+	if m.leader.workerKey != "" && m.leader.workerKey != workerKey && now.Before(m.leader.expiredAt) {
+		return false, nil
+	}
+	m.leader = leaderLease{
+		workerKey: workerKey,
+		expiredAt: now.Add(leaseTime),
+	}
+	return true, nil
+}
+
 func (m *MemCache) Marshal(obj interface{}) ([]byte, error) {
 	// 结构体序列化为[]byte
 	return json.Marshal(obj)
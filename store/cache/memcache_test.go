@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weeyp/fastflow/pkg/entity"
+	"github.com/weeyp/fastflow/pkg/mod"
+	"github.com/weeyp/fastflow/pkg/utils/data"
+)
+
+func TestMemCache_ListCommand(t *testing.T) {
+	m := NewMemCache()
+	cmds := []*entity.Command{
+		{DagInsID: "ins1", Name: entity.CommandNamePause, Status: entity.CommandStatusPending, CreatedAt: 2},
+		{DagInsID: "ins2", Name: entity.CommandNamePause, Status: entity.CommandStatusPending, CreatedAt: 2},
+		{DagInsID: "ins1", Name: entity.CommandNameResume, Status: entity.CommandStatusPending, CreatedAt: 3},
+		{DagInsID: "ins1", Name: entity.CommandNameRetry, Status: entity.CommandStatusSuccess, CreatedAt: 1},
+	}
+	for _, cmd := range cmds {
+		assert.NoError(t, m.CreateCommand(cmd))
+	}
+
+	tests := []struct {
+		caseDesc  string
+		giveInput *mod.ListCommandInput
+		wantCmds  []*entity.Command
+	}{
+		{
+			caseDesc:  "all",
+			giveInput: &mod.ListCommandInput{},
+			wantCmds:  []*entity.Command{cmds[3], cmds[0], cmds[1], cmds[2]},
+		},
+		{
+			caseDesc:  "pending",
+			giveInput: &mod.ListCommandInput{Status: []entity.CommandStatus{entity.CommandStatusPending}},
+			wantCmds:  []*entity.Command{cmds[0], cmds[1], cmds[2]},
+		},
+		{
+			caseDesc: "pending of dag instance",
+			giveInput: &mod.ListCommandInput{
+				DagInsID: "ins1",
+				Status:   []entity.CommandStatus{entity.CommandStatusPending},
+			},
+			wantCmds: []*entity.Command{cmds[0], cmds[2]},
+		},
+	}
+	for _, tc := range tests {
+		ret, err := m.ListCommand(tc.giveInput)
+		assert.NoError(t, err, tc.caseDesc)
+		assert.Equal(t, tc.wantCmds, ret, tc.caseDesc)
+	}
+}
+
+func TestMemCache_CommandIsCopied(t *testing.T) {
+	m := NewMemCache()
+	cmd := &entity.Command{DagInsID: "ins1", Status: entity.CommandStatusPending, TargetTaskInsIDs: []string{"task1"}}
+	assert.NoError(t, m.CreateCommand(cmd))
+	cmd.Status = entity.CommandStatusSuccess
+
+	listed, err := m.ListCommand(&mod.ListCommandInput{})
+	assert.NoError(t, err)
+	assert.Len(t, listed, 1)
+	listed[0].Complete(nil)
+	listed[0].TargetTaskInsIDs[0] = "task2"
+
+	got, err := m.GetCommand(cmd.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.CommandStatusPending, got.Status)
+	assert.Equal(t, []string{"task1"}, got.TargetTaskInsIDs)
+
+	assert.NoError(t, m.PatchCommand(listed[0]))
+	assert.Equal(t, entity.CommandStatusPending, got.Status)
+	got, err = m.GetCommand(cmd.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.CommandStatusSuccess, got.Status)
+	assert.Equal(t, []string{"task1"}, got.TargetTaskInsIDs)
+}
+
+func TestMemCache_ExecutedCommandExpired(t *testing.T) {
+	m := NewMemCache()
+	m.executedCmdTTL = 10 * time.Millisecond
+	pending := &entity.Command{DagInsID: "ins1", Status: entity.CommandStatusPending}
+	executed := &entity.Command{DagInsID: "ins1", Status: entity.CommandStatusPending}
+	assert.NoError(t, m.CreateCommand(pending))
+	assert.NoError(t, m.CreateCommand(executed))
+
+	executed.Complete(nil)
+	assert.NoError(t, m.PatchCommand(executed))
+	_, err := m.GetCommand(executed.ID)
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = m.GetCommand(executed.ID)
+	assert.Error(t, err)
+	cmds, err := m.ListCommand(&mod.ListCommandInput{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*entity.Command{pending}, cmds)
+}
+
+func TestMemCache_PatchTaskInsBranches(t *testing.T) {
+	m := NewMemCache()
+	taskIns := &entity.TaskInstance{Status: entity.TaskInstanceStatusRunning}
+	assert.NoError(t, m.BatchCreatTaskIns([]*entity.TaskInstance{taskIns}))
+
+	// nil branches are not patched
+	assert.NoError(t, m.PatchTaskIns(&entity.TaskInstance{ID: taskIns.ID, Status: entity.TaskInstanceStatusRunning}))
+	got, err := m.GetTaskIns(taskIns.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got.Branches)
+
+	// the branch task chooses nothing
+	assert.NoError(t, m.PatchTaskIns(&entity.TaskInstance{
+		ID:       taskIns.ID,
+		Status:   entity.TaskInstanceStatusSuccess,
+		Branches: []string{},
+	}))
+	got, err = m.GetTaskIns(taskIns.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, got.Branches)
+	assert.Empty(t, got.Branches)
+
+	// it is kept after serializing by store
+	b, err := m.Marshal(got)
+	assert.NoError(t, err)
+	loaded := &entity.TaskInstance{}
+	assert.NoError(t, m.Unmarshal(b, loaded))
+	assert.NotNil(t, loaded.Branches)
+	assert.Empty(t, loaded.Branches)
+}
+
+func TestMemCache_ValidateDag(t *testing.T) {
+	m := NewMemCache()
+	valid := &entity.Dag{ID: "dag", Tasks: []entity.Task{{ID: "task1", ActionName: "action"}}}
+	assert.NoError(t, m.CreateDag(valid))
+
+	invalid := &entity.Dag{
+		ID: "invalid",
+		Tasks: []entity.Task{{
+			ID:         "task1",
+			ActionName: "action",
+			PreChecks: entity.PreChecks{
+				"check": {Act: entity.ActiveActionSkip, When: "vars.env =="},
+			},
+		}},
+	}
+	err := m.CreateDag(invalid)
+	assert.Error(t, err)
+	_, err = m.GetDag(invalid.ID)
+	assert.Error(t, err)
+
+	invalid.ID = valid.ID
+	assert.Error(t, m.UpdateDag(invalid))
+	got, err := m.GetDag(valid.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, valid, got)
+}
+
+func TestMemCache_PatchDagInsIfOwned(t *testing.T) {
+	m := NewMemCache()
+	dagIns := &entity.DagInstance{ID: "ins", Worker: "w1", Status: entity.DagInstanceStatusRunning}
+	assert.NoError(t, m.CreateDagIns(dagIns))
+
+	patched, err := m.PatchDagInsIfOwned("w2", &entity.DagInstance{ID: dagIns.ID, Status: entity.DagInstanceStatusSuccess})
+	assert.NoError(t, err)
+	assert.False(t, patched)
+	got, err := m.GetDagInstance(dagIns.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.DagInstanceStatusRunning, got.Status)
+
+	patched, err = m.PatchDagInsIfOwned("w1", &entity.DagInstance{ID: dagIns.ID, Worker: "w2", Status: entity.DagInstanceStatusInit})
+	assert.NoError(t, err)
+	assert.True(t, patched)
+	got, err = m.GetDagInstance(dagIns.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "w2", got.Worker)
+	assert.Equal(t, entity.DagInstanceStatusInit, got.Status)
+
+	_, err = m.PatchDagInsIfOwned("w1", &entity.DagInstance{ID: "not-found"})
+	assert.Equal(t, data.ErrDataNotFound, err)
+}